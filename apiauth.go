@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	principalKey = "principal"
+	allScope     = "*"
+	keyHashType  = "sha256:"
+	scopeRead    = "read"
+	scopeWrite   = "write"
+)
+
+// routeGroups are the route groups that access can be granted to.
+var routeGroups = []string{"infrastructure", "virtualization", "inventory", "metrics", "status"}
+
+// AuthConfig configures how consumers of the API authenticate. Without any
+// keys, OIDC issuer or client certificate identities configured the API is
+// open, as in earlier versions.
+type AuthConfig struct {
+	Keys        []APIKey             `json:"keys" yaml:"keys"`
+	OIDC        OIDCConfig           `json:"oidc" yaml:"oidc"`
+	ClientCerts []ClientCertIdentity `json:"clientCerts" yaml:"clientCerts"`
+}
+
+// APIKey is a static bearer key. Only the SHA-256 hash of the key is kept in
+// the config, as "sha256:<hex>". Without Scopes the key can only read.
+type APIKey struct {
+	Name    string   `json:"name" yaml:"name"`
+	Hash    string   `json:"hash" yaml:"hash"`
+	Parents []string `json:"parents" yaml:"parents"`
+	Routes  []string `json:"routes" yaml:"routes"`
+	Scopes  []string `json:"scopes" yaml:"scopes"`
+}
+
+func (a *AuthConfig) enabled() bool {
+	return len(a.Keys) > 0 || a.OIDC.enabled() || len(a.ClientCerts) > 0
+}
+
+func (a *AuthConfig) applyDefaults() {
+	for i := range a.Keys {
+		if len(a.Keys[i].Scopes) == 0 {
+			a.Keys[i].Scopes = []string{scopeRead}
+		}
+	}
+	for i := range a.ClientCerts {
+		if len(a.ClientCerts[i].Scopes) == 0 {
+			a.ClientCerts[i].Scopes = []string{scopeRead}
+		}
+	}
+	a.OIDC.applyDefaults()
+}
+
+func (a *AuthConfig) validate(parents []PVEConnectionObject) error {
+	names := make(map[string]bool)
+	for i, key := range a.Keys {
+		if key.Name == "" {
+			return fmt.Errorf("API key #%d has no name", i+1)
+		}
+		if names[key.Name] {
+			return fmt.Errorf("API key %s is defined more than once", key.Name)
+		}
+		names[key.Name] = true
+		if _, err := key.digest(); err != nil {
+			return err
+		}
+		if err := validateScopes(key.Name, key.Parents, key.Routes, parents); err != nil {
+			return err
+		}
+		if err := validateAccessScopes(key.Name, key.Scopes); err != nil {
+			return err
+		}
+	}
+	if err := validateClientCerts(a.ClientCerts, parents); err != nil {
+		return err
+	}
+	return a.OIDC.validate(parents)
+}
+
+func validateAccessScopes(name string, scopes []string) error {
+	for _, s := range scopes {
+		if s != scopeRead && s != scopeWrite && s != allScope {
+			return fmt.Errorf("%s grants the unknown scope %s - use %s or %s", name, s, scopeRead, scopeWrite)
+		}
+	}
+	return nil
+}
+
+// validateScopes checks that the route groups granted to an identity exist,
+// so a typo does not silently lock a caller out. Unknown parents are only
+// logged - removing a parent must not fail the reload of the whole config,
+// and a grant for a parent that does not exist matches nothing.
+func validateScopes(name string, allowedParents, routes []string, parents []PVEConnectionObject) error {
+	for _, p := range allowedParents {
+		if p == allScope {
+			continue
+		}
+		if !slices.ContainsFunc(parents, func(c PVEConnectionObject) bool { return c.Parent == p }) {
+			slog.Warn("Ignoring an unknown parent in the access grants", "identity", name, "parent", p)
+		}
+	}
+	for _, r := range routes {
+		if r != allScope && !slices.Contains(routeGroups, r) {
+			return fmt.Errorf("%s grants access to the unknown route group %s - use one of %s", name, r, strings.Join(routeGroups, ", "))
+		}
+	}
+	return nil
+}
+
+func (k APIKey) digest() ([]byte, error) {
+	if !strings.HasPrefix(k.Hash, keyHashType) {
+		return nil, fmt.Errorf("the hash of API key %s must start with %q", k.Name, keyHashType)
+	}
+	digest, err := hex.DecodeString(strings.TrimPrefix(k.Hash, keyHashType))
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("the hash of API key %s is not a hex encoded SHA-256 hash", k.Name)
+	}
+	return digest, nil
+}
+
+// principal is an authenticated caller and what it may access. An empty
+// Parents, Routes or Scopes list grants nothing, "*" grants everything.
+type principal struct {
+	Name    string
+	Method  string
+	Parents []string
+	Routes  []string
+	Scopes  []string
+}
+
+var (
+	// anonymous is used for every caller when authentication is disabled
+	anonymous = &principal{Name: "anonymous", Method: "none", Parents: []string{allScope}, Routes: []string{allScope}, Scopes: []string{allScope}}
+	// nobody is used when a handler runs without the authenticate middleware
+	nobody = &principal{Name: "nobody", Method: "none"}
+)
+
+func (p *principal) allowsParent(parent string) bool {
+	return slices.Contains(p.Parents, allScope) || slices.Contains(p.Parents, parent)
+}
+
+func (p *principal) allowsRoute(group string) bool {
+	return slices.Contains(p.Routes, allScope) || slices.Contains(p.Routes, group)
+}
+
+func (p *principal) allowsScope(scope string) bool {
+	return slices.Contains(p.Scopes, allScope) || slices.Contains(p.Scopes, scope)
+}
+
+// requiredScope returns the scope needed for the method of a request.
+func requiredScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return scopeRead
+	}
+	return scopeWrite
+}
+
+// authenticator is one way for callers to prove who they are. It returns nil
+// without an error when the request does not carry its kind of credential,
+// so the next authenticator can be tried.
+type authenticator interface {
+	authenticate(r *http.Request, cfg *Config) (*principal, error)
+}
+
+// authenticators are tried in order for every request.
+var authenticators = []authenticator{
+	clientCertAuthenticator{},
+	apiKeyAuthenticator{},
+	oidcAuthenticator{},
+}
+
+type apiKeyAuthenticator struct{}
+
+func (apiKeyAuthenticator) authenticate(r *http.Request, cfg *Config) (*principal, error) {
+	token, ok := bearerToken(r)
+	if !ok || len(cfg.Auth.Keys) == 0 {
+		return nil, nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	for _, key := range cfg.Auth.Keys {
+		digest, err := key.digest()
+		if err != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare(sum[:], digest) == 1 {
+			return &principal{Name: key.Name, Method: "apiKey", Parents: key.Parents, Routes: key.Routes, Scopes: key.Scopes}, nil
+		}
+	}
+	return nil, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authenticate identifies the caller and stores the principal on the context.
+func authenticate(c *gin.Context) {
+	cfg := requestConfig(c)
+	if !cfg.Auth.enabled() {
+		c.Set(principalKey, anonymous)
+		c.Next()
+		return
+	}
+
+	for _, a := range authenticators {
+		p, err := a.authenticate(c.Request, cfg)
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "Rejected the credentials", "client_ip", c.ClientIP(), "error", err)
+			break
+		}
+		if p != nil {
+			c.Set(principalKey, p)
+			c.Next()
+			return
+		}
+	}
+
+	c.Header("WWW-Authenticate", `Bearer realm="proxmox-simple-api"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "A valid bearer token or client certificate is required"})
+}
+
+// authorizeRoute only lets callers through that were granted the route group.
+func authorizeRoute(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !principalFrom(c).allowsRoute(group) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Access to the %s routes is not granted", group)})
+			return
+		}
+		c.Next()
+	}
+}
+
+func principalFrom(c *gin.Context) *principal {
+	if v, ok := c.Get(principalKey); ok {
+		if p, ok := v.(*principal); ok {
+			return p
+		}
+	}
+	return nobody
+}
+
+// parentsFor returns the configured parents the caller may access with the
+// method of the request.
+func parentsFor(c *gin.Context) []PVEConnectionObject {
+	p := principalFrom(c)
+	if !p.allowsScope(requiredScope(c.Request.Method)) {
+		return nil
+	}
+	var parents []PVEConnectionObject
+	for _, host := range requestConfig(c).Parents {
+		if p.allowsParent(host.Parent) {
+			parents = append(parents, host)
+		}
+	}
+	return parents
+}
+
+// parentFor looks up a parent by name. Parents the caller may not access are
+// reported as not found, so their names are not disclosed.
+func parentFor(c *gin.Context, name string) (PVEConnectionObject, bool) {
+	p := principalFrom(c)
+	host, found := requestConfig(c).findParent(name)
+	if !found || !p.allowsParent(name) || !p.allowsScope(requiredScope(c.Request.Method)) {
+		return PVEConnectionObject{}, false
+	}
+	return host, true
+}
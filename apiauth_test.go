@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ksl28/proxmox-simple-api/pve/pvetest"
+)
+
+// keyHash returns the config form of the hash of an API key.
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return keyHashType + hex.EncodeToString(sum[:])
+}
+
+// withKeys configures a second parent and API keys for the fake parent.
+func withKeys(cfg *Config) {
+	second := cfg.Parents[0]
+	second.Parent = "lab2"
+	cfg.Parents = append(cfg.Parents, second)
+	cfg.Auth.Keys = []APIKey{
+		{Name: "all", Hash: keyHash("all-key"), Parents: []string{"*"}, Routes: []string{"*"}},
+		{Name: "lab2-infra", Hash: keyHash("lab2-key"), Parents: []string{"lab2"}, Routes: []string{"infrastructure", "metrics"}},
+		{Name: "removed", Hash: keyHash("removed-key"), Parents: []string{"decommissioned"}, Routes: []string{"*"}},
+	}
+}
+
+func keyRequest(router http.Handler, path, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIKeyRouteGroups(t *testing.T) {
+	_, router := newFakeParent(t, withKeys)
+
+	tests := []struct {
+		name   string
+		key    string
+		path   string
+		status int
+	}{
+		{"no key", "", "/api/v1/infrastructure/nodes/summary", http.StatusUnauthorized},
+		{"wrong key", "guess", "/api/v1/infrastructure/nodes/summary", http.StatusUnauthorized},
+		{"granted group", "lab2-key", "/api/v1/infrastructure/nodes/summary", http.StatusOK},
+		{"other group", "lab2-key", "/api/v1/virtualization/vm/summary", http.StatusForbidden},
+		{"all groups", "all-key", "/api/v1/virtualization/vm/summary", http.StatusOK},
+		{"health", "", "/health", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := keyRequest(router, tt.path, tt.key); rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d - %s", tt.name, tt.status, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestAPIKeyParentScopes(t *testing.T) {
+	_, router := newFakeParent(t, withKeys)
+
+	parents := func(key string) []string {
+		rec := keyRequest(router, "/api/v1/infrastructure/nodes/summary", key)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d - %s", rec.Code, rec.Body.String())
+		}
+		var res NodeSummaryResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, n := range res.Data {
+			if !slices.Contains(names, n.Parent) {
+				names = append(names, n.Parent)
+			}
+		}
+		return names
+	}
+
+	if got := parents("all-key"); !slices.Equal(got, []string{"lab", "lab2"}) {
+		t.Errorf("expected both parents for the unscoped key, got %v", got)
+	}
+	if got := parents("lab2-key"); !slices.Equal(got, []string{"lab2"}) {
+		t.Errorf("expected only lab2 for the scoped key, got %v", got)
+	}
+	// A grant for a parent that is no longer configured is ignored instead of
+	// failing the config, and matches nothing.
+	if got := parents("removed-key"); len(got) != 0 {
+		t.Errorf("expected no parents for the key of a removed parent, got %v", got)
+	}
+
+	if rec := keyRequest(router, "/api/v1/infrastructure/nodes/detailed/lab", "lab2-key"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected a parent outside the scope to be reported as not found, got %d", rec.Code)
+	}
+	if rec := keyRequest(router, "/api/v1/infrastructure/nodes/detailed/lab2", "lab2-key"); rec.Code != http.StatusOK {
+		t.Errorf("expected the scoped parent to be readable, got %d - %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUnknownRouteGroupIsRejected(t *testing.T) {
+	cfg := Config{Parents: []PVEConnectionObject{{Parent: "lab", Token: "monitor@pve!test=secret", Endpoints: []string{"127.0.0.1"}}}}
+	cfg.Auth.Keys = []APIKey{{Name: "typo", Hash: keyHash("typo-key"), Parents: []string{"lab"}, Routes: []string{"infrastucture"}}}
+	cfg.applyDefaults()
+	if err := cfg.validate(); err == nil {
+		t.Error("expected a key with an unknown route group to be rejected")
+	}
+}
+
+func TestMetricsAreScopedToTheParentsOfTheKey(t *testing.T) {
+	srv, router := newFakeParent(t, withKeys)
+	srv.Inject("/cluster/resources", pvetest.Fault{Status: http.StatusForbidden, Message: "Permission check failed"})
+	// Calls to both parents create upstream series for both
+	keyRequest(router, "/api/v1/virtualization/vm/summary", "all-key")
+
+	all := keyRequest(router, "/metrics", "all-key").Body.String()
+	for _, want := range []string{
+		`pve_api_upstream_requests_total{code="200",endpoint="` + srv.Address() + `",parent="lab"}`,
+		`pve_api_upstream_requests_total{code="200",endpoint="` + srv.Address() + `",parent="lab2"}`,
+		`pve_api_upstream_errors_total{parent="lab",reason="authenticate"}`,
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("expected the unscoped metrics to contain %s", want)
+		}
+	}
+
+	scoped := keyRequest(router, "/metrics", "lab2-key").Body.String()
+	if strings.Contains(scoped, `parent="lab"`) {
+		t.Errorf("expected no series of lab for a key scoped to lab2")
+	}
+	if !strings.Contains(scoped, `parent="lab2"`) || !strings.Contains(scoped, "pve_api_http_requests_total") {
+		t.Errorf("expected the series of lab2 and of the API itself")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Proxmox tickets are valid for two hours - they are renewed well before.
+	ticketRenewAfter = 90 * time.Minute
+	csrfHeader       = "CSRFPreventionToken"
+	authCookie       = "PVEAuthCookie"
+)
+
+// authError marks credentials the parent rejected, or that cannot be read,
+// so they are reported apart from network and TLS errors and not retried. A
+// login that fails on the way to the parent is not an authError.
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string {
+	return e.err.Error()
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+type ticket struct {
+	ticket   string
+	csrf     string
+	obtained time.Time
+}
+
+// authTransport adds the credentials of a parent to every request - either a
+// static API token, or a ticket obtained through /access/ticket.
+type authTransport struct {
+	base http.RoundTripper
+
+	mu sync.Mutex
+	// host is replaced by setHost when the credentials change on a reload
+	host    PVEConnectionObject
+	current *ticket
+}
+
+// setHost applies the settings of the parent after a reload. A cached ticket
+// is dropped when the login changed.
+func (t *authTransport) setHost(host PVEConnectionObject) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old := t.host
+	if old.Username != host.Username || old.Password != host.Password || old.PasswordFile != host.PasswordFile || old.PasswordEnv != host.PasswordEnv ||
+		old.TotpSecret != host.TotpSecret || old.TotpSecretFile != host.TotpSecretFile || old.TotpSecretEnv != host.TotpSecretEnv {
+		t.current = nil
+	}
+	t.host = host
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	host := t.host
+	t.mu.Unlock()
+
+	if host.Username == "" {
+		token, err := resolveToken(host)
+		if err != nil {
+			return nil, &authError{err: err}
+		}
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", token)
+		return t.base.RoundTrip(req)
+	}
+
+	tk, err := t.ticketFor(req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.base.RoundTrip(withTicket(req, tk))
+	if err != nil || res.StatusCode != http.StatusUnauthorized || req.Method != http.MethodGet {
+		return res, err
+	}
+
+	// The ticket may have been revoked or the parent restarted - log in again
+	// once before giving up.
+	res.Body.Close()
+	t.invalidate(tk)
+	tk, err = t.ticketFor(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(withTicket(req, tk))
+}
+
+func withTicket(req *http.Request, tk *ticket) *http.Request {
+	req = req.Clone(req.Context())
+	req.AddCookie(&http.Cookie{Name: authCookie, Value: tk.ticket})
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		req.Header.Set(csrfHeader, tk.csrf)
+	}
+	return req
+}
+
+func (t *authTransport) invalidate(tk *ticket) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current == tk {
+		t.current = nil
+	}
+}
+
+// ticketFor returns a valid ticket, logging in or renewing it when needed.
+// A rejected login is returned as an authError, network errors as they are.
+// Concurrent requests wait for the same login instead of each starting one.
+func (t *authTransport) ticketFor(req *http.Request) (*ticket, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current != nil && time.Since(t.current.obtained) < ticketRenewAfter {
+		return t.current, nil
+	}
+
+	if t.current != nil {
+		// A valid ticket can be exchanged for a new one without the password
+		// or a second factor.
+		if tk, err := t.requestTicket(req, url.Values{"username": {t.host.Username}, "password": {t.current.ticket}}); err == nil {
+			t.current = &tk.ticket
+			return t.current, nil
+		}
+	}
+
+	tk, err := t.login(req)
+	if err != nil {
+		t.current = nil
+		return nil, err
+	}
+	t.current = tk
+	return tk, nil
+}
+
+func (t *authTransport) login(req *http.Request) (*ticket, error) {
+	password, err := resolveSecret("password for "+t.host.Parent, t.host.Password, t.host.PasswordFile, t.host.PasswordEnv)
+	if err != nil {
+		return nil, &authError{err: err}
+	}
+	tk, err := t.requestTicket(req, url.Values{"username": {t.host.Username}, "password": {password}})
+	if err != nil {
+		return nil, err
+	}
+	if !tk.needTFA {
+		return &tk.ticket, nil
+	}
+
+	if t.host.totpSources() == 0 {
+		return nil, &authError{err: fmt.Errorf("the login for %s requires a second factor, but no totpSecret is configured", t.host.Parent)}
+	}
+	secret, err := resolveSecret("TOTP secret for "+t.host.Parent, t.host.TotpSecret, t.host.TotpSecretFile, t.host.TotpSecretEnv)
+	if err != nil {
+		return nil, &authError{err: err}
+	}
+	code, err := totpCode(secret, time.Now())
+	if err != nil {
+		return nil, &authError{err: fmt.Errorf("failed to generate the TOTP code for %s - %w", t.host.Parent, err)}
+	}
+	tk, err = t.requestTicket(req, url.Values{
+		"username":      {t.host.Username},
+		"tfa-challenge": {tk.ticket.ticket},
+		"password":      {"totp:" + code},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tk.needTFA {
+		return nil, &authError{err: fmt.Errorf("the second factor for %s was not accepted", t.host.Parent)}
+	}
+	return &tk.ticket, nil
+}
+
+type ticketResponse struct {
+	ticket
+	needTFA bool
+}
+
+// requestTicket posts form to /access/ticket. Errors only carry the status,
+// as the body may echo the submitted credentials.
+func (t *authTransport) requestTicket(req *http.Request, form url.Values) (*ticketResponse, error) {
+	ticketUrl := fmt.Sprintf("https://%s:%d/api2/json/access/ticket", t.host.Parent, t.host.Port)
+	login, err := http.NewRequestWithContext(req.Context(), http.MethodPost, ticketUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	login.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := t.base.RoundTrip(login)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log in to %s - %w", t.host.Parent, err)
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, &authError{err: fmt.Errorf("the login to %s as %s was rejected: %s", t.host.Parent, t.host.Username, res.Status)}
+	default:
+		return nil, fmt.Errorf("the login to %s as %s failed: %s", t.host.Parent, t.host.Username, res.Status)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var decoded struct {
+		Data struct {
+			Ticket  string `json:"ticket"`
+			CSRF    string `json:"CSRFPreventionToken"`
+			NeedTFA any    `json:"NeedTFA"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode the login response from %s - %w", t.host.Parent, err)
+	}
+	if decoded.Data.Ticket == "" {
+		return nil, fmt.Errorf("the login to %s as %s returned no ticket", t.host.Parent, t.host.Username)
+	}
+
+	return &ticketResponse{
+		ticket: ticket{
+			ticket:   decoded.Data.Ticket,
+			csrf:     decoded.Data.CSRF,
+			obtained: time.Now(),
+		},
+		needTFA: decoded.Data.NeedTFA != nil && fmt.Sprint(decoded.Data.NeedTFA) != "0",
+	}, nil
+}
+
+// totpCode returns the RFC 6238 code (SHA-1, 30 seconds, 6 digits) for a
+// base32 secret, which is what Proxmox uses for TOTP.
+func totpCode(secret string, now time.Time) (string, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(now.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
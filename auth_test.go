@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ksl28/proxmox-simple-api/pve/pvetest"
+)
+
+func TestTotpCodeMatchesRFC6238(t *testing.T) {
+	// The SHA-1 vectors of RFC 6238 appendix B, cut to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // base32 of "12345678901234567890"
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := totpCode(secret, time.Unix(unix, 0))
+		if err != nil || got != want {
+			t.Errorf("at %d expected %s, got %s - %v", unix, want, got, err)
+		}
+	}
+	if _, err := totpCode("not base32!", time.Now()); err == nil {
+		t.Errorf("expected an invalid secret to be rejected")
+	}
+}
+
+func TestTicketIsRenewedAndSentWithCSRF(t *testing.T) {
+	fixtures := pvetest.DefaultFixtures()
+	fixtures.Users = map[string]string{"monitor@pve": "secret-password"}
+	srv := pvetest.NewServer(fixtures)
+	t.Cleanup(srv.Close)
+
+	cfg := Config{Parents: []PVEConnectionObject{{Parent: "ticket-lab", Username: "monitor@pve", Password: "secret-password", Endpoints: []string{srv.Address()}, Insecure: true}}}
+	cfg.applyDefaults()
+	pc, err := parentClientFor(cfg.Parents[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := pc.client.Transport.(*retryTransport).base.(*authTransport)
+	call := func(method string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, "https://ticket-lab:8006/api2/json/version", strings.NewReader(""))
+		res, err := pc.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := call(http.MethodGet); status != http.StatusOK || srv.Requests("/access/ticket") != 1 {
+		t.Fatalf("expected one login, got status %d and %d logins", status, srv.Requests("/access/ticket"))
+	}
+	first := auth.current
+
+	// Close to running out, the ticket is exchanged for a new one
+	first.obtained = time.Now().Add(-ticketRenewAfter - time.Minute)
+	if status := call(http.MethodGet); status != http.StatusOK || auth.current == first || srv.Requests("/access/ticket") != 2 {
+		t.Errorf("expected the ticket to be renewed, got status %d", status)
+	}
+
+	// A ticket the cluster no longer knows is replaced by a new login
+	srv.ExpireTickets()
+	if status := call(http.MethodGet); status != http.StatusOK || srv.Requests("/access/ticket") != 3 {
+		t.Errorf("expected a new login after the ticket expired, got status %d", status)
+	}
+
+	// Writes carry the CSRF token - the fake does not implement the call,
+	// but it would answer 401 without the token
+	if status := call(http.MethodPost); status != http.StatusNotImplemented {
+		t.Errorf("expected the write to pass the CSRF check, got status %d", status)
+	}
+	auth.current.csrf = "stale"
+	if status := call(http.MethodPost); status != http.StatusUnauthorized {
+		t.Errorf("expected a wrong CSRF token to be rejected, got status %d", status)
+	}
+}
+
+func TestReloadKeepsTheClientUnlessTheTransportChanges(t *testing.T) {
+	cfg := Config{Parents: []PVEConnectionObject{{Parent: "reload-lab", Token: "monitor@pve!a=first", Endpoints: []string{"127.0.0.1:1"}}}}
+	cfg.applyDefaults()
+	host := cfg.Parents[0]
+	pc, err := parentClientFor(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host.Timeout = Duration(time.Minute)
+	host.Token = "monitor@pve!a=second"
+	if again, _ := parentClientFor(host); again != pc || pc.auth.host.Token != host.Token {
+		t.Errorf("expected the client to be kept with the new token")
+	}
+
+	host.Retries = 3
+	if rebuilt, _ := parentClientFor(host); rebuilt == pc {
+		t.Errorf("expected the client to be rebuilt for new retry settings")
+	}
+}
+
+func TestOnlyRejectedLoginsAreAuthErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		login    func() (*http.Response, error)
+		rejected bool
+	}{
+		"rejected": {func() (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusUnauthorized, Status: "401 authentication failure", Body: http.NoBody}, nil
+		}, true},
+		"unreachable node": {func() (*http.Response, error) {
+			return &http.Response{StatusCode: 595, Status: "595 No route to host", Body: http.NoBody}, nil
+		}, false},
+		"connection refused": {func() (*http.Response, error) {
+			return nil, syscall.ECONNREFUSED
+		}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			auth := &authTransport{
+				base: roundTripFunc(func(*http.Request) (*http.Response, error) { return tc.login() }),
+				host: PVEConnectionObject{Parent: "ticket-lab", Port: 8006, Username: "monitor@pve", Password: "secret-password"},
+			}
+			req, _ := http.NewRequest(http.MethodGet, "https://ticket-lab:8006/api2/json/version", nil)
+			_, err := auth.RoundTrip(req)
+			if err == nil {
+				t.Fatal("expected the login to fail")
+			}
+			if got := errors.As(err, new(*authError)); got != tc.rejected || credentialsRejected(err) != tc.rejected {
+				t.Errorf("expected an authError to be %v, got %v - %v", tc.rejected, got, err)
+			}
+			if retryable(nil, err) == tc.rejected {
+				t.Errorf("expected retryable to be %v - %v", !tc.rejected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksl28/proxmox-simple-api/pve"
+)
+
+type parentClient struct {
+	key       transportKey
+	auth      *authTransport
+	client    *http.Client
+	api       *pve.Client
+	endpoints *endpointSet
+	breaker   *circuitBreaker
+}
+
+var (
+	parentClientsMu sync.Mutex
+	parentClients   = make(map[string]*parentClient)
+)
+
+// transportKey holds the settings of a parent its client is built from. The
+// credentials are swapped in place and the timeout is read per request, so
+// changing them keeps the breaker, limits and last working endpoint.
+type transportKey struct {
+	port             int
+	endpoints        string
+	endpointOrder    string
+	caFile           string
+	fingerprint      string
+	insecure         bool
+	retries          int
+	retryBackoff     Duration
+	breakerThreshold int
+	breakerCooldown  Duration
+	maxConcurrent    int
+	rateLimit        float64
+	rateBurst        int
+	fixtures         FixturesConfig
+}
+
+func (p PVEConnectionObject) transportKey() transportKey {
+	return transportKey{
+		port:             p.Port,
+		endpoints:        strings.Join(p.Endpoints, ","),
+		endpointOrder:    p.EndpointOrder,
+		caFile:           p.CAFile,
+		fingerprint:      p.Fingerprint,
+		insecure:         p.Insecure,
+		retries:          p.Retries,
+		retryBackoff:     p.RetryBackoff,
+		breakerThreshold: p.BreakerThreshold,
+		breakerCooldown:  p.BreakerCooldown,
+		maxConcurrent:    p.MaxConcurrent,
+		rateLimit:        p.RateLimit,
+		rateBurst:        p.RateBurst,
+		fixtures:         p.fixtures,
+	}
+}
+
+// parentClientFor returns the cached client state for a parent. Clients are
+// kept between requests so connections and the last working endpoint are
+// reused, and rebuilt when the transport settings of the parent change on a
+// reload. Changed credentials are applied to the cached client and drop its
+// ticket, so they take effect at once.
+func parentClientFor(host PVEConnectionObject) (*parentClient, error) {
+	parentClientsMu.Lock()
+	defer parentClientsMu.Unlock()
+
+	key := host.transportKey()
+	if pc, ok := parentClients[host.Parent]; ok && pc.key == key {
+		pc.auth.setHost(host)
+		return pc, nil
+	}
+
+	tlsConfig, err := buildTLSConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	endpoints := &endpointSet{
+		addresses: host.endpointAddresses(),
+		ordered:   host.EndpointOrder == endpointOrderOrdered,
+	}
+	breaker := newCircuitBreaker(host)
+	auth := &authTransport{
+		base: newLimitTransport(&failoverTransport{base: transport, parent: host.Parent, endpoints: endpoints}, host),
+		host: host,
+	}
+	var rt http.RoundTripper = &retryTransport{
+		base:    auth,
+		parent:  host.Parent,
+		retries: host.Retries,
+		backoff: time.Duration(host.RetryBackoff),
+		breaker: breaker,
+	}
+	switch host.fixtures.Mode {
+	case fixturesRecord:
+		rt = newRecordTransport(rt, host)
+	case fixturesReplay:
+		rt = &replayTransport{dir: fixturesDir(host)}
+	}
+	pc := &parentClient{
+		key:       key,
+		auth:      auth,
+		client:    &http.Client{Transport: rt},
+		endpoints: endpoints,
+		breaker:   breaker,
+	}
+	pc.api = pve.New(host.Parent, host.Port, pve.WithHTTPClient(pc.client))
+
+	if old, ok := parentClients[host.Parent]; ok {
+		old.client.CloseIdleConnections()
+	}
+	parentClients[host.Parent] = pc
+	return pc, nil
+}
+
+// parentContext bounds the calls to a parent by the timeout of the parent,
+// and cancels them when ctx is done - e.g. when the caller disconnects. The
+// logs written with it name the parent, and its calls are traced under one
+// span that ends with the returned cancel.
+func parentContext(ctx context.Context, host PVEConnectionObject) (context.Context, context.CancelFunc) {
+	ctx, span := startParentSpan(ctx, host.Parent)
+	ctx = withLogAttrs(ctx, slog.String("parent", host.Parent))
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(host.Timeout))
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// clientFor returns the Proxmox client for a parent. Its requests go through
+// the cached HTTP client, so authentication, failover, retries and limits
+// apply to every call.
+func clientFor(host PVEConnectionObject) (*pve.Client, error) {
+	pc, err := parentClientFor(host)
+	if err != nil {
+		return nil, err
+	}
+	return pc.api, nil
+}
+
+// endpointAddresses returns the host:port candidates of a parent. Without
+// any Endpoints the parent name itself is the only candidate.
+func (p PVEConnectionObject) endpointAddresses() []string {
+	if len(p.Endpoints) == 0 {
+		return []string{net.JoinHostPort(p.Parent, strconv.Itoa(p.Port))}
+	}
+	var addresses []string
+	for _, ep := range p.Endpoints {
+		if _, _, err := net.SplitHostPort(ep); err == nil {
+			addresses = append(addresses, ep)
+		} else {
+			addresses = append(addresses, net.JoinHostPort(ep, strconv.Itoa(p.Port)))
+		}
+	}
+	return addresses
+}
+
+// probeParent checks that at least one endpoint of the parent accepts
+// connections, and remembers it as the endpoint to use.
+func probeParent(ctx context.Context, host PVEConnectionObject) (bool, error) {
+	pc, err := parentClientFor(host)
+	if err != nil {
+		return false, err
+	}
+	if host.fixtures.Mode == fixturesReplay {
+		// Replayed parents are never contacted
+		return true, nil
+	}
+
+	var failures []string
+	for _, i := range pc.endpoints.tryOrder() {
+		address := pc.endpoints.addresses[i]
+		h, p, _ := net.SplitHostPort(address)
+		port, _ := strconv.Atoi(p)
+		open, err := testHostPort(ctx, host.Parent, h, port)
+		if open {
+			pc.endpoints.markGood(i)
+			return true, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", address, err))
+		if ctx.Err() != nil {
+			return false, fmt.Errorf("the check of the endpoints was stopped - %w", ctx.Err())
+		}
+	}
+	return false, fmt.Errorf("no endpoint is reachable - %s", strings.Join(failures, "; "))
+}
+
+// parentSource reports the endpoint that last answered for a parent.
+func parentSource(host PVEConnectionObject) ParentSource {
+	source := ParentSource{Parent: host.Parent}
+	if pc, err := parentClientFor(host); err == nil {
+		source.Endpoint = pc.endpoints.current()
+	}
+	return source
+}
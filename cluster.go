@@ -0,0 +1,179 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve"
+)
+
+// clusterOverview reports the cluster and quorum of every parent the caller
+// may read.
+func clusterOverview(c *gin.Context) {
+	var (
+		results []ClusterInfo
+		errors  []ApiError
+		sources []ParentSource
+	)
+
+	// The parents are queried concurrently, every one into its own batch so
+	// the response keeps the order of the config
+	type parentBatch struct {
+		info    ClusterInfo
+		errors  []ApiError
+		reached bool
+	}
+	parents := parentsFor(c)
+	batches := make([]parentBatch, len(parents))
+	var wg sync.WaitGroup
+	for i, host := range parents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := parentContext(c.Request.Context(), host)
+			defer cancel()
+			b := &batches[i]
+			b.info, b.errors, b.reached = liveCluster(ctx, host)
+		}()
+	}
+	wg.Wait()
+
+	for i, batch := range batches {
+		errors = append(errors, batch.errors...)
+		if batch.reached {
+			results = append(results, batch.info)
+			sources = append(sources, parentSource(parents[i]))
+		}
+	}
+
+	c.JSON(http.StatusOK, ClustersResponse{
+		Data:    results,
+		Errors:  requestErrors(c, errors),
+		Sources: sources,
+	})
+}
+
+// parentClusterOverview reports the cluster and quorum of one parent.
+func parentClusterOverview(c *gin.Context) {
+	parentName := c.Param("parent")
+	selectedObj, found := parentFor(c, parentName)
+
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("The parent entered (%s) is not present in the configuration - please adjust.", parentName)})
+		return
+	}
+
+	ctx, cancel := parentContext(c.Request.Context(), selectedObj)
+	defer cancel()
+
+	info, errors, reached := liveCluster(ctx, selectedObj)
+	if !reached {
+		c.JSON(http.StatusInternalServerError, ClustersResponse{
+			Errors: requestErrors(c, errors),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ClustersResponse{
+		Data:    []ClusterInfo{info},
+		Errors:  requestErrors(c, errors),
+		Sources: []ParentSource{parentSource(selectedObj)},
+	})
+}
+
+// liveCluster checks that a parent is reachable and reads its cluster.
+// reached is false when the parent could not be queried at all.
+func liveCluster(ctx context.Context, host PVEConnectionObject) (info ClusterInfo, errors []ApiError, reached bool) {
+	client, err := clientFor(host)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+		return ClusterInfo{}, []ApiError{{Parent: host.Parent, Action: "tlsConfig", Message: err.Error()}}, false
+	}
+	portOpen, err := probeParent(ctx, host)
+	if !portOpen {
+		slog.WarnContext(ctx, "Failed to reach the parent", "port", host.Port, "error", err)
+		return ClusterInfo{}, []ApiError{{
+			Parent:  host.Parent,
+			Action:  errorAction("testHostPort", err),
+			Message: fmt.Sprintf("The parent is not listening on %d - %v", host.Port, err),
+		}}, false
+	}
+	return parentCluster(ctx, client, host)
+}
+
+// parentCluster reads the cluster of a parent from /cluster/status and, when
+// the parent is not standalone, the votes and corosync addresses of the
+// nodes from /cluster/config/nodes. Without the latter the nodes are still
+// listed, without votes or rings.
+func parentCluster(ctx context.Context, client *pve.Client, host PVEConnectionObject) (info ClusterInfo, errors []ApiError, ok bool) {
+	status, err := client.ClusterStatus(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to obtain the cluster status", "error", err)
+		return ClusterInfo{}, []ApiError{{
+			Parent:  host.Parent,
+			Action:  errorAction("getClusterStatus", err),
+			Message: err.Error(),
+		}}, false
+	}
+
+	info = ClusterInfo{Parent: host.Parent, Standalone: true, Quorate: true}
+	for _, s := range status {
+		switch s.Type {
+		case "cluster":
+			info.Standalone = false
+			info.Name = s.Name
+			info.Quorate = s.Quorate == 1
+			info.ConfigVersion = s.Version
+		case "node":
+			info.Nodes = append(info.Nodes, ClusterNodeInfo{
+				Name:   s.Name,
+				NodeID: s.NodeID,
+				Online: s.Online == 1,
+				Local:  s.Local == 1,
+				IP:     s.IP,
+			})
+		}
+	}
+	if info.Standalone {
+		return info, nil, true
+	}
+
+	config, err := client.ClusterConfigNodes(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to obtain the cluster configuration", "error", err)
+		errors = append(errors, ApiError{
+			Parent:  host.Parent,
+			Action:  errorAction("getClusterConfigNodes", err),
+			Message: err.Error(),
+		})
+	}
+	for _, n := range config {
+		i := slices.IndexFunc(info.Nodes, func(node ClusterNodeInfo) bool { return node.Name == n.Node })
+		if i < 0 {
+			// Configured, but not yet seen by corosync
+			info.Nodes = append(info.Nodes, ClusterNodeInfo{Name: n.Node})
+			i = len(info.Nodes) - 1
+		}
+		info.Nodes[i].NodeID = n.NodeID
+		info.Nodes[i].QuorumVotes = n.QuorumVotes
+		info.Nodes[i].RingAddresses = n.Rings
+	}
+	for _, n := range info.Nodes {
+		info.ExpectedVotes += n.QuorumVotes
+		if n.Online {
+			info.TotalVotes += n.QuorumVotes
+		}
+	}
+	if info.ExpectedVotes > 0 {
+		info.Quorum = info.ExpectedVotes/2 + 1
+	}
+	slices.SortFunc(info.Nodes, func(a, b ClusterNodeInfo) int {
+		return cmp.Or(cmp.Compare(a.NodeID, b.NodeID), cmp.Compare(a.Name, b.Name))
+	})
+	return info, errors, true
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultApiPort        = 8080
+	defaultParentPort     = 8006
+	defaultReloadInterval = 10 * time.Second
+	defaultParentTimeout  = 30 * time.Second
+)
+
+// Config is the validated runtime configuration. A snapshot is never modified
+// after it has been published - a reload builds a new one and swaps it in.
+type Config struct {
+	Listen         ListenerConfig        `json:"listen" yaml:"listen"`
+	ReloadInterval Duration              `json:"reloadInterval" yaml:"reloadInterval"`
+	TokenDir       string                `json:"tokenDir" yaml:"tokenDir"`
+	Auth           AuthConfig            `json:"auth" yaml:"auth"`
+	Metrics        MetricsConfig         `json:"metrics" yaml:"metrics"`
+	Inventory      InventoryConfig       `json:"inventory" yaml:"inventory"`
+	Health         HealthConfig          `json:"health" yaml:"health"`
+	Log            LogConfig             `json:"log" yaml:"log"`
+	Fixtures       FixturesConfig        `json:"fixtures" yaml:"fixtures"`
+	OpenAPI        OpenAPIConfig         `json:"openapi" yaml:"openapi"`
+	Tracing        TracingConfig         `json:"tracing" yaml:"tracing"`
+	Parents        []PVEConnectionObject `json:"parents" yaml:"parents"`
+}
+
+type ListenerConfig struct {
+	Address        string         `json:"address" yaml:"address"`
+	Port           int            `json:"port" yaml:"port"`
+	TrustedProxies []string       `json:"trustedProxies" yaml:"trustedProxies"`
+	TLS            ListenerTLS    `json:"tls" yaml:"tls"`
+	Timeouts       ServerTimeouts `json:"timeouts" yaml:"timeouts"`
+}
+
+// Duration accepts Go duration strings ("30s", "2m") in both JSON and YAML.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\" - %v", err)
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.parse(value.Value)
+}
+
+func (d *Duration) parse(s string) error {
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (c *Config) applyDefaults() {
+	if c.Listen.Address == "" {
+		c.Listen.Address = "0.0.0.0"
+	}
+	if c.Listen.Port == 0 {
+		c.Listen.Port = defaultApiPort
+	}
+	c.Listen.Timeouts.applyDefaults()
+	if c.ReloadInterval == 0 {
+		c.ReloadInterval = Duration(defaultReloadInterval)
+	}
+	c.Auth.applyDefaults()
+	if c.Metrics.Interval != 0 {
+		slog.Warn("metrics.interval is deprecated - use inventory.interval instead")
+		if c.Inventory.Interval == 0 {
+			c.Inventory.Interval = c.Metrics.Interval
+		}
+	}
+	c.Inventory.applyDefaults()
+	c.Health.applyDefaults()
+	c.Log.applyDefaults()
+	c.Tracing.applyDefaults()
+	for i := range c.Parents {
+		if c.Parents[i].Port == 0 {
+			c.Parents[i].Port = defaultParentPort
+		}
+		if c.Parents[i].EndpointOrder == "" {
+			c.Parents[i].EndpointOrder = endpointOrderHealth
+		}
+		if c.Parents[i].Timeout == 0 {
+			c.Parents[i].Timeout = Duration(defaultParentTimeout)
+		}
+		if c.Parents[i].RetryBackoff == 0 {
+			c.Parents[i].RetryBackoff = Duration(defaultRetryBackoff)
+		}
+		if c.Parents[i].BreakerCooldown == 0 {
+			c.Parents[i].BreakerCooldown = Duration(defaultBreakerCooldown)
+		}
+		if c.Parents[i].MaxConcurrent == 0 {
+			c.Parents[i].MaxConcurrent = defaultMaxConcurrent
+		}
+		if c.Parents[i].RateLimit > 0 && c.Parents[i].RateBurst == 0 {
+			c.Parents[i].RateBurst = defaultRateBurst(c.Parents[i].RateLimit)
+		}
+		p := &c.Parents[i]
+		p.fixtures = c.Fixtures
+		if c.TokenDir != "" && p.Username == "" && p.tokenSources() == 0 {
+			p.TokenFile = filepath.Join(c.TokenDir, p.Parent)
+		}
+	}
+}
+
+func (c *Config) validate() error {
+	if len(c.Parents) == 0 {
+		return fmt.Errorf("no parents are defined")
+	}
+	if c.Listen.Port < 1 || c.Listen.Port > 65535 {
+		return fmt.Errorf("listener port %d is out of range", c.Listen.Port)
+	}
+	if c.ReloadInterval < 0 {
+		return fmt.Errorf("reloadInterval must not be negative")
+	}
+	if err := c.Listen.TLS.validate(); err != nil {
+		return err
+	}
+	if err := c.Listen.Timeouts.validate(); err != nil {
+		return err
+	}
+	if err := c.Inventory.validate(); err != nil {
+		return err
+	}
+	if err := c.Health.validate(); err != nil {
+		return err
+	}
+	if err := c.Log.validate(); err != nil {
+		return err
+	}
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+	if err := c.Fixtures.validate(); err != nil {
+		return err
+	}
+	if err := c.OpenAPI.validate(); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for i, p := range c.Parents {
+		if p.Parent == "" {
+			return fmt.Errorf("parent #%d has no name", i+1)
+		}
+		if seen[p.Parent] {
+			return fmt.Errorf("parent %s is defined more than once", p.Parent)
+		}
+		seen[p.Parent] = true
+		if p.Port < 1 || p.Port > 65535 {
+			return fmt.Errorf("parent %s has an invalid port %d", p.Parent, p.Port)
+		}
+		if p.tokenSources() > 1 {
+			return fmt.Errorf("parent %s sets more than one of token, tokenFile and tokenEnv", p.Parent)
+		}
+		if p.Username != "" {
+			if p.tokenSources() > 0 {
+				return fmt.Errorf("parent %s sets both a token and a username", p.Parent)
+			}
+			if p.passwordSources() != 1 {
+				return fmt.Errorf("parent %s must set exactly one of password, passwordFile and passwordEnv", p.Parent)
+			}
+			if p.totpSources() > 1 {
+				return fmt.Errorf("parent %s sets more than one of totpSecret, totpSecretFile and totpSecretEnv", p.Parent)
+			}
+		} else if p.tokenSources() == 0 && c.Fixtures.Mode != fixturesReplay {
+			return fmt.Errorf("parent %s has no token, tokenFile, tokenEnv or username and no tokenDir is configured", p.Parent)
+		}
+		if p.Timeout < 0 {
+			return fmt.Errorf("parent %s has a negative timeout", p.Parent)
+		}
+		if p.Retries < 0 || p.RetryBackoff < 0 || p.BreakerThreshold < 0 || p.BreakerCooldown < 0 {
+			return fmt.Errorf("parent %s has negative retry or circuit breaker settings", p.Parent)
+		}
+		if p.MaxConcurrent < 0 || p.RateLimit < 0 || p.RateBurst < 0 {
+			return fmt.Errorf("parent %s has negative concurrency or rate limits", p.Parent)
+		}
+		if p.EndpointOrder != endpointOrderHealth && p.EndpointOrder != endpointOrderOrdered {
+			return fmt.Errorf("parent %s has an unknown endpointOrder %q", p.Parent, p.EndpointOrder)
+		}
+		for _, ep := range p.Endpoints {
+			if strings.TrimSpace(ep) == "" {
+				return fmt.Errorf("parent %s has an empty endpoint", p.Parent)
+			}
+		}
+		if _, err := buildTLSConfig(p); err != nil {
+			return err
+		}
+	}
+	return c.Auth.validate(c.Parents)
+}
+
+func (c *Config) listenAddress() string {
+	return net.JoinHostPort(c.Listen.Address, strconv.Itoa(c.Listen.Port))
+}
+
+// findParent returns the configured parent with the given name.
+func (c *Config) findParent(name string) (PVEConnectionObject, bool) {
+	for _, p := range c.Parents {
+		if p.Parent == name {
+			return p, true
+		}
+	}
+	return PVEConnectionObject{}, false
+}
+
+// parseConfig decodes a config file. Files ending in .json are decoded as
+// JSON, everything else as YAML (which also accepts JSON documents).
+func parseConfig(path string, content []byte) (*Config, error) {
+	var cfg Config
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func convertJSON() ([]PVEConnectionObject, error) {
+	envVar, ok := os.LookupEnv("OBJECTS_JSON")
+	if !ok {
+		slog.Error("No data found in OBJECTS_JSON variable")
+		return nil, fmt.Errorf("no data found in OBJECTS_JSON variable")
+	}
+
+	var objects []PVEConnectionObject
+	if err := json.Unmarshal([]byte(envVar), &objects); err != nil {
+		var singleObject PVEConnectionObject
+		if err := json.Unmarshal([]byte(envVar), &singleObject); err != nil {
+			return objects, fmt.Errorf("%s", redactSecrets(err.Error()))
+		} else {
+			objects = []PVEConnectionObject{singleObject}
+		}
+	}
+	return objects, nil
+}
+
+// configFromEnv builds the configuration from the legacy environment
+// variables (OBJECTS_JSON, apiaddress, apiport, trusted_proxy, LOG_LEVEL,
+// LOG_FORMAT and OTEL_TRACES_EXPORTER).
+func configFromEnv() (*Config, error) {
+	parents, err := convertJSON()
+	if err != nil {
+		return nil, err
+	}
+	cfg := Config{Parents: parents}
+	if apiAddress, ok := os.LookupEnv("apiaddress"); ok {
+		cfg.Listen.Address = apiAddress
+	}
+	if apiPort, ok := os.LookupEnv("apiport"); ok {
+		port, err := strconv.Atoi(apiPort)
+		if err != nil {
+			return nil, fmt.Errorf("the apiport variable is not a number - %v", err)
+		}
+		cfg.Listen.Port = port
+	}
+	if trustedProxy, ok := os.LookupEnv("trusted_proxy"); ok {
+		cfg.Listen.TrustedProxies = []string{trustedProxy}
+	}
+	cfg.Log.Level = os.Getenv("LOG_LEVEL")
+	cfg.Log.Format = os.Getenv("LOG_FORMAT")
+	cfg.Tracing.Exporter = os.Getenv("OTEL_TRACES_EXPORTER")
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// configStore holds the active config snapshot and the outcome of the last
+// reload attempt.
+type configStore struct {
+	current atomic.Pointer[Config]
+
+	mu        sync.Mutex
+	path      string
+	modTime   time.Time
+	size      int64
+	lastError error
+}
+
+var activeConfig configStore
+
+// configKey holds the config snapshot of a request on the gin.Context.
+const configKey = "config"
+
+// currentConfig returns the active config snapshot. Handlers use
+// requestConfig instead, so a reload cannot change the config halfway
+// through a request.
+func currentConfig() *Config {
+	return activeConfig.current.Load()
+}
+
+// loadRequestConfig stores the active config snapshot on the request, once,
+// before any other middleware reads it.
+func loadRequestConfig(c *gin.Context) {
+	c.Set(configKey, currentConfig())
+	c.Next()
+}
+
+// requestConfig returns the config snapshot of the request, or the active
+// one when the request did not pass loadRequestConfig.
+func requestConfig(c *gin.Context) *Config {
+	if v, ok := c.Get(configKey); ok {
+		if cfg, ok := v.(*Config); ok {
+			return cfg
+		}
+	}
+	return currentConfig()
+}
+
+// loadInitial loads the config from path, or from the environment when path
+// is empty. A failure here is fatal for the caller.
+func (s *configStore) loadInitial(path string) error {
+	if path == "" {
+		cfg, err := configFromEnv()
+		if err != nil {
+			return err
+		}
+		s.current.Store(cfg)
+		configureLogging(cfg.Log, os.Stderr)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	return s.reloadLocked()
+}
+
+// reload re-reads the config file if it changed on disk. A file that fails to
+// parse or validate is rejected and the previous snapshot stays active.
+func (s *configStore) reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if s.lastError == nil || s.lastError.Error() != err.Error() {
+			slog.Warn("Failed to check the config file, keeping the previous config", "path", s.path, "error", err)
+		}
+		s.lastError = err
+		return
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return
+	}
+
+	if err := s.reloadLocked(); err != nil {
+		slog.Warn("Rejected the changed config file, keeping the previous config", "path", s.path, "error", err)
+		return
+	}
+	slog.Info("Reloaded the config", "path", s.path, "parents", len(currentConfig().Parents))
+}
+
+// reloadError returns why the config file was last rejected, or nil when the
+// active snapshot is the one on disk.
+func (s *configStore) reloadError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastError
+}
+
+func (s *configStore) reloadLocked() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		s.lastError = err
+		return err
+	}
+	// The stamp is recorded before parsing, so a bad file is only reported
+	// once and not on every poll.
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		s.lastError = err
+		return err
+	}
+	cfg, err := parseConfig(s.path, content)
+	if err != nil {
+		err = fmt.Errorf("%s", redactSecrets(err.Error()))
+		s.lastError = err
+		return err
+	}
+
+	if old := s.current.Load(); old != nil && old.listenAddress() != cfg.listenAddress() {
+		slog.Warn("The listener changed - this requires a restart to take effect", "from", old.listenAddress(), "to", cfg.listenAddress())
+	}
+	s.current.Store(cfg)
+	configureLogging(cfg.Log, os.Stderr)
+	s.lastError = nil
+	return nil
+}
+
+// watch polls the config file for changes until ctx is done. Polling is
+// used rather than inotify, as Kubernetes swaps mounted ConfigMaps through
+// symlinks which inotify watchers tend to miss.
+func (s *configStore) watch(ctx context.Context) {
+	if s.path == "" {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(currentConfig().ReloadInterval)):
+		}
+		s.reload()
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRejectedConfigKeepsThePreviousSnapshot(t *testing.T) {
+	_, router := newFakeParent(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	good := "parents:\n  - parent: lab\n    token: monitor@pve!test=secret\n"
+	if err := os.WriteFile(path, []byte(good), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := activeConfig.loadInitial(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		activeConfig.mu.Lock()
+		activeConfig.path, activeConfig.lastError = "", nil
+		activeConfig.mu.Unlock()
+	})
+	loaded := currentConfig()
+
+	if err := os.WriteFile(path, []byte("parents:\n  - parent: lab\n    unknownKey: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	activeConfig.reload()
+	if currentConfig() != loaded {
+		t.Errorf("expected the previous config to stay active")
+	}
+	if err := activeConfig.reloadError(); err == nil || !strings.Contains(err.Error(), "unknownKey") {
+		t.Errorf("expected the reason of the rejection, got %v", err)
+	}
+
+	var res ReadinessResponse
+	getJSON(t, router, "/readyz", http.StatusServiceUnavailable, &res)
+	if !strings.Contains(res.ConfigError, "unknownKey") {
+		t.Errorf("expected the rejection on /readyz, got %+v", res)
+	}
+
+	if err := os.WriteFile(path, []byte(good+"reloadInterval: 5s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	activeConfig.reload()
+	if currentConfig() == loaded || activeConfig.reloadError() != nil {
+		t.Errorf("expected the fixed file to be loaded")
+	}
+}
+
+func TestMetricsIntervalIsADeprecatedAlias(t *testing.T) {
+	base := "parents:\n  - parent: lab\n    token: monitor@pve!test=secret\n"
+	cfg, err := parseConfig("config.yaml", []byte(base+"metrics:\n  enabled: true\n  interval: 2m\n"))
+	if err != nil {
+		t.Fatalf("expected a config with metrics.interval to load - %v", err)
+	}
+	if time.Duration(cfg.Inventory.Interval) != 2*time.Minute {
+		t.Errorf("expected metrics.interval to set the inventory interval, got %s", time.Duration(cfg.Inventory.Interval))
+	}
+
+	cfg, err = parseConfig("config.yaml", []byte(base+"metrics:\n  interval: 2m\ninventory:\n  interval: 30s\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(cfg.Inventory.Interval) != 30*time.Second {
+		t.Errorf("expected inventory.interval to win, got %s", time.Duration(cfg.Inventory.Interval))
+	}
+}
+
+func TestRequestKeepsItsConfigSnapshot(t *testing.T) {
+	newFakeParent(t)
+	router := gin.New()
+	router.Use(loadRequestConfig)
+	router.GET("/parents", authenticate, func(c *gin.Context) {
+		// A reload while the request is served does not change its view
+		reloaded := *currentConfig()
+		reloaded.Parents = nil
+		activeConfig.current.Store(&reloaded)
+
+		var names []string
+		for _, p := range parentsFor(c) {
+			names = append(names, p.Parent)
+		}
+		c.JSON(http.StatusOK, names)
+	})
+
+	var names []string
+	getJSON(t, router, "/parents", http.StatusOK, &names)
+	if len(names) != 1 || names[0] != "lab" {
+		t.Errorf("expected the parents of the config the request started with, got %v", names)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// endpointOrderHealth starts with the endpoint that answered last
+	endpointOrderHealth = "health"
+	// endpointOrderOrdered always starts with the first configured endpoint
+	endpointOrderOrdered = "ordered"
+)
+
+// endpointSet holds the candidate endpoints of a parent and which one of them
+// answered last.
+type endpointSet struct {
+	addresses []string
+	ordered   bool
+
+	mu      sync.Mutex
+	healthy int
+	known   bool
+}
+
+// tryOrder returns the indexes of the endpoints in the order they should be
+// tried.
+func (e *endpointSet) tryOrder() []int {
+	e.mu.Lock()
+	start := 0
+	if !e.ordered {
+		start = e.healthy
+	}
+	e.mu.Unlock()
+
+	order := make([]int, 0, len(e.addresses))
+	for i := range e.addresses {
+		order = append(order, (start+i)%len(e.addresses))
+	}
+	return order
+}
+
+func (e *endpointSet) markGood(i int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.healthy = i
+	e.known = true
+}
+
+// current returns the endpoint that answered last, or an empty string when
+// none has answered yet.
+func (e *endpointSet) current() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.known {
+		return ""
+	}
+	return e.addresses[e.healthy]
+}
+
+// failoverTransport sends each request to the endpoints of a parent in turn,
+// until one of them answers, and skips those whose pveproxy reports that the
+// node behind it is unavailable. When every endpoint fails, the last such
+// answer is returned, or the last error if none answered. URLs are built with the parent name, and the
+// host is swapped for the endpoint here.
+type failoverTransport struct {
+	base      http.RoundTripper
+	parent    string
+	endpoints *endpointSet
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		lastErr error
+		// lastRes is the last unavailable answer, returned when no endpoint does better
+		lastRes *http.Response
+	)
+	for n, i := range t.endpoints.tryOrder() {
+		attempt := req.Clone(req.Context())
+		attempt.URL.Host = t.endpoints.addresses[i]
+		attempt.Host = ""
+		if n > 0 && req.Body != nil {
+			// A body can only be sent once, unless it can be recreated
+			if req.GetBody == nil {
+				break
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+
+		ctx, span := tracer().Start(req.Context(), req.Method+" "+upstreamRoute(req.URL.Path),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(upstreamSpanAttrs(t.parent, attempt.URL.Host, req.Method, req.URL.Path)...),
+		)
+		started := time.Now()
+		res, err := t.base.RoundTrip(attempt.WithContext(ctx))
+		if err == nil {
+			span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+			if unavailableStatus(res.StatusCode) {
+				span.SetStatus(codes.Error, res.Status)
+			}
+		}
+		endSpan(span, err)
+		attrs := append(upstreamLogAttrs(req.URL.Path), "method", req.Method, "endpoint", attempt.URL.Host, "duration_ms", msSince(started))
+		if err == nil {
+			observeUpstream(t.parent, attempt.URL.Host, started, res.StatusCode, nil)
+			slog.DebugContext(req.Context(), "Called the parent", append(attrs, "status", res.StatusCode)...)
+			if lastRes != nil {
+				lastRes.Body.Close()
+			}
+			if !unavailableStatus(res.StatusCode) {
+				t.endpoints.markGood(i)
+				return res, nil
+			}
+			// pveproxy answers, but the node behind it is broken - try the
+			// next endpoint, and keep the answer in case none does better
+			lastRes = res
+		} else {
+			observeUpstream(t.parent, attempt.URL.Host, started, 0, err)
+			slog.DebugContext(req.Context(), "Failed to call the parent", append(attrs, "error", err)...)
+			lastErr = err
+		}
+		if req.Context().Err() != nil {
+			break
+		}
+	}
+	// An answer tells more about the failure than a connection error
+	if lastRes != nil {
+		return lastRes, nil
+	}
+	return nil, lastErr
+}
+
+// unavailableStatus reports whether pveproxy answered that it cannot serve
+// the call right now - a gateway error, or the 595 it sends when it cannot
+// reach its node. Proxmox also answers ordinary errors such as a stopped VM or
+// a missing guest agent with a plain 500; those come back the same from every
+// node and are returned as they are.
+func unavailableStatus(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, 595:
+		return true
+	}
+	return false
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the
+// wrapped transport.
+func (t *failoverTransport) CloseIdleConnections() {
+	if ci, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFailoverSkipsBrokenEndpoints(t *testing.T) {
+	// pveproxy is up, but cannot reach its node
+	broken := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "No route to host", 595)
+	}))
+	t.Cleanup(broken.Close)
+	// Nothing listens on the endpoint any more
+	down := httptest.NewTLSServer(http.NotFoundHandler())
+	down.Close()
+
+	// The gateway of the endpoint is overloaded
+	busy := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(busy.Close)
+
+	for name, endpoint := range map[string]string{
+		"595":  broken.Listener.Addr().String(),
+		"503":  busy.Listener.Addr().String(),
+		"down": down.Listener.Addr().String(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, router := newFakeParent(t, func(cfg *Config) {
+				cfg.Parents[0].Endpoints = []string{endpoint, cfg.Parents[0].Endpoints[0]}
+				cfg.Parents[0].EndpointOrder = endpointOrderOrdered
+			})
+
+			var res NodeSummaryResponse
+			getJSON(t, router, "/api/v1/infrastructure/nodes/summary", http.StatusOK, &res)
+			if len(res.Data) != 2 || len(res.Errors) != 0 {
+				t.Errorf("expected the nodes from the healthy endpoint, got %+v", res)
+			}
+
+			var status ParentStatusResponse
+			getJSON(t, router, "/api/v1/status/parents", http.StatusOK, &status)
+			if len(status.Data) != 1 || status.Data[0].Endpoint != srv.Address() {
+				t.Errorf("expected %s in use, got %+v", srv.Address(), status.Data)
+			}
+		})
+	}
+}
+
+func TestFailoverReturnsProxmoxErrors(t *testing.T) {
+	// Proxmox answers ordinary errors with a plain 500, which every other
+	// endpoint would answer the same
+	var calls atomic.Int32
+	failing := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"data":null,"message":"unable to read file '/etc/pve/corosync.conf'\n"}`))
+	}))
+	t.Cleanup(failing.Close)
+
+	srv, router := newFakeParent(t, func(cfg *Config) {
+		cfg.Parents[0].Endpoints = []string{failing.Listener.Addr().String(), cfg.Parents[0].Endpoints[0]}
+		cfg.Parents[0].EndpointOrder = endpointOrderOrdered
+	})
+
+	var res NodeSummaryResponse
+	getJSON(t, router, "/api/v1/infrastructure/nodes/summary", http.StatusOK, &res)
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, "corosync.conf") {
+		t.Errorf("expected the error of the first endpoint, got %+v", res)
+	}
+	if calls.Load() != 1 || srv.Requests("/nodes") != 0 {
+		t.Errorf("expected only the first endpoint to be called, got %d and %d calls", calls.Load(), srv.Requests("/nodes"))
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	fixturesRecord = "record"
+	fixturesReplay = "replay"
+	apiPathPrefix  = "/api2/json"
+)
+
+// FixturesConfig records the responses of the parents to a directory, or
+// replays them from it instead of contacting the parents. The fixtures of a
+// parent are kept in a directory named after the parent - or after its alias
+// when anonymizing, for recording and replaying alike.
+type FixturesConfig struct {
+	Mode string `json:"mode" yaml:"mode"`
+	Dir  string `json:"dir" yaml:"dir"`
+	// Anonymize replaces the names of the parents and nodes in recorded
+	// paths and responses with stable aliases.
+	Anonymize bool `json:"anonymize" yaml:"anonymize"`
+}
+
+// fixturesDir returns the directory with the fixtures of a parent.
+func fixturesDir(host PVEConnectionObject) string {
+	name := host.Parent
+	if host.fixtures.Anonymize {
+		name = newAnonymizer(nil).add(name)
+	}
+	return filepath.Join(host.fixtures.Dir, name)
+}
+
+func (f *FixturesConfig) validate() error {
+	switch f.Mode {
+	case "":
+		return nil
+	case fixturesRecord, fixturesReplay:
+	default:
+		return fmt.Errorf("unknown fixtures mode %q - use %s or %s", f.Mode, fixturesRecord, fixturesReplay)
+	}
+	if f.Dir == "" {
+		return fmt.Errorf("the fixtures mode %s needs a dir", f.Mode)
+	}
+	return nil
+}
+
+// fixture is a recorded response. Bodies that are not JSON are kept as text.
+type fixture struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Text   string          `json:"text,omitempty"`
+}
+
+// fixturePath maps a request to its file below the directory of a parent,
+// e.g. GET /api2/json/nodes/pve1/qemu to nodes/pve1/qemu.json.
+func fixturePath(dir string, u *url.URL) string {
+	name := strings.Trim(strings.TrimPrefix(u.Path, apiPathPrefix), "/")
+	if name == "" {
+		name = "index"
+	}
+	if u.RawQuery != "" {
+		name += "@" + url.QueryEscape(u.RawQuery)
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)+".json")
+}
+
+// recordTransport passes requests on and saves a copy of every response,
+// with tokens and tickets redacted.
+type recordTransport struct {
+	base      http.RoundTripper
+	dir       string
+	anonymize *anonymizer
+}
+
+func newRecordTransport(base http.RoundTripper, host PVEConnectionObject) *recordTransport {
+	t := &recordTransport{base: base, dir: fixturesDir(host)}
+	if host.fixtures.Anonymize {
+		t.anonymize = newAnonymizer(append([]string{host.Parent}, host.Endpoints...))
+	}
+	return t
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet {
+		return res, err
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := t.save(req.URL, res.StatusCode, body); err != nil {
+		slog.WarnContext(req.Context(), "Failed to record the response", "upstream_path", req.URL.Path, "error", err)
+	}
+	return res, nil
+}
+
+func (t *recordTransport) save(u *url.URL, status int, body []byte) error {
+	f := fixture{Status: status}
+	sanitized := redactSecrets(string(body))
+	if t.anonymize != nil {
+		switch u.Path {
+		case apiPathPrefix + "/nodes", apiPathPrefix + "/cluster/resources",
+			apiPathPrefix + "/cluster/status", apiPathPrefix + "/cluster/config/nodes":
+			t.anonymize.learnNodes([]byte(sanitized))
+		}
+		anonymized := *u
+		anonymized.Path = t.anonymize.path(u.Path)
+		u = &anonymized
+		sanitized = t.anonymize.body(sanitized)
+	}
+	if json.Valid([]byte(sanitized)) {
+		f.Body = json.RawMessage(sanitized)
+	} else {
+		f.Text = sanitized
+	}
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := fixturePath(t.dir, u)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Write and rename, so a replay never reads a half written file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fixture-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the
+// wrapped transport.
+func (t *recordTransport) CloseIdleConnections() {
+	if ci, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// replayTransport answers every request from the recorded fixtures of a
+// parent. Requests without a fixture get a 404, like an unknown API path.
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return replayResponse(req, http.StatusNotImplemented, []byte(`{"data":null,"message":"replayed parents are read only"}`)), nil
+	}
+	b, err := os.ReadFile(fixturePath(t.dir, req.URL))
+	if os.IsNotExist(err) {
+		msg, _ := json.Marshal(map[string]any{"data": nil, "message": "no fixture was recorded for " + req.URL.Path})
+		return replayResponse(req, http.StatusNotFound, msg), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f fixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("the fixture for %s is invalid - %w", req.URL.Path, err)
+	}
+	body := []byte(f.Body)
+	if f.Text != "" {
+		body = []byte(f.Text)
+	}
+	return replayResponse(req, f.Status, body), nil
+}
+
+func replayResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// anonymizer replaces host names with aliases derived from a hash of the
+// name, so the same name gets the same alias in every recording.
+type anonymizer struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func newAnonymizer(hosts []string) *anonymizer {
+	a := &anonymizer{names: make(map[string]string)}
+	for _, h := range hosts {
+		if host, _, err := net.SplitHostPort(h); err == nil {
+			h = host
+		}
+		a.add(h)
+	}
+	return a
+}
+
+// add registers a host name and returns its alias. In full names the host
+// and the domain are replaced separately, so pve1 and pve1.lab.example map to
+// host-1a2b3c4d and host-1a2b3c4d.domain-5e6f7a8b, and the domain itself is
+// registered too. IP addresses are left alone.
+func (a *anonymizer) add(name string) string {
+	if name == "" || net.ParseIP(name) != nil {
+		return name
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	short, domain, full := strings.Cut(name, ".")
+	alias := a.alias("host-", short)
+	if full {
+		alias += "." + a.alias("domain-", domain)
+		a.names[name] = alias
+	}
+	return alias
+}
+
+// alias returns the alias of a name, made from the prefix and a hash of the
+// name. The caller holds a.mu.
+func (a *anonymizer) alias(prefix, name string) string {
+	alias, ok := a.names[name]
+	if !ok {
+		sum := sha256.Sum256([]byte(name))
+		alias = prefix + hex.EncodeToString(sum[:4])
+		a.names[name] = alias
+	}
+	return alias
+}
+
+// learnNodes registers the node names from a /nodes, /cluster/resources,
+// /cluster/status or /cluster/config/nodes response, and the corosync
+// addresses that are host names.
+func (a *anonymizer) learnNodes(body []byte) {
+	var nodes struct {
+		Data []map[string]any `json:"data"`
+	}
+	if json.Unmarshal(body, &nodes) != nil {
+		return
+	}
+	for _, n := range nodes.Data {
+		if node, ok := n["node"].(string); ok {
+			a.add(node)
+		}
+		// Only the node entries - guests and the cluster have names as well
+		if name, ok := n["name"].(string); ok && (n["type"] == nil || n["type"] == "node") {
+			a.add(name)
+		}
+		for key, v := range n {
+			if addr, ok := v.(string); ok && strings.HasPrefix(key, "ring") && strings.HasSuffix(key, "_addr") {
+				a.add(addr)
+			}
+		}
+	}
+}
+
+// path replaces the node name in a request path.
+func (a *anonymizer) path(p string) string {
+	parts := strings.Split(p, "/")
+	for i := 1; i < len(parts); i++ {
+		if parts[i-1] == "nodes" {
+			parts[i] = a.add(parts[i])
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// body replaces every known name that stands on its own - not as part of a
+// longer name such as pve10 for pve1.
+func (a *anonymizer) body(s string) string {
+	a.mu.Lock()
+	aliases := maps.Clone(a.names)
+	a.mu.Unlock()
+	// Longer names first, so a name that contains another is replaced whole
+	names := slices.SortedFunc(maps.Keys(aliases), func(x, y string) int { return len(y) - len(x) })
+
+	for _, name := range names {
+		s = replaceName(s, name, aliases[name])
+	}
+	return s
+}
+
+func replaceName(s, name, alias string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, name)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := i + len(name)
+		if (i == 0 || !isNameByte(s[i-1])) && (end == len(s) || !isNameByte(s[end])) {
+			b.WriteString(s[:i])
+			b.WriteString(alias)
+		} else {
+			b.WriteString(s[:end])
+		}
+		s = s[end:]
+	}
+}
+
+func isNameByte(c byte) bool {
+	return c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplayFixtures(t *testing.T) {
+	dir := t.TempDir()
+	_, router := newFakeParent(t, func(cfg *Config) {
+		cfg.Fixtures = FixturesConfig{Mode: fixturesRecord, Dir: dir, Anonymize: true}
+	})
+	var recorded VmSummaryResponse
+	getJSON(t, router, "/api/v1/virtualization/vm/summary", http.StatusOK, &recorded)
+	if len(recorded.Data) != 2 || recorded.Data[0].Node != "pve1" {
+		t.Fatalf("expected the live VMs while recording, got %+v", recorded)
+	}
+	getJSON(t, router, "/api/v1/infrastructure/nodes/summary", http.StatusOK, nil)
+
+	alias, parentAlias := newAnonymizer(nil).add("pve1"), newAnonymizer(nil).add("lab")
+	if _, err := os.Stat(filepath.Join(dir, "lab")); !os.IsNotExist(err) {
+		t.Errorf("expected no directory named after the parent, got %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, parentAlias, "nodes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), `"pve1"`) || !strings.Contains(string(b), alias) {
+		t.Errorf("expected pve1 to be replaced by %s in %s", alias, b)
+	}
+	b, err = os.ReadFile(filepath.Join(dir, parentAlias, "cluster", "resources.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), `"pve1"`) || !strings.Contains(string(b), `"node/`+alias+`"`) {
+		t.Errorf("expected the resources of pve1 to be recorded under its alias, got %s", b)
+	}
+
+	// Replay without a reachable parent or any credentials
+	_, router = newFakeParent(t, func(cfg *Config) {
+		cfg.Fixtures = FixturesConfig{Mode: fixturesReplay, Dir: dir, Anonymize: true}
+		cfg.Parents[0].Token = ""
+		cfg.Parents[0].Endpoints = []string{"127.0.0.1:1"}
+	})
+	var replayed VmSummaryResponse
+	getJSON(t, router, "/api/v1/virtualization/vm/summary", http.StatusOK, &replayed)
+	if len(replayed.Errors) != 0 || len(replayed.Data) != 2 {
+		t.Fatalf("expected the recorded VMs without errors, got %+v", replayed)
+	}
+	if vm := replayed.Data[0]; vm.Node != alias || vm.Name != recorded.Data[0].Name || vm.GuestMemoryGb != recorded.Data[0].GuestMemoryGb {
+		t.Errorf("expected the recorded VM on %s, got %+v", alias, vm)
+	}
+
+	var missing NodeStorageResponse
+	getJSON(t, router, "/api/v1/infrastructure/nodes/detailed/lab/storage", http.StatusOK, &missing)
+	if len(missing.Errors) != 2 || !strings.Contains(missing.Errors[0].Message, "no fixture was recorded") {
+		t.Errorf("expected errors for the storage that was not recorded, got %+v", missing.Errors)
+	}
+}
+
+func TestAnonymizerKeepsLongerNames(t *testing.T) {
+	a := newAnonymizer([]string{"pve1.lab.example:8006"})
+	got := a.body(`{"node":"pve1","other":"pve10","fqdn":"pve1.lab.example","peer":"pve2.lab.example","id":"node/pve1"}`)
+	alias, fqdn := a.add("pve1"), a.add("pve1.lab.example")
+	domain := strings.TrimPrefix(fqdn, alias+".")
+	want := `{"node":"` + alias + `","other":"pve10","fqdn":"` + fqdn + `","peer":"pve2.` + domain + `","id":"node/` + alias + `"}`
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if strings.Contains(got, "lab.example") || !strings.HasPrefix(domain, "domain-") {
+		t.Errorf("expected the domain to be replaced, got %s", got)
+	}
+}
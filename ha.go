@@ -0,0 +1,215 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve"
+)
+
+// haOverview reports the HA manager, resources and groups of every parent
+// the caller may read.
+func haOverview(c *gin.Context) {
+	var (
+		results []HAInfo
+		errors  []ApiError
+		sources []ParentSource
+	)
+
+	// The parents are queried concurrently, every one into its own batch so
+	// the response keeps the order of the config
+	type parentBatch struct {
+		info    HAInfo
+		errors  []ApiError
+		reached bool
+	}
+	parents := parentsFor(c)
+	batches := make([]parentBatch, len(parents))
+	var wg sync.WaitGroup
+	for i, host := range parents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := parentContext(c.Request.Context(), host)
+			defer cancel()
+			b := &batches[i]
+			b.info, b.errors, b.reached = liveHA(ctx, host)
+		}()
+	}
+	wg.Wait()
+
+	for i, batch := range batches {
+		errors = append(errors, batch.errors...)
+		if batch.reached {
+			results = append(results, batch.info)
+			sources = append(sources, parentSource(parents[i]))
+		}
+	}
+
+	c.JSON(http.StatusOK, HAResponse{
+		Data:    results,
+		Errors:  requestErrors(c, errors),
+		Sources: sources,
+	})
+}
+
+// parentHAOverview reports the HA manager, resources and groups of one
+// parent.
+func parentHAOverview(c *gin.Context) {
+	parentName := c.Param("parent")
+	selectedObj, found := parentFor(c, parentName)
+
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("The parent entered (%s) is not present in the configuration - please adjust.", parentName)})
+		return
+	}
+
+	ctx, cancel := parentContext(c.Request.Context(), selectedObj)
+	defer cancel()
+
+	info, errors, reached := liveHA(ctx, selectedObj)
+	if !reached {
+		c.JSON(http.StatusInternalServerError, HAResponse{
+			Errors: requestErrors(c, errors),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, HAResponse{
+		Data:    []HAInfo{info},
+		Errors:  requestErrors(c, errors),
+		Sources: []ParentSource{parentSource(selectedObj)},
+	})
+}
+
+// liveHA checks that a parent is reachable and reads its HA stack. reached
+// is false when the parent could not be queried at all.
+func liveHA(ctx context.Context, host PVEConnectionObject) (info HAInfo, errors []ApiError, reached bool) {
+	client, err := clientFor(host)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+		return HAInfo{}, []ApiError{{Parent: host.Parent, Action: "tlsConfig", Message: err.Error()}}, false
+	}
+	portOpen, err := probeParent(ctx, host)
+	if !portOpen {
+		slog.WarnContext(ctx, "Failed to reach the parent", "port", host.Port, "error", err)
+		return HAInfo{}, []ApiError{{
+			Parent:  host.Parent,
+			Action:  errorAction("testHostPort", err),
+			Message: fmt.Sprintf("The parent is not listening on %d - %v", host.Port, err),
+		}}, false
+	}
+	return parentHA(ctx, client, host)
+}
+
+// parentHA reads the manager status, the configured resources and the
+// groups of a parent from /cluster/ha. Only the manager status is required -
+// without the resources or groups, e.g. after PVE 9 migrated the groups to
+// rules, those are left empty and reported as errors.
+func parentHA(ctx context.Context, client *pve.Client, host PVEConnectionObject) (info HAInfo, errors []ApiError, ok bool) {
+	status, err := client.HAManagerStatus(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to obtain the HA manager status", "error", err)
+		return HAInfo{}, []ApiError{{
+			Parent:  host.Parent,
+			Action:  errorAction("getHAManagerStatus", err),
+			Message: err.Error(),
+		}}, false
+	}
+	manager := status.ManagerStatus
+	info = HAInfo{Parent: host.Parent, MasterNode: manager.MasterNode}
+
+	nodes := make(map[string]bool)
+	for node := range manager.NodeStatus {
+		nodes[node] = true
+	}
+	for node := range status.LRMStatus {
+		nodes[node] = true
+	}
+	for node := range nodes {
+		lrm := status.LRMStatus[node]
+		info.Nodes = append(info.Nodes, HANodeInfo{
+			Node:         node,
+			Status:       manager.NodeStatus[node],
+			LRMMode:      lrm.Mode,
+			LRMState:     lrm.State,
+			LRMTimestamp: lrm.Timestamp,
+		})
+	}
+	slices.SortFunc(info.Nodes, func(a, b HANodeInfo) int { return cmp.Compare(a.Node, b.Node) })
+
+	resources, err := client.HAResources(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to obtain the HA resources", "error", err)
+		errors = append(errors, ApiError{
+			Parent:  host.Parent,
+			Action:  errorAction("getHAResources", err),
+			Message: err.Error(),
+		})
+	}
+	for _, r := range resources {
+		current := manager.ServiceStatus[r.SID]
+		_, id, _ := strings.Cut(r.SID, ":")
+		vmid, _ := strconv.Atoi(id)
+		info.Resources = append(info.Resources, HAResourceInfo{
+			SID:            r.SID,
+			Type:           r.Type,
+			Vmid:           vmid,
+			Node:           current.Node,
+			RequestedState: r.State,
+			CurrentState:   current.State,
+			Group:          r.Group,
+			MaxRestart:     r.MaxRestart,
+			MaxRelocate:    r.MaxRelocate,
+			Comment:        r.Comment,
+		})
+	}
+	slices.SortFunc(info.Resources, func(a, b HAResourceInfo) int {
+		return cmp.Or(cmp.Compare(a.Vmid, b.Vmid), cmp.Compare(a.SID, b.SID))
+	})
+
+	groups, err := client.HAGroups(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to obtain the HA groups", "error", err)
+		errors = append(errors, ApiError{
+			Parent:  host.Parent,
+			Action:  errorAction("getHAGroups", err),
+			Message: err.Error(),
+		})
+	}
+	for _, g := range groups {
+		info.Groups = append(info.Groups, HAGroupInfo{
+			Group:      g.Group,
+			Nodes:      haGroupNodes(g.Nodes),
+			Restricted: g.Restricted == 1,
+			NoFailback: g.NoFailback == 1,
+			Comment:    g.Comment,
+		})
+	}
+	slices.SortFunc(info.Groups, func(a, b HAGroupInfo) int { return cmp.Compare(a.Group, b.Group) })
+	return info, errors, true
+}
+
+// haGroupNodes parses the members of an HA group, node[:priority] separated
+// by commas, highest priority first. Nodes without a priority have 0.
+func haGroupNodes(list string) []HAGroupNode {
+	var nodes []HAGroupNode
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		node, priority, _ := strings.Cut(entry, ":")
+		p, _ := strconv.Atoi(priority)
+		nodes = append(nodes, HAGroupNode{Node: node, Priority: p})
+	}
+	slices.SortStableFunc(nodes, func(a, b HAGroupNode) int { return cmp.Compare(b.Priority, a.Priority) })
+	return nodes
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve/pvetest"
+)
+
+// newFakeParent starts a fake Proxmox API and configures it as the only
+// parent, named lab. configure can change the parent before the config is
+// validated.
+func newFakeParent(t *testing.T, configure ...func(*Config)) (*pvetest.Server, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	srv := pvetest.NewServer(pvetest.DefaultFixtures())
+	t.Cleanup(srv.Close)
+
+	cfg := &Config{Parents: []PVEConnectionObject{{
+		Parent:    "lab",
+		Token:     "monitor@pve!test=secret",
+		Endpoints: []string{srv.Address()},
+		Insecure:  true,
+	}}}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	activeConfig.current.Store(cfg)
+	t.Cleanup(func() { activeConfig.current.Store(nil) })
+
+	return srv, newRouter(cfg)
+}
+
+// getJSON requests path from the router, checks the status and decodes the
+// response into out.
+func getJSON(t *testing.T, router *gin.Engine, path string, status int, out any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != status {
+		t.Fatalf("GET %s: expected status %d, got %d - %s", path, status, rec.Code, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("GET %s: failed to decode %s - %v", path, rec.Body.String(), err)
+		}
+	}
+}
+
+// findError returns the first error for the node, or nil.
+func findError(errors []ApiError, node string) *ApiError {
+	for i := range errors {
+		if errors[i].Node == node {
+			return &errors[i]
+		}
+	}
+	return nil
+}
+
+func TestHealth(t *testing.T) {
+	_, router := newFakeParent(t)
+	var res map[string]string
+	getJSON(t, router, "/health", http.StatusOK, &res)
+	if res["status"] != "ok" {
+		t.Errorf("expected status ok, got %v", res)
+	}
+}
+
+func TestNodesSummary(t *testing.T) {
+	srv, router := newFakeParent(t)
+	var res NodeSummaryResponse
+	getJSON(t, router, "/api/v1/infrastructure/nodes/summary", http.StatusOK, &res)
+
+	if len(res.Data) != 2 || len(res.Errors) != 0 {
+		t.Fatalf("expected two nodes without errors, got %+v", res)
+	}
+	pve1 := res.Data[0]
+	if pve1.Node != "pve1" || pve1.MemGb != 24 || pve1.MaxMemGb != 64 || pve1.Cpu != 25 || pve1.UptimeHours != 24 {
+		t.Errorf("unexpected summary of pve1 - %+v", pve1)
+	}
+	if len(res.Sources) != 1 || res.Sources[0].Endpoint != srv.Address() || res.Sources[0].Cached {
+		t.Errorf("expected the live endpoint as source, got %+v", res.Sources)
+	}
+}
+
+func TestNodesSummaryReportsOfflineNodes(t *testing.T) {
+	srv, router := newFakeParent(t)
+	srv.SetOffline("pve2", true)
+
+	var res NodeSummaryResponse
+	getJSON(t, router, "/api/v1/infrastructure/nodes/summary", http.StatusOK, &res)
+	if len(res.Data) != 2 || res.Data[1].NodeStatus != "offline" {
+		t.Fatalf("expected pve2 to be listed as offline, got %+v", res.Data)
+	}
+	if e := findError(res.Errors, "pve2"); e == nil || e.Action != "onlineStatus" {
+		t.Errorf("expected an onlineStatus error for pve2, got %+v", res.Errors)
+	}
+}
+
+func TestNodesSummaryReportsBadJSON(t *testing.T) {
+	srv, router := newFakeParent(t)
+	srv.Inject("/nodes", pvetest.Fault{BadJSON: true})
+
+	var res NodeSummaryResponse
+	getJSON(t, router, "/api/v1/infrastructure/nodes/summary", http.StatusOK, &res)
+	if len(res.Data) != 0 || len(res.Errors) != 1 {
+		t.Fatalf("expected a single error and no nodes, got %+v", res)
+	}
+	if e := res.Errors[0]; e.Action != "getParentNodes" || !strings.Contains(e.Message, "decode") {
+		t.Errorf("expected a decode error from getParentNodes, got %+v", e)
+	}
+}
+
+func TestNodesDetailed(t *testing.T) {
+	_, router := newFakeParent(t)
+	var res NodeDetailsResponse
+	getJSON(t, router, "/api/v1/infrastructure/nodes/detailed/lab", http.StatusOK, &res)
+
+	if len(res.Data) != 2 || len(res.Errors) != 0 {
+		t.Fatalf("expected two nodes without errors, got %+v", res)
+	}
+	pve1 := res.Data[0]
+	if pve1.Pveversion != "pve-manager/8.3.2/3e76eec21c4a14a7" || pve1.Cpuinfo.Cores != 16 || pve1.BootInfo.Mode != "efi" {
+		t.Errorf("unexpected status of pve1 - %+v", pve1)
+	}
+	if pve1.Dns.Dns1 != "10.0.0.53" || pve1.Time.Timezone != "Europe/Copenhagen" || pve1.Time.Time.Unix() != 1735689600 {
+		t.Errorf("unexpected DNS or time of pve1 - %+v %+v", pve1.Dns, pve1.Time)
+	}
+
+	getJSON(t, router, "/api/v1/infrastructure/nodes/detailed/unknown", http.StatusNotFound, nil)
+}
+
+func TestNodeStorage(t *testing.T) {
+	_, router := newFakeParent(t)
+	var res NodeStorageResponse
+	getJSON(t, router, "/api/v1/infrastructure/nodes/detailed/lab/storage", http.StatusOK, &res)
+
+	if len(res.Data) != 3 || len(res.Errors) != 0 {
+		t.Fatalf("expected three storages without errors, got %+v", res)
+	}
+	ceph := res.Data[1]
+	if ceph.Node != "pve1" || ceph.Storage != "ceph" || ceph.TotalGb != 4096 || ceph.UsedGb != 1024 || ceph.Shared != 1 {
+		t.Errorf("unexpected ceph storage - %+v", ceph)
+	}
+}
+
+func TestNodeStorageReportsServerErrors(t *testing.T) {
+	srv, router := newFakeParent(t)
+	srv.Inject("/nodes/pve1/storage", pvetest.Fault{Status: http.StatusInternalServerError, Message: "storage 'ceph' is not online"})
+
+	var res NodeStorageResponse
+	getJSON(t, router, "/api/v1/infrastructure/nodes/detailed/lab/storage", http.StatusOK, &res)
+	if len(res.Data) != 1 || res.Data[0].Node != "pve2" {
+		t.Fatalf("expected the storage of pve2 only, got %+v", res.Data)
+	}
+	e := findError(res.Errors, "pve1")
+	if e == nil || e.Action != "getNodeStorage" || !strings.Contains(e.Message, "storage 'ceph' is not online") {
+		t.Errorf("expected the Proxmox message in the error for pve1, got %+v", res.Errors)
+	}
+}
+
+func TestNodeDisks(t *testing.T) {
+	_, router := newFakeParent(t)
+	var res NodeDiskObject
+	getJSON(t, router, "/api/v1/infrastructure/nodes/detailed/lab/disks", http.StatusOK, &res)
+
+	if len(res.Data) != 3 || len(res.Errors) != 0 {
+		t.Fatalf("expected three disks without errors, got %+v", res)
+	}
+	disks := make(map[string]NodeDiskInfo)
+	for _, d := range res.Data {
+		disks[d.Node+d.Devpath] = d
+	}
+	if nvme := disks["pve1/dev/nvme0n1"]; nvme.Wearout != 97 || nvme.SizeGb != 1000 || nvme.Health != "PASSED" {
+		t.Errorf("unexpected NVMe disk - %+v", nvme)
+	}
+	if hdd := disks["pve1/dev/sda"]; hdd.Wearout != 100 || hdd.Rpm != float64(7200) {
+		t.Errorf("expected a wearout of 100 and 7200 RPM for the HDD, got %+v", hdd)
+	}
+}
+
+func TestVmSummary(t *testing.T) {
+	srv, router := newFakeParent(t)
+	var res VmSummaryResponse
+	getJSON(t, router, "/api/v1/virtualization/vm/summary", http.StatusOK, &res)
+
+	if len(res.Data) != 2 || len(res.Errors) != 0 {
+		t.Fatalf("expected two VMs without errors, got %+v", res)
+	}
+	web := res.Data[0]
+	if web.Vmid != 100 || web.Parent != "lab" || web.Node != "pve1" || web.GuestMemoryGb != 3072 || web.MaxMemoryGb != 8192 {
+		t.Errorf("unexpected summary of VM 100 - %+v", web)
+	}
+	if srv.Requests("/cluster/resources") != 1 || srv.Requests("/nodes") != 0 || srv.Requests("/nodes/pve1/qemu") != 0 {
+		t.Errorf("expected a single call to /cluster/resources")
+	}
+}
+
+func TestVmSummaryWalksNodesWithoutClusterResources(t *testing.T) {
+	srv, router := newFakeParent(t)
+	srv.Inject("/cluster/resources", pvetest.Fault{Status: http.StatusForbidden, Message: "Permission check failed"})
+	srv.SetOffline("pve2", true)
+
+	var res VmSummaryResponse
+	getJSON(t, router, "/api/v1/virtualization/vm/summary", http.StatusOK, &res)
+	if len(res.Data) != 2 || res.Data[0].Vmid != 100 || res.Data[0].GuestMemoryGb != 3072 {
+		t.Fatalf("expected the VMs of pve1, got %+v", res)
+	}
+	if e := findError(res.Errors, "pve2"); len(res.Errors) != 1 || e == nil || e.Action != "onlineStatus" {
+		t.Errorf("expected an onlineStatus error for pve2, got %+v", res.Errors)
+	}
+	if srv.Requests("/nodes") != 1 || srv.Requests("/nodes/pve1/qemu") != 1 || srv.Requests("/nodes/pve1/lxc") != 0 {
+		t.Errorf("expected the VMs of the online node to be listed one by one")
+	}
+}
+
+func TestInventoryResources(t *testing.T) {
+	srv, router := newFakeParent(t)
+	var all ClusterResourcesResponse
+	getJSON(t, router, "/api/v1/inventory/resources", http.StatusOK, &all)
+	counts := make(map[string]int)
+	for _, r := range all.Data {
+		counts[r.Type]++
+	}
+	if counts["node"] != 2 || counts["qemu"] != 2 || counts["lxc"] != 2 || counts["storage"] != 3 || len(all.Errors) != 0 {
+		t.Fatalf("expected 2 nodes, 2 VMs, 2 containers and 3 storages, got %v - %+v", counts, all.Errors)
+	}
+
+	var res ClusterResourcesResponse
+	getJSON(t, router, "/api/v1/inventory/resources?type=storage", http.StatusOK, &res)
+	if len(res.Data) != 3 || res.Data[0].ID != "storage/pve1/ceph" || !res.Data[0].Shared || res.Data[0].MaxDisk != 4096*1024*1024*1024 {
+		t.Errorf("expected the storage of both nodes, got %+v", res.Data)
+	}
+	getJSON(t, router, "/api/v1/inventory/resources?type=pool", http.StatusBadRequest, nil)
+
+	// The same resources are listed by walking the nodes
+	srv.Inject("/cluster/resources", pvetest.Fault{Status: http.StatusNotImplemented, Message: "Method 'GET /cluster/resources' not implemented"})
+	var walked ClusterResourcesResponse
+	getJSON(t, router, "/api/v1/inventory/resources", http.StatusOK, &walked)
+	if len(walked.Data) != len(all.Data) || len(walked.Errors) != 0 {
+		t.Fatalf("expected the same %d resources, got %+v", len(all.Data), walked)
+	}
+	for i := range walked.Data {
+		if walked.Data[i] != all.Data[i] {
+			t.Errorf("expected %+v, got %+v", all.Data[i], walked.Data[i])
+		}
+	}
+}
+
+func TestVmSummaryReportsSlowNodes(t *testing.T) {
+	srv, router := newFakeParent(t, func(cfg *Config) {
+		cfg.Parents[0].Timeout = Duration(300 * time.Millisecond)
+	})
+	// Walk the nodes, as for parents that do not list the cluster resources
+	srv.Inject("/cluster/resources", pvetest.Fault{Status: http.StatusNotImplemented, Message: "Method 'GET /cluster/resources' not implemented"})
+	srv.Inject("/nodes/*/qemu", pvetest.Fault{Delay: 2 * time.Second})
+
+	started := time.Now()
+	var res VmSummaryResponse
+	getJSON(t, router, "/api/v1/virtualization/vm/summary", http.StatusOK, &res)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("expected the request to stop at the timeout, took %v", elapsed)
+	}
+	if len(res.Data) != 0 || len(res.Errors) != 2 {
+		t.Fatalf("expected a timeout for both nodes, got %+v", res)
+	}
+	for _, e := range res.Errors {
+		if e.Action != "timeout" {
+			t.Errorf("expected the action timeout, got %+v", e)
+		}
+	}
+}
+
+func TestVmDetailed(t *testing.T) {
+	_, router := newFakeParent(t)
+	var res QemuGuestWrapper
+	getJSON(t, router, "/api/v1/virtualization/vm/detailed/lab/100", http.StatusOK, &res)
+
+	if len(res.Errors) != 0 {
+		t.Fatalf("expected no errors, got %+v", res.Errors)
+	}
+	if res.Data.Status.Name != "web01" || res.Data.Status.MemoryMB != 3072 || res.Data.Status.MachineVersion != "pc-i440fx-8.1+pve0" {
+		t.Errorf("unexpected status - %+v", res.Data.Status)
+	}
+	if res.Data.Hostname.HostName != "web01.lab.example" || res.Data.OSInfo.MajorVersion != "12" {
+		t.Errorf("unexpected guest agent data - %+v %+v", res.Data.Hostname, res.Data.OSInfo)
+	}
+	if len(res.Data.NetworkInfo) != 2 || res.Data.NetworkInfo[1].IPAddressList[0].IPAddress != "10.0.1.10" {
+		t.Errorf("unexpected network interfaces - %+v", res.Data.NetworkInfo)
+	}
+}
+
+func TestVmDetailedWithoutAgent(t *testing.T) {
+	_, router := newFakeParent(t)
+	var res QemuGuestWrapper
+	getJSON(t, router, "/api/v1/virtualization/vm/detailed/lab/101", http.StatusOK, &res)
+
+	if res.Data.Status.Status != "stopped" {
+		t.Errorf("expected the stopped VM, got %+v", res.Data.Status)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Action != "checkAgent" {
+		t.Errorf("expected a checkAgent error, got %+v", res.Errors)
+	}
+
+	getJSON(t, router, "/api/v1/virtualization/vm/detailed/lab/999", http.StatusNotFound, nil)
+	getJSON(t, router, "/api/v1/virtualization/vm/detailed/missing/100", http.StatusNotFound, nil)
+}
+
+func TestLxcSummary(t *testing.T) {
+	srv, router := newFakeParent(t)
+	var res LxcSummaryResponse
+	getJSON(t, router, "/api/v1/virtualization/lxc/summary", http.StatusOK, &res)
+
+	if len(res.Data) != 2 || len(res.Errors) != 0 {
+		t.Fatalf("expected two containers without errors, got %+v", res)
+	}
+	if ct := res.Data[0]; ct.Vmid != 200 || ct.MemoryMb != 256 || ct.NetoutMb != 20 || ct.UptimeHours != 1 {
+		t.Errorf("unexpected summary of container 200 - %+v", ct)
+	}
+
+	srv.SetOffline("pve2", true)
+	res = LxcSummaryResponse{}
+	getJSON(t, router, "/api/v1/virtualization/lxc/summary", http.StatusOK, &res)
+	// The container of the offline node is still listed, by its last known node
+	if len(res.Data) != 2 || res.Data[1].Status != "unknown" || res.Data[1].NodeStatus != "offline" || findError(res.Errors, "pve2") == nil {
+		t.Errorf("expected container 201 as unknown and an error for pve2, got %+v", res)
+	}
+}
+
+func TestClusters(t *testing.T) {
+	srv, router := newFakeParent(t)
+	var res ClustersResponse
+	getJSON(t, router, "/api/v1/infrastructure/clusters", http.StatusOK, &res)
+
+	if len(res.Data) != 1 || len(res.Errors) != 0 {
+		t.Fatalf("expected the cluster of lab without errors, got %+v", res)
+	}
+	cluster := res.Data[0]
+	if cluster.Standalone || cluster.Name != "lab-cluster" || !cluster.Quorate || cluster.ExpectedVotes != 2 || cluster.TotalVotes != 2 || cluster.Quorum != 2 {
+		t.Errorf("unexpected cluster - %+v", cluster)
+	}
+	if len(cluster.Nodes) != 2 {
+		t.Fatalf("expected two nodes, got %+v", cluster.Nodes)
+	}
+	if n := cluster.Nodes[1]; n.Name != "pve2" || n.NodeID != 2 || !n.Online || n.Local || n.IP != "10.0.0.12" || n.QuorumVotes != 1 || !slices.Equal(n.RingAddresses, []string{"10.0.0.12", "10.1.0.12"}) {
+		t.Errorf("unexpected node pve2 - %+v", n)
+	}
+
+	// One of two votes is not enough
+	srv.SetOffline("pve2", true)
+	res = ClustersResponse{}
+	getJSON(t, router, "/api/v1/infrastructure/clusters/lab", http.StatusOK, &res)
+	if cluster := res.Data[0]; cluster.Quorate || cluster.TotalVotes != 1 || cluster.Nodes[1].Online {
+		t.Errorf("expected the cluster without quorum, got %+v", cluster)
+	}
+
+	// The nodes are still listed without the corosync configuration
+	srv.Inject("/cluster/config/nodes", pvetest.Fault{Status: http.StatusForbidden, Message: "Permission check failed"})
+	res = ClustersResponse{}
+	getJSON(t, router, "/api/v1/infrastructure/clusters/lab", http.StatusOK, &res)
+	if len(res.Data) != 1 || len(res.Data[0].Nodes) != 2 || len(res.Errors) != 1 || res.Errors[0].Action != "getClusterConfigNodes" {
+		t.Errorf("expected the nodes and an error for the configuration, got %+v", res)
+	}
+}
+
+func TestClustersStandalone(t *testing.T) {
+	fixtures := pvetest.DefaultFixtures()
+	fixtures.Cluster = nil
+	standalone := pvetest.NewServer(fixtures)
+	t.Cleanup(standalone.Close)
+	_, router := newFakeParent(t, func(cfg *Config) { cfg.Parents[0].Endpoints = []string{standalone.Address()} })
+
+	var res ClustersResponse
+	getJSON(t, router, "/api/v1/infrastructure/clusters/lab", http.StatusOK, &res)
+	if len(res.Data) != 1 || len(res.Errors) != 0 {
+		t.Fatalf("expected the parent without errors, got %+v", res)
+	}
+	if cluster := res.Data[0]; !cluster.Standalone || !cluster.Quorate || cluster.Name != "" || len(cluster.Nodes) != 1 || !cluster.Nodes[0].Local {
+		t.Errorf("expected a standalone parent, got %+v", cluster)
+	}
+	if standalone.Requests("/cluster/config/nodes") != 0 {
+		t.Errorf("expected no call for the corosync configuration of a standalone parent")
+	}
+}
+
+func TestHA(t *testing.T) {
+	srv, router := newFakeParent(t)
+	var res HAResponse
+	getJSON(t, router, "/api/v1/infrastructure/ha", http.StatusOK, &res)
+
+	if len(res.Data) != 1 || len(res.Errors) != 0 {
+		t.Fatalf("expected the HA stack of lab without errors, got %+v", res)
+	}
+	ha := res.Data[0]
+	if ha.MasterNode != "pve1" || len(ha.Nodes) != 2 || ha.Nodes[0].LRMState != "active" || ha.Nodes[1].Status != "online" {
+		t.Errorf("unexpected manager status - %+v", ha)
+	}
+	if len(ha.Resources) != 2 || ha.Resources[0].SID != "vm:100" || ha.Resources[0].Node != "pve1" || ha.Resources[0].CurrentState != "started" || ha.Resources[1].Group != "" {
+		t.Errorf("unexpected HA resources - %+v", ha.Resources)
+	}
+	if len(ha.Groups) != 1 || !ha.Groups[0].NoFailback || !slices.Equal(ha.Groups[0].Nodes, []HAGroupNode{{"pve1", 2}, {"pve2", 1}}) {
+		t.Errorf("unexpected HA groups - %+v", ha.Groups)
+	}
+
+	// The container of the offline node is fenced, and the groups are gone
+	// like after the migration to rules
+	srv.SetOffline("pve2", true)
+	srv.Inject("/cluster/ha/groups", pvetest.Fault{Status: http.StatusInternalServerError, Message: "ha groups have been migrated to rules"})
+	res = HAResponse{}
+	getJSON(t, router, "/api/v1/infrastructure/ha/lab", http.StatusOK, &res)
+	ha = res.Data[0]
+	if ha.Nodes[1].Status != "unknown" || ha.Resources[1].RequestedState != "started" || ha.Resources[1].CurrentState != "fence" {
+		t.Errorf("expected ct:201 fenced on pve2, got %+v", ha)
+	}
+	if len(ha.Groups) != 0 || len(res.Errors) != 1 || res.Errors[0].Action != "getHAGroups" {
+		t.Errorf("expected no groups and an error for them, got %+v", res)
+	}
+}
+
+func TestGuestsShowHA(t *testing.T) {
+	_, router := newFakeParent(t)
+	var vms VmSummaryResponse
+	getJSON(t, router, "/api/v1/virtualization/vm/summary", http.StatusOK, &vms)
+	if len(vms.Data) != 2 || !vms.Data[0].HAManaged || vms.Data[0].HAState != "started" || vms.Data[1].HAManaged {
+		t.Errorf("expected only VM 100 managed by HA, got %+v", vms.Data)
+	}
+
+	var containers LxcSummaryResponse
+	getJSON(t, router, "/api/v1/virtualization/lxc/summary", http.StatusOK, &containers)
+	if len(containers.Data) != 2 || containers.Data[0].HAManaged || !containers.Data[1].HAManaged {
+		t.Errorf("expected only container 201 managed by HA, got %+v", containers.Data)
+	}
+}
+
+func TestHAGroupNodes(t *testing.T) {
+	got := haGroupNodes("pve3, pve1:2,pve2:1")
+	want := []HAGroupNode{{"pve1", 2}, {"pve2", 1}, {"pve3", 0}}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestParentStatus(t *testing.T) {
+	srv, router := newFakeParent(t)
+	// Let the parent answer once, so the endpoint is known
+	getJSON(t, router, "/api/v1/infrastructure/nodes/summary", http.StatusOK, nil)
+
+	var res ParentStatusResponse
+	getJSON(t, router, "/api/v1/status/parents", http.StatusOK, &res)
+	if len(res.Data) != 1 || res.Data[0].Endpoint != srv.Address() || res.Data[0].Breaker.State != "disabled" {
+		t.Errorf("unexpected parent status - %+v", res)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	_, router := newFakeParent(t, func(cfg *Config) {
+		cfg.Parents = append(cfg.Parents, PVEConnectionObject{Parent: "down", Token: "monitor@pve!test=secret", Endpoints: []string{"127.0.0.1:1"}})
+	})
+	parentHealth.refresh(context.Background(), currentConfig().Parents)
+	t.Cleanup(func() { parentHealth.refresh(context.Background(), nil) })
+
+	var res ReadinessResponse
+	getJSON(t, router, "/readyz", http.StatusOK, &res)
+	if res.Status != "ready" || res.Reachable != 1 || res.Parents != 2 {
+		t.Errorf("expected ready with one of two parents, got %+v", res)
+	}
+
+	currentConfig().Health.Readiness = readinessAll
+	getJSON(t, router, "/readyz", http.StatusServiceUnavailable, &res)
+	if res.Status != "not ready" || res.Message != "1 of 2 parents are not reachable" {
+		t.Errorf("expected not ready with the all policy, got %+v", res)
+	}
+	getJSON(t, router, "/healthz", http.StatusOK, nil)
+}
+
+func TestParentHealth(t *testing.T) {
+	srv, router := newFakeParent(t)
+	parentHealth.refresh(context.Background(), currentConfig().Parents)
+	t.Cleanup(func() { parentHealth.refresh(context.Background(), nil) })
+
+	var res ParentHealthResponse
+	getJSON(t, router, "/api/v1/parents", http.StatusOK, &res)
+	if len(res.Data) != 1 || len(res.Errors) != 0 {
+		t.Fatalf("expected one healthy parent, got %+v", res)
+	}
+	lab := res.Data[0]
+	if !lab.Reachable || lab.Endpoint != srv.Address() || lab.PVEVersion != "8.3.2" ||
+		lab.AuthValid == nil || !*lab.AuthValid || lab.LatencyMs == nil || lab.LastContact == nil {
+		t.Errorf("unexpected health of the parent - %+v", lab)
+	}
+
+	// A rejected token keeps the parent reachable, and the last contact
+	srv.Inject("/version", pvetest.Fault{Status: http.StatusUnauthorized, Message: "authentication failure"})
+	parentHealth.refresh(context.Background(), currentConfig().Parents)
+	getJSON(t, router, "/api/v1/parents", http.StatusOK, &res)
+	lab = res.Data[0]
+	if !lab.Reachable || lab.AuthValid == nil || *lab.AuthValid || lab.LastContact == nil || lab.LatencyMs != nil {
+		t.Errorf("expected rejected credentials, got %+v", lab)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Action != "getVersion" {
+		t.Errorf("expected the failed version call in the errors, got %+v", res.Errors)
+	}
+}
+
+func TestParentHealthChecksRightAwayInTheBackground(t *testing.T) {
+	_, router := newFakeParent(t, func(cfg *Config) {
+		cfg.Health.Interval = Duration(time.Hour)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		parentHealth.refresh(context.Background(), nil)
+	})
+	go func() {
+		defer close(done)
+		parentHealth.run(ctx)
+	}()
+
+	// Ready once the first check is done, without waiting for the interval
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code == http.StatusOK {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the first check to run at once, /readyz still answers %d", rec.Code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	_, router := newFakeParent(t, func(cfg *Config) {
+		cfg.Metrics.Enabled = true
+	})
+	inventory.refresh(context.Background(), currentConfig().Parents)
+	t.Cleanup(func() { inventory.refresh(context.Background(), nil) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	for _, want := range []string{
+		`pve_parent_up{parent="lab"} 1`,
+		`pve_guest_up{name="web01",node="pve1",parent="lab",tags="prod;web",type="qemu",vmid="100"} 1`,
+		`pve_guest_cpu_usage_ratio{name="web01",node="pve1",parent="lab",tags="prod;web",type="qemu",vmid="100"} 0.12`,
+		`pve_guest_cpus{name="dns01",node="pve1",parent="lab",tags="infra",type="lxc",vmid="200"} 1`,
+		`pve_guest_uptime_seconds{name="dns01",node="pve1",parent="lab",tags="infra",type="lxc",vmid="200"} 3600`,
+		`pve_storage_size_bytes{node="pve1",parent="lab",shared="1",storage="ceph",type="rbd"} 4.398046511104e+12`,
+		`pve_api_upstream_requests_total{code="200"`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected the metrics to contain %s", want)
+		}
+	}
+}
+
+func TestCachedInventoryReportsDownParents(t *testing.T) {
+	srv, router := newFakeParent(t, func(cfg *Config) {
+		cfg.Inventory.Enabled = true
+	})
+	srv.Inject("/nodes", pvetest.Fault{Status: http.StatusInternalServerError, Message: "cluster not ready"})
+	inventory.refresh(context.Background(), currentConfig().Parents)
+	t.Cleanup(func() { inventory.refresh(context.Background(), nil) })
+
+	for _, path := range []string{"/api/v1/infrastructure/nodes/summary", "/api/v1/virtualization/vm/summary", "/api/v1/inventory/resources"} {
+		var res VmSummaryResponse
+		getJSON(t, router, path, http.StatusOK, &res)
+		if len(res.Sources) != 1 || res.Sources[0].Parent != "lab" || !res.Sources[0].Cached || findError(res.Errors, "") == nil {
+			t.Errorf("%s: expected the cached outcome of the down parent in the sources, got %+v", path, res)
+		}
+	}
+
+	// ?fresh=true queries the parent live, from the goroutine of every parent
+	var res VmSummaryResponse
+	getJSON(t, router, "/api/v1/virtualization/vm/summary?fresh=true", http.StatusOK, &res)
+	if len(res.Sources) != 1 || res.Sources[0].Cached || len(res.Data) == 0 {
+		t.Errorf("expected the VMs from /cluster/resources, read live, got %+v", res)
+	}
+}
+
+func TestSummariesQueryParentsConcurrently(t *testing.T) {
+	tests := []struct {
+		route string
+		slow  string
+		// ordered routes keep the order of the config
+		ordered bool
+	}{
+		{"/api/v1/infrastructure/nodes/summary", "/nodes", true},
+		{"/api/v1/virtualization/lxc/summary", "/cluster/resources", false},
+		{"/api/v1/infrastructure/clusters", "/cluster/status", true},
+		{"/api/v1/infrastructure/ha", "/cluster/ha/status/manager_status", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			srv, router := newFakeParent(t, func(cfg *Config) {
+				second := cfg.Parents[0]
+				second.Parent = "lab2"
+				cfg.Parents = append(cfg.Parents, second)
+			})
+			srv.Inject(tt.slow, pvetest.Fault{Delay: 300 * time.Millisecond})
+
+			started := time.Now()
+			var res struct {
+				Sources []ParentSource `json:"sources"`
+			}
+			getJSON(t, router, tt.route, http.StatusOK, &res)
+			if elapsed := time.Since(started); elapsed > 550*time.Millisecond {
+				t.Errorf("expected the parents to be queried concurrently, took %s", elapsed)
+			}
+			parents := make([]string, 0, len(res.Sources))
+			for _, s := range res.Sources {
+				parents = append(parents, s.Parent)
+			}
+			if !tt.ordered {
+				slices.Sort(parents)
+			}
+			if !slices.Equal(parents, []string{"lab", "lab2"}) {
+				t.Errorf("expected both parents, got %+v", res.Sources)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve"
+)
+
+const (
+	defaultHealthInterval = 30 * time.Second
+
+	// readinessAny is ready when at least one parent is reachable
+	readinessAny = "any"
+	// readinessAll is ready when every parent is reachable
+	readinessAll = "all"
+	// readinessConfig is ready as soon as a config is loaded
+	readinessConfig = "config"
+)
+
+// HealthConfig sets how often the parents are checked in the background, and
+// which of them must be reachable before /readyz reports the API as ready.
+type HealthConfig struct {
+	Interval  Duration `json:"interval" yaml:"interval"`
+	Readiness string   `json:"readiness" yaml:"readiness"`
+}
+
+func (h *HealthConfig) applyDefaults() {
+	if h.Interval == 0 {
+		h.Interval = Duration(defaultHealthInterval)
+	}
+	if h.Readiness == "" {
+		h.Readiness = readinessAny
+	}
+}
+
+func (h *HealthConfig) validate() error {
+	if h.Interval < Duration(time.Second) {
+		return fmt.Errorf("the health interval must be at least 1s")
+	}
+	switch h.Readiness {
+	case readinessAny, readinessAll, readinessConfig:
+		return nil
+	}
+	return fmt.Errorf("unknown readiness policy %q - use %s, %s or %s", h.Readiness, readinessAny, readinessAll, readinessConfig)
+}
+
+// health reports that the process is up. It is reachable without
+// authentication and does not contact any parent.
+func health(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// readiness reports whether the API can serve requests, by the readiness
+// policy and the last background check of the parents, and why the config
+// file was rejected if it was. It is reachable without authentication and
+// does not contact any parent.
+func readiness(c *gin.Context) {
+	cfg := requestConfig(c)
+	if cfg == nil {
+		c.JSON(http.StatusServiceUnavailable, ReadinessResponse{Status: "not ready", Message: "No configuration is loaded"})
+		return
+	}
+
+	result := ReadinessResponse{
+		Status:    "ready",
+		Policy:    cfg.Health.Readiness,
+		Parents:   len(cfg.Parents),
+		Reachable: parentHealth.reachable(cfg.Parents),
+	}
+	if err := activeConfig.reloadError(); err != nil {
+		result.ConfigError = err.Error()
+	}
+	switch {
+	case cfg.Health.Readiness == readinessAny && result.Reachable == 0:
+		result.Message = "None of the parents is reachable"
+	case cfg.Health.Readiness == readinessAll && result.Reachable < result.Parents:
+		result.Message = fmt.Sprintf("%d of %d parents are not reachable", result.Parents-result.Reachable, result.Parents)
+	}
+	if result.Message != "" {
+		result.Status = "not ready"
+		c.JSON(http.StatusServiceUnavailable, result)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// parentStatus reports the endpoint in use and the circuit breaker state of
+// every parent the caller may read. It does not contact any parent.
+func parentStatus(c *gin.Context) {
+	var (
+		results []ParentStatus
+		errors  []ApiError
+	)
+
+	for _, host := range parentsFor(c) {
+		pc, err := parentClientFor(host)
+		if err != nil {
+			errors = append(errors, ApiError{
+				Parent:  host.Parent,
+				Action:  "tlsConfig",
+				Message: err.Error(),
+			})
+			continue
+		}
+		results = append(results, ParentStatus{
+			Parent:   host.Parent,
+			Endpoint: pc.endpoints.current(),
+			Breaker:  pc.breaker.status(),
+		})
+	}
+
+	c.JSON(http.StatusOK, ParentStatusResponse{
+		Data:   results,
+		Errors: requestErrors(c, errors),
+	})
+}
+
+// parentHealthOverview reports the last background check of every parent the
+// caller may read, with the error of the check when it failed. It does not
+// contact any parent.
+func parentHealthOverview(c *gin.Context) {
+	var (
+		results []ParentHealth
+		errors  []ApiError
+	)
+
+	for _, host := range parentsFor(c) {
+		state, checked := parentHealth.get(host.Parent)
+		if !checked {
+			results = append(results, ParentHealth{Parent: host.Parent})
+			continue
+		}
+		results = append(results, state.health)
+		if state.err != nil {
+			errors = append(errors, *state.err)
+		}
+	}
+
+	c.JSON(http.StatusOK, ParentHealthResponse{
+		Data:   results,
+		Errors: requestErrors(c, errors),
+	})
+}
+
+// parentHealthState is the result of the last check of a parent.
+type parentHealthState struct {
+	health ParentHealth
+	err    *ApiError
+}
+
+// parentHealthStore holds the last check of every parent, refreshed in the
+// background so the health routes never wait for the parents.
+type parentHealthStore struct {
+	mu      sync.RWMutex
+	parents map[string]parentHealthState
+}
+
+var parentHealth = &parentHealthStore{}
+
+// run checks the parents right away, and then on the health interval until
+// ctx is done. The listener does not wait for the first check - /readyz
+// reports the parents as not reachable until it is done.
+func (s *parentHealthStore) run(ctx context.Context) {
+	for {
+		s.refresh(ctx, currentConfig().Parents)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(currentConfig().Health.Interval)):
+		}
+	}
+}
+
+// refresh checks all parents concurrently and swaps in the results. Changes
+// of the reachability are logged. Parents that were removed from the config
+// are dropped. Cancelling ctx stops the checks in flight.
+func (s *parentHealthStore) refresh(ctx context.Context, parents []PVEConnectionObject) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		checked = make(map[string]parentHealthState, len(parents))
+	)
+	for _, host := range parents {
+		wg.Add(1)
+		go func(host PVEConnectionObject) {
+			defer wg.Done()
+			last, seen := s.get(host.Parent)
+			ctx, cancel := parentContext(ctx, host)
+			defer cancel()
+			state := checkParent(ctx, host, last.health)
+
+			if !seen || state.health.Reachable != last.health.Reachable {
+				if state.health.Reachable {
+					slog.InfoContext(ctx, "The parent is reachable", "endpoint", state.health.Endpoint)
+				} else {
+					slog.WarnContext(ctx, "The parent is not reachable", "error", state.err.Message)
+				}
+			}
+			mu.Lock()
+			checked[host.Parent] = state
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	s.parents = checked
+	s.mu.Unlock()
+}
+
+func (s *parentHealthStore) get(parent string) (parentHealthState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.parents[parent]
+	return state, ok
+}
+
+// reachable counts the given parents that were reachable on their last check.
+func (s *parentHealthStore) reachable(parents []PVEConnectionObject) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, host := range parents {
+		if s.parents[host.Parent].health.Reachable {
+			n++
+		}
+	}
+	return n
+}
+
+// checkParent probes the endpoints of a parent and reads its version, which
+// also tells whether the credentials are accepted. The last contact and
+// version are kept from the previous check when the parent does not answer.
+func checkParent(ctx context.Context, host PVEConnectionObject, last ParentHealth) parentHealthState {
+	now := time.Now()
+	h := ParentHealth{
+		Parent:      host.Parent,
+		LastCheck:   &now,
+		LastContact: last.LastContact,
+		PVEVersion:  last.PVEVersion,
+	}
+
+	client, err := clientFor(host)
+	if err != nil {
+		return parentHealthState{health: h, err: &ApiError{
+			Parent:  host.Parent,
+			Action:  "tlsConfig",
+			Message: err.Error(),
+		}}
+	}
+	reachable, err := probeParent(ctx, host)
+	if !reachable {
+		return parentHealthState{health: h, err: &ApiError{
+			Parent:  host.Parent,
+			Action:  errorAction("testHostPort", err),
+			Message: fmt.Sprintf("The parent is not listening on %d - %v", host.Port, err),
+		}}
+	}
+	h.Reachable = true
+
+	started := time.Now()
+	version, err := client.Version(ctx)
+	h.Endpoint = parentSource(host).Endpoint
+	if err != nil {
+		if credentialsRejected(err) {
+			h.AuthValid = new(bool)
+		}
+		return parentHealthState{health: h, err: &ApiError{
+			Parent:  host.Parent,
+			Action:  errorAction("getVersion", err),
+			Message: err.Error(),
+		}}
+	}
+	latency := float64(time.Since(started).Microseconds()) / 1000
+	contact := time.Now()
+	valid := true
+	h.LatencyMs = &latency
+	h.LastContact = &contact
+	h.AuthValid = &valid
+	h.PVEVersion = version.Version
+	return parentHealthState{health: h}
+}
+
+// credentialsRejected reports whether the parent refused the token, or the
+// ticket login failed.
+func credentialsRejected(err error) bool {
+	var (
+		apiErr  *pve.Error
+		authErr *authError
+	)
+	if errors.As(err, &authErr) {
+		return true
+	}
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized
+}
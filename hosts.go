@@ -1,23 +1,38 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func testHostPort(host string, port int) (bool, error) {
-	hostPort := fmt.Sprintf("%s:%d", host, port)
-	_, err := net.DialTimeout("tcp", hostPort, time.Duration(600)*time.Millisecond)
+func testHostPort(ctx context.Context, parent string, host string, port int) (bool, error) {
+	hostPort := net.JoinHostPort(host, strconv.Itoa(port))
+	ctx, span := tracer().Start(ctx, "testHostPort", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("pve.parent", parent),
+		attribute.String("pve.endpoint", hostPort),
+	))
+	started := time.Now()
+	dialer := net.Dialer{Timeout: time.Duration(600) * time.Millisecond}
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort)
+	probeDuration.WithLabelValues(parent, hostPort).Observe(time.Since(started).Seconds())
+	endSpan(span, err)
 	if err != nil {
+		probeFailures.WithLabelValues(parent, hostPort).Inc()
 		return false, err
 	} else {
+		conn.Close()
 		return true, nil
 	}
 }
@@ -26,328 +41,280 @@ func quickHostOverview(c *gin.Context) {
 	var (
 		results []nodeSummaryWrapper
 		errors  []ApiError
+		sources []ParentSource
 	)
 
-	parentObjects, err := convertJSON()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read JSON data"})
-		return
-	}
-
-	for _, host := range parentObjects {
-		portOpen, err := testHostPort(host.Parent, host.Port)
-		if err != nil {
-			errors = append(errors, ApiError{
-				Parent:  host.Parent,
-				Action:  "testHostPort",
-				Message: err.Error(),
-			})
-			log.Printf("Failed to check if the port %d for %s is open - %v", host.Port, host.Parent, err)
-			continue
-		}
-		if portOpen {
-			parentNodes, err := getParentNodes(host.Parent, host.Port, host.Token)
-			if err != nil {
-				errors = append(errors, ApiError{
-					Parent:  host.Parent,
-					Action:  "getParentNodes",
-					Message: err.Error(),
-				})
-				log.Printf("Failed to obtain the nodes under the parent %s - %v", host.Parent, err)
-				continue
-			}
-			for _, node := range parentNodes.Data {
-				var summary nodeSummaryWrapper
-				//If the first object in the slice is empty / offline, then the struct will be limited to only show the fields that have values.
-				if node.NodeStatus != "online" {
-					errors = append(errors, ApiError{
-						Parent:  host.Parent,
-						Node:    node.Node,
-						Action:  "onlineStatus",
-						Message: fmt.Sprintf("The node %s is offline", node.Node),
-					})
-					summary.Parent = host.Parent
-					summary.Node = node.Node
-					summary.NodeStatus = node.NodeStatus
-					summary.MaxCPU = 0
-					summary.MaxMemGb = 0
-					summary.MemGb = 0
-					summary.UptimeHours = 0
-					summary.Cpu = 0
-					summary.MaxRootDiskGb = 0
-					summary.RootDiskGb = 0
-					results = append(results, summary)
-					continue
-				} else {
-					summary.Parent = host.Parent
-					summary.Node = node.Node
-					summary.NodeStatus = node.NodeStatus
-					summary.MaxCPU = node.MaxCPU
-					summary.MaxMemGb = int(node.MaxMem) / (1024 * 1024 * 1024)
-					summary.MemGb = int(node.Mem) / (1024 * 1024 * 1024)
-					summary.UptimeHours = int(node.UptimeHours) / (60 * 60)
-					summary.Cpu = math.Round(node.Cpu * 100)
-					summary.MaxRootDiskGb = node.Maxdisk / (1000 * 1000 * 1000)
-					summary.RootDiskGb = node.Disk / (1000 * 1000 * 1000)
-					results = append(results, summary)
-				}
-
-			}
-		} else {
-			errors = append(errors, ApiError{
-				Parent:  host.Parent,
-				Action:  "testHostPort",
-				Message: fmt.Sprintf("The check to see if the port was open executed with success, but the parent appears to be offline or not listening on %d", host.Port),
-			})
-			log.Printf("The server %s is not listening on %d", host.Parent, host.Port)
-			continue
+	// The parents are queried concurrently, every one into its own batch so
+	// the response keeps the order of the config
+	parents := parentsFor(c)
+	fresh := wantsFresh(c)
+	batches := make([]nodeSummaryBatch, len(parents))
+	var wg sync.WaitGroup
+	for i, host := range parents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batches[i] = parentNodeSummaries(c.Request.Context(), fresh, host)
+		}()
+	}
+	wg.Wait()
+
+	for _, batch := range batches {
+		results = append(results, batch.nodes...)
+		errors = append(errors, batch.errors...)
+		if batch.source != nil {
+			sources = append(sources, *batch.source)
 		}
 	}
 	c.JSON(http.StatusOK, NodeSummaryResponse{
-		Data:   results,
-		Errors: errors,
+		Data:    results,
+		Errors:  requestErrors(c, errors),
+		Sources: sources,
 	})
 }
 
-func getParentNodes(parent string, port int, apiToken string) (PVENodesObject, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes", parent, port)
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Error creating the HTTP request for %s - %v", customUrl, err)
-		return PVENodesObject{}, err
-	}
-	var parentNodes PVENodesObject
-	if err := sendRequest(req, &parentNodes, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return PVENodesObject{}, err
-	}
-
-	return parentNodes, nil
+// nodeSummaryBatch is the outcome of the node summaries of one parent.
+type nodeSummaryBatch struct {
+	nodes  []nodeSummaryWrapper
+	errors []ApiError
+	source *ParentSource
 }
 
-func nodeGuestsOverview(guestType string, parent string, port int, node string, apiToken string) (NodeGuestOverview, error) {
-
-	var customUrl string
-	if guestType == "lxc" {
-		customUrl = fmt.Sprintf("https://%s:%d/api2/json/nodes/%s/lxc", parent, port, node)
-	} else {
-		customUrl = fmt.Sprintf("https://%s:%d/api2/json/nodes/%s/qemu", parent, port, node)
+// parentNodeSummaries reads the node summaries of a parent from the inventory,
+// or live from /nodes.
+func parentNodeSummaries(ctx context.Context, fresh bool, host PVEConnectionObject) (batch nodeSummaryBatch) {
+	if inv := inventory.cached(fresh, host); inv != nil {
+		batch.nodes = inv.Nodes
+		batch.errors = append(append(batch.errors, inv.Errors...), inv.NodeErrors...)
+		source := inv.source()
+		batch.source = &source
+		return batch
 	}
 
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
+	ctx, cancel := parentContext(ctx, host)
+	defer cancel()
+	client, err := clientFor(host)
 	if err != nil {
-		log.Printf("Failed to create the HTTP request for %s - %v", parent, err)
-		return NodeGuestOverview{}, err
+		batch.errors = append(batch.errors, ApiError{
+			Parent:  host.Parent,
+			Action:  "tlsConfig",
+			Message: err.Error(),
+		})
+		slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+		return batch
 	}
-
-	var qemuList NodeGuestOverview
-	if err := sendRequest(req, &qemuList, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return NodeGuestOverview{}, err
+	portOpen, err := probeParent(ctx, host)
+	if err != nil {
+		batch.errors = append(batch.errors, ApiError{
+			Parent:  host.Parent,
+			Action:  errorAction("testHostPort", err),
+			Message: err.Error(),
+		})
+		slog.WarnContext(ctx, "Failed to reach the parent", "port", host.Port, "error", err)
+		return batch
 	}
-
-	for i := range qemuList.Data {
-		qemuList.Data[i].Node = node
-		qemuList.Data[i].Parent = parent
+	if !portOpen {
+		batch.errors = append(batch.errors, ApiError{
+			Parent:  host.Parent,
+			Action:  "testHostPort",
+			Message: fmt.Sprintf("The check to see if the port was open executed with success, but the parent appears to be offline or not listening on %d", host.Port),
+		})
+		slog.WarnContext(ctx, "The parent is not listening", "port", host.Port)
+		return batch
 	}
+	parentNodes, err := client.Nodes(ctx)
+	if err != nil {
+		batch.errors = append(batch.errors, ApiError{
+			Parent:  host.Parent,
+			Action:  errorAction("getParentNodes", err),
+			Message: err.Error(),
+		})
+		slog.WarnContext(ctx, "Failed to obtain the nodes of the parent", "error", err)
+		return batch
+	}
+	source := parentSource(host)
+	batch.source = &source
+	batch.nodes, batch.errors = nodeSummaries(host, parentNodes)
+	return batch
+}
 
-	return qemuList, nil
+// nodeSummaries builds the summary of every node of a parent from /nodes.
+func nodeSummaries(host PVEConnectionObject, nodes []pve.Node) ([]nodeSummaryWrapper, []ApiError) {
+	var (
+		results []nodeSummaryWrapper
+		errors  []ApiError
+	)
+	for _, node := range nodes {
+		var summary nodeSummaryWrapper
+		//If the first object in the slice is empty / offline, then the struct will be limited to only show the fields that have values.
+		if node.Status != "online" {
+			errors = append(errors, ApiError{
+				Parent:  host.Parent,
+				Node:    node.Node,
+				Action:  "onlineStatus",
+				Message: fmt.Sprintf("The node %s is offline", node.Node),
+			})
+			summary.Parent = host.Parent
+			summary.Node = node.Node
+			summary.NodeStatus = node.Status
+			summary.MaxCPU = 0
+			summary.MaxMemGb = 0
+			summary.MemGb = 0
+			summary.UptimeHours = 0
+			summary.Cpu = 0
+			summary.MaxRootDiskGb = 0
+			summary.RootDiskGb = 0
+			results = append(results, summary)
+			continue
+		} else {
+			summary.Parent = host.Parent
+			summary.Node = node.Node
+			summary.NodeStatus = node.Status
+			summary.MaxCPU = node.MaxCPU
+			summary.MaxMemGb = node.MaxMem / (1024 * 1024 * 1024)
+			summary.MemGb = node.Mem / (1024 * 1024 * 1024)
+			summary.UptimeHours = node.Uptime / (60 * 60)
+			summary.Cpu = math.Round(node.CPU * 100)
+			summary.MaxRootDiskGb = node.MaxDisk / (1000 * 1000 * 1000)
+			summary.RootDiskGb = node.Disk / (1000 * 1000 * 1000)
+			results = append(results, summary)
+		}
 
+	}
+	return results, errors
 }
 
 func detailedHostOverview(c *gin.Context) {
 	parentName := c.Param("parent")
-	parentObjects, err := convertJSON()
-	if err != nil {
-		log.Printf("Failed to convert the JSON values - %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to obtain the JSON data from the environment variable - %v", err)})
-		return
-	}
-	var selectedObj PVEConnectionObject
-	var found bool
+	selectedObj, found := parentFor(c, parentName)
 
 	var (
 		results []NodeDetails
 		errors  []ApiError
 	)
 
-	for _, host := range parentObjects {
-		if host.Parent == parentName {
-			selectedObj = host
-			found = true
-			break
+	if found {
+		ctx, cancel := parentContext(c.Request.Context(), selectedObj)
+		defer cancel()
+
+		client, err := clientFor(selectedObj)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+			c.JSON(http.StatusInternalServerError, NodeDetailsResponse{
+				Data: results,
+				Errors: requestErrors(c, append(errors, ApiError{
+					Parent:  selectedObj.Parent,
+					Action:  "tlsConfig",
+					Message: err.Error(),
+				})),
+			})
+			return
 		}
-	}
 
-	if found {
-		parentNodes, err := getParentNodes(selectedObj.Parent, selectedObj.Port, selectedObj.Token)
+		parentNodes, err := client.Nodes(ctx)
 		if err != nil {
 			errors = append(errors, ApiError{
 				Parent:  selectedObj.Parent,
-				Action:  "getParentNodes",
+				Action:  errorAction("getParentNodes", err),
 				Message: err.Error(),
 			})
-			log.Printf("Failed to get nodes for the parent %s - %v", selectedObj.Parent, err)
+			slog.WarnContext(ctx, "Failed to obtain the nodes of the parent", "error", err)
 			c.JSON(http.StatusInternalServerError, NodeDetailsResponse{
 				Data:   results,
-				Errors: errors,
+				Errors: requestErrors(c, errors),
 			})
 			return
 		}
 
-		for _, node := range parentNodes.Data {
+		for _, node := range parentNodes {
 			var details NodeDetails
 
-			if node.NodeStatus != "online" {
+			if node.Status != "online" {
 				errors = append(errors, ApiError{
 					Parent:  selectedObj.Parent,
 					Node:    node.Node,
 					Action:  "onlineStatus",
 					Message: fmt.Sprintf("The node %s appears to be offline", node.Node),
 				})
-				log.Printf("Skipping node %s - its offline", node.Node)
+				slog.InfoContext(ctx, "Skipping an offline node", "node", node.Node)
 				continue
 			}
 
-			nodeStatus, err := getNodeStatus(selectedObj.Parent, selectedObj.Port, selectedObj.Token, node.Node)
+			nodeStatus, err := client.NodeStatus(ctx, node.Node)
 			if err != nil {
 				errors = append(errors, ApiError{
 					Parent:  selectedObj.Parent,
 					Node:    node.Node,
-					Action:  "getNodeStatus",
+					Action:  errorAction("getNodeStatus", err),
 					Message: err.Error(),
 				})
-				log.Printf("Failed to get the node status for %s - %v", node.Node, err)
+				slog.WarnContext(ctx, "Failed to obtain the status of the node", "node", node.Node, "error", err)
 				continue
 			}
 
-			NodeDnsObject, err := getNodeDnsObject(selectedObj.Parent, selectedObj.Port, selectedObj.Token, node.Node)
+			nodeDns, err := client.NodeDNS(ctx, node.Node)
 			if err != nil {
 				errors = append(errors, ApiError{
 					Parent:  selectedObj.Parent,
 					Node:    node.Node,
-					Action:  "getNodeDnsObject",
+					Action:  errorAction("getNodeDnsObject", err),
 					Message: err.Error(),
 				})
-				log.Printf("Failed to get the node DNS for %s - %v", node.Node, err)
+				slog.WarnContext(ctx, "Failed to obtain the DNS configuration of the node", "node", node.Node, "error", err)
 				continue
 			}
 
-			NodeTimeObject, err := getNodeTimeObject(selectedObj.Parent, selectedObj.Port, selectedObj.Token, node.Node)
+			nodeTime, err := client.NodeTime(ctx, node.Node)
 			if err != nil {
 				errors = append(errors, ApiError{
 					Parent:  selectedObj.Parent,
 					Node:    node.Node,
-					Action:  "getNodeTimeObject",
+					Action:  errorAction("getNodeTimeObject", err),
 					Message: err.Error(),
 				})
-				log.Printf("Failed to get the time configuration for %s - %v", node.Node, err)
+				slog.WarnContext(ctx, "Failed to obtain the time configuration of the node", "node", node.Node, "error", err)
 				continue
 			}
 
 			details.NodeInfo.Node = node.Node
 			details.NodeInfo.Parent = selectedObj.Parent
-			details.NodeInfo.NodeStatus = node.NodeStatus
-			details.Dns.Dns1 = NodeDnsObject.Data.Dns1
-			details.Dns.Dns2 = NodeDnsObject.Data.Dns2
-			details.Dns.Dns3 = NodeDnsObject.Data.Dns3
-			details.Dns.Search = NodeDnsObject.Data.Search
-			details.BootInfo.Mode = nodeStatus.Data.BootInfo.Mode
-			details.BootInfo.Secureboot = nodeStatus.Data.BootInfo.Secureboot
-			details.Cpuinfo.Cpus = nodeStatus.Data.Cpuinfo.Cpus
-			details.Cpuinfo.Cores = nodeStatus.Data.Cpuinfo.Cores
-			details.Cpuinfo.Model = nodeStatus.Data.Cpuinfo.Model
-			details.Cpuinfo.Mhz = nodeStatus.Data.Cpuinfo.Mhz
-			details.Cpuinfo.Sockets = nodeStatus.Data.Cpuinfo.Sockets
-			details.Pveversion = nodeStatus.Data.Pveversion
-			details.CurrentKernel.Release = nodeStatus.Data.CurrentKernel.Release
-			details.CurrentKernel.Machine = nodeStatus.Data.CurrentKernel.Machine
-			details.Time.Time = time.Unix(int64(NodeTimeObject.Data.Time), 0)
-			details.Time.Timezone = NodeTimeObject.Data.Timezone
+			details.NodeInfo.NodeStatus = node.Status
+			details.Dns.Dns1 = nodeDns.DNS1
+			details.Dns.Dns2 = nodeDns.DNS2
+			details.Dns.Dns3 = nodeDns.DNS3
+			details.Dns.Search = nodeDns.Search
+			details.BootInfo.Mode = nodeStatus.BootInfo.Mode
+			details.BootInfo.Secureboot = nodeStatus.BootInfo.Secureboot
+			details.Cpuinfo.Cpus = nodeStatus.CPUInfo.Cpus
+			details.Cpuinfo.Cores = nodeStatus.CPUInfo.Cores
+			details.Cpuinfo.Model = nodeStatus.CPUInfo.Model
+			details.Cpuinfo.Mhz = nodeStatus.CPUInfo.Mhz
+			details.Cpuinfo.Sockets = nodeStatus.CPUInfo.Sockets
+			details.Pveversion = nodeStatus.PVEVersion
+			details.CurrentKernel.Release = nodeStatus.CurrentKernel.Release
+			details.CurrentKernel.Machine = nodeStatus.CurrentKernel.Machine
+			details.Time.Time = time.Unix(int64(nodeTime.Time), 0)
+			details.Time.Timezone = nodeTime.Timezone
 			results = append(results, details)
 
 		}
 
 		c.JSON(http.StatusOK, NodeDetailsResponse{
-			Data:   results,
-			Errors: errors,
+			Data:    results,
+			Errors:  requestErrors(c, errors),
+			Sources: []ParentSource{parentSource(selectedObj)},
 		})
 	} else {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("The parent entered (%s) is not present in the environment variable - please adjust.", parentName)})
-	}
-}
-
-func getNodeStatus(parent string, port int, apiToken string, node string) (NodeStatusObject, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%s/status", parent, port, node)
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Failed to create the HTTP request for getNodeStatus - %v", err)
-		return NodeStatusObject{}, err
-	}
-
-	var jsonObject NodeStatusObject
-	if err := sendRequest(req, &jsonObject, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return NodeStatusObject{}, err
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("The parent entered (%s) is not present in the configuration - please adjust.", parentName)})
 	}
-
-	return jsonObject, nil
-}
-
-func getNodeDnsObject(parent string, port int, apiToken string, node string) (NodeDnsObject, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%s/dns", parent, port, node)
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Failed to create the HTTP request for the getNodeDnsObject - %v", err)
-		return NodeDnsObject{}, err
-	}
-
-	var jsonObject NodeDnsObject
-	if err := sendRequest(req, &jsonObject, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return NodeDnsObject{}, err
-	}
-
-	return jsonObject, nil
-}
-
-func getNodeTimeObject(parent string, port int, apiToken string, node string) (NodeTimeObject, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%s/time", parent, port, node)
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Failed to create the HTTP request for the getNodeTimeObject - %v", err)
-		return NodeTimeObject{}, err
-	}
-	var jsonObject NodeTimeObject
-	if err := sendRequest(req, &jsonObject, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return NodeTimeObject{}, err
-	}
-
-	return jsonObject, nil
-
 }
 
 func getNodeStorageOverview(c *gin.Context) {
 	parentName := c.Param("parent")
-	parentObjects, err := convertJSON()
-	if err != nil {
-		log.Printf("Failed to convert the JSON objects - %v", err)
-		return
-	}
-
-	var selectedObj PVEConnectionObject
-	var found bool
-	for _, host := range parentObjects {
-		if host.Parent == parentName {
-			selectedObj = host
-			found = true
-			break
-		}
-	}
+	selectedObj, found := parentFor(c, parentName)
 
 	if !found {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("The parent entered (%s) is not present in the environment variable - please adjust.", parentName)})
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("The parent entered (%s) is not present in the configuration - please adjust.", parentName)})
 		return
 	}
 
@@ -356,155 +323,147 @@ func getNodeStorageOverview(c *gin.Context) {
 		errors      []ApiError
 	)
 
-	portOpen, err := testHostPort(selectedObj.Parent, selectedObj.Port)
+	if inv := inventory.cached(wantsFresh(c), selectedObj); inv != nil && inv.Up {
+		c.JSON(http.StatusOK, NodeStorageResponse{
+			Data:    inv.Storage,
+			Errors:  requestErrors(c, inv.StorageErrors),
+			Sources: []ParentSource{inv.source()},
+		})
+		return
+	}
+
+	ctx, cancel := parentContext(c.Request.Context(), selectedObj)
+	defer cancel()
+
+	client, err := clientFor(selectedObj)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+		c.JSON(http.StatusInternalServerError, NodeStorageResponse{
+			Data: storageList.Data,
+			Errors: requestErrors(c, append(errors, ApiError{
+				Parent:  selectedObj.Parent,
+				Action:  "tlsConfig",
+				Message: err.Error(),
+			})),
+		})
+		return
+	}
+
+	portOpen, err := probeParent(ctx, selectedObj)
 	if err != nil {
 		errors = append(errors, ApiError{
 			Parent:  selectedObj.Parent,
-			Action:  "testHostPort",
+			Action:  errorAction("testHostPort", err),
 			Message: err.Error(),
 		})
-		log.Printf("Failed to check if the port was open on %s:%d - %v", selectedObj.Parent, selectedObj.Port, err)
+		slog.WarnContext(ctx, "Failed to reach the parent", "port", selectedObj.Port, "error", err)
 		c.JSON(http.StatusInternalServerError, NodeStorageResponse{
 			Data:   storageList.Data,
-			Errors: errors,
+			Errors: requestErrors(c, errors),
 		})
 		return
 	}
 
 	if portOpen {
 
-		parentNodes, err := getParentNodes(selectedObj.Parent, selectedObj.Port, selectedObj.Token)
+		parentNodes, err := client.Nodes(ctx)
 		if err != nil {
 			errors = append(errors, ApiError{
 				Parent:  selectedObj.Parent,
-				Action:  "testHostPort",
+				Action:  errorAction("testHostPort", err),
 				Message: err.Error(),
 			})
-			log.Printf("Failed to obtain the cluster nodes for %s - %v", selectedObj.Parent, err)
+			slog.WarnContext(ctx, "Failed to obtain the nodes of the parent", "error", err)
 			c.JSON(http.StatusInternalServerError, NodeStorageResponse{
 				Data:   storageList.Data,
-				Errors: errors,
+				Errors: requestErrors(c, errors),
 			})
 			return
 		}
 
-		for _, node := range parentNodes.Data {
-			if node.NodeStatus != "online" {
-				errors = append(errors, ApiError{
-					Parent:  selectedObj.Parent,
-					Node:    node.Node,
-					Action:  "onlineStatus",
-					Message: fmt.Sprintf("The node %s appears to be offline", node.Node),
-				})
-				log.Printf("Skipping node %s - its offline", node.Node)
-				continue
-			}
-
-			nodeStorage, err := getNodeStorage(selectedObj.Parent, selectedObj.Port, selectedObj.Token, node.Node)
-			if err != nil {
-				errors = append(errors, ApiError{
-					Parent:  selectedObj.Parent,
-					Node:    node.Node,
-					Action:  "getNodeStorage",
-					Message: err.Error(),
-				})
-				log.Printf("Failed to obtain the storage for %s - %v", node.Node, err)
-				continue
-			}
-
-			for _, storage := range nodeStorage.Data {
-				var details NodeStorageInfo
-				details.Parent = selectedObj.Parent
-				details.Node = node.Node
-				details.NodeStatus = node.NodeStatus
-				details.Active = storage.Active
-				details.Content = storage.Content
-				details.Enabled = storage.Enabled
-				details.Shared = storage.Shared
-				details.Type = storage.Type
-				details.Storage = storage.Storage
-				details.TotalGb = storage.Total / (1024 * 1024 * 1024)
-				details.AvailableGb = storage.Available / (1024 * 1024 * 1024)
-				details.UsedGb = storage.Used / (1024 * 1024 * 1024)
-
-				storageList.Data = append(storageList.Data, details)
-			}
-
-		}
+		storage, storageErrors := nodeStorage(ctx, client, selectedObj, parentNodes)
+		storageList.Data = append(storageList.Data, storage...)
+		errors = append(errors, storageErrors...)
 	} else {
 		c.JSON(http.StatusBadGateway, NodeStorageResponse{
 			Data: storageList.Data,
-			Errors: append(errors, ApiError{
+			Errors: requestErrors(c, append(errors, ApiError{
 				Parent:  selectedObj.Parent,
 				Action:  "testHostPort",
 				Message: fmt.Sprintf("port %d closed", selectedObj.Port),
-			}),
+			})),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, NodeStorageResponse{
-		Data:   storageList.Data,
-		Errors: errors,
+		Data:    storageList.Data,
+		Errors:  requestErrors(c, errors),
+		Sources: []ParentSource{parentSource(selectedObj)},
 	})
 }
 
-func getNodeStorage(parent string, port int, apiToken string, node string) (hostStorageList, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%s/storage", parent, port, node)
-
-	var nodeStorageObj hostStorageList
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Failed to create HTTP request for getNodeStorage on %s - %v", customUrl, err)
-		return hostStorageList{}, err
-	}
-
-	if err := sendRequest(req, &nodeStorageObj, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return hostStorageList{}, err
-	}
-	return nodeStorageObj, nil
-}
+// nodeStorage lists the storage of every online node of a parent.
+func nodeStorage(ctx context.Context, client *pve.Client, host PVEConnectionObject, nodes []pve.Node) ([]NodeStorageInfo, []ApiError) {
+	var (
+		results []NodeStorageInfo
+		errors  []ApiError
+	)
+	for _, node := range nodes {
+		if node.Status != "online" {
+			errors = append(errors, ApiError{
+				Parent:  host.Parent,
+				Node:    node.Node,
+				Action:  "onlineStatus",
+				Message: fmt.Sprintf("The node %s appears to be offline", node.Node),
+			})
+			slog.InfoContext(ctx, "Skipping an offline node", "node", node.Node)
+			continue
+		}
 
-func getNodeDisks(parent string, port int, apiToken string, node string) (hostDiskList, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%s/disks/list", parent, port, node)
+		storageObj, err := client.Storage(ctx, node.Node)
+		if err != nil {
+			errors = append(errors, ApiError{
+				Parent:  host.Parent,
+				Node:    node.Node,
+				Action:  errorAction("getNodeStorage", err),
+				Message: err.Error(),
+			})
+			slog.WarnContext(ctx, "Failed to obtain the storage of the node", "node", node.Node, "error", err)
+			continue
+		}
 
-	var nodeStorageObj hostDiskList
+		for _, storage := range storageObj {
+			var details NodeStorageInfo
+			details.Parent = host.Parent
+			details.Node = node.Node
+			details.NodeStatus = node.Status
+			details.Active = storage.Active
+			details.Content = storage.Content
+			details.Enabled = storage.Enabled
+			details.Shared = storage.Shared
+			details.Type = storage.Type
+			details.Storage = storage.Storage
+			details.TotalGb = storage.Total / (1024 * 1024 * 1024)
+			details.AvailableGb = storage.Avail / (1024 * 1024 * 1024)
+			details.UsedGb = storage.Used / (1024 * 1024 * 1024)
+			details.totalBytes = storage.Total
+			details.availableBytes = storage.Avail
+			details.usedBytes = storage.Used
 
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Failed to create HTTP request for getNodeDisks on %s - %v", customUrl, err)
-		return hostDiskList{}, err
-	}
+			results = append(results, details)
+		}
 
-	if err := sendRequest(req, &nodeStorageObj, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return hostDiskList{}, err
 	}
-
-	return nodeStorageObj, nil
+	return results, errors
 }
 
 func getNodeDiskOverview(c *gin.Context) {
 	parentName := c.Param("parent")
-	parentObjects, err := convertJSON()
-	if err != nil {
-		log.Printf("Failed to convert the JSON objects - %v", err)
-		return
-	}
-
-	var selectedObj PVEConnectionObject
-	var found bool
-	for _, host := range parentObjects {
-		if host.Parent == parentName {
-			selectedObj = host
-			found = true
-			break
-		}
-	}
+	selectedObj, found := parentFor(c, parentName)
 
 	if !found {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("The parent entered (%s) is not present in the environment variable - please adjust.", parentName)})
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("The parent entered (%s) is not present in the configuration - please adjust.", parentName)})
 		return
 	}
 
@@ -513,134 +472,170 @@ func getNodeDiskOverview(c *gin.Context) {
 		errors   []ApiError
 	)
 
-	portOpen, err := testHostPort(selectedObj.Parent, selectedObj.Port)
+	if inv := inventory.cached(wantsFresh(c), selectedObj); inv != nil && inv.Up {
+		c.JSON(http.StatusOK, NodeDiskObject{
+			Data:    inv.Disks,
+			Errors:  requestErrors(c, inv.DiskErrors),
+			Sources: []ParentSource{inv.source()},
+		})
+		return
+	}
+
+	ctx, cancel := parentContext(c.Request.Context(), selectedObj)
+	defer cancel()
+
+	client, err := clientFor(selectedObj)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+		c.JSON(http.StatusInternalServerError, NodeDiskObject{
+			Data: diskList.Data,
+			Errors: requestErrors(c, append(errors, ApiError{
+				Parent:  selectedObj.Parent,
+				Action:  "tlsConfig",
+				Message: err.Error(),
+			})),
+		})
+		return
+	}
+
+	portOpen, err := probeParent(ctx, selectedObj)
 	if err != nil {
 		errors = append(errors, ApiError{
 			Parent:  selectedObj.Parent,
-			Action:  "testHostPort",
+			Action:  errorAction("testHostPort", err),
 			Message: err.Error(),
 		})
-		log.Printf("Failed to check if the port was open on %s:%d - %v", selectedObj.Parent, selectedObj.Port, err)
+		slog.WarnContext(ctx, "Failed to reach the parent", "port", selectedObj.Port, "error", err)
 		c.JSON(http.StatusBadGateway, NodeDiskObject{
 			Data:   diskList.Data,
-			Errors: errors,
+			Errors: requestErrors(c, errors),
 		})
 		return
 	}
 
 	if portOpen {
-		parentNodes, err := getParentNodes(selectedObj.Parent, selectedObj.Port, selectedObj.Token)
+		parentNodes, err := client.Nodes(ctx)
 		if err != nil {
-			log.Printf(
-				"Failed to obtain the nodes for the parent %s - %v", selectedObj.Parent, err)
+			slog.WarnContext(ctx, "Failed to obtain the nodes of the parent", "error", err)
 			c.JSON(http.StatusInternalServerError, NodeDiskObject{
 				Data: diskList.Data,
-				Errors: append(errors, ApiError{
+				Errors: requestErrors(c, append(errors, ApiError{
 					Parent:  selectedObj.Parent,
-					Action:  "getParentNodes",
+					Action:  errorAction("getParentNodes", err),
 					Message: err.Error(),
-				}),
+				})),
 			})
 			return
 		}
 
-		ch := make(chan []NodeDiskInfo, len(parentNodes.Data))
-		errCh := make(chan ApiError, len(parentNodes.Data)*2)
-		for _, node := range parentNodes.Data {
-			n := node
-			go func() {
-				var disks []NodeDiskInfo
-				if n.NodeStatus != "online" {
-					errCh <- ApiError{
-						Parent:  selectedObj.Parent,
-						Node:    n.Node,
-						Action:  "onlineStatus",
-						Message: fmt.Sprintf("The node %s appears to be offline", n.Node),
-					}
-					// Sends an empty response, so the the channel is not blocked
-					ch <- disks
-					log.Printf("Skipping node %s - its offline", n.Node)
-					return
-				}
-				var nodeStorage hostDiskList
-				nodeStorage, err := getNodeDisks(selectedObj.Parent, selectedObj.Port, selectedObj.Token, n.Node)
-				if err != nil {
-					errCh <- ApiError{
-						Parent:  selectedObj.Parent,
-						Node:    n.Node,
-						Action:  "getNodeDisks",
-						Message: err.Error(),
-					}
-					log.Printf("Failed to obtain the disks for %s - %v", n.Node, err)
-					return
-				}
+		disks, diskErrors := nodeDisks(ctx, client, selectedObj, parentNodes)
+		diskList.Data = append(diskList.Data, disks...)
+		errors = append(errors, diskErrors...)
 
-				for _, disk := range nodeStorage.Data {
-
-					var details NodeDiskInfo
+		c.JSON(http.StatusOK, NodeDiskObject{
+			Data:    diskList.Data,
+			Errors:  requestErrors(c, errors),
+			Sources: []ParentSource{parentSource(selectedObj)},
+		})
+	} else {
+		c.JSON(http.StatusBadGateway, NodeDiskObject{
+			Data: diskList.Data,
+			Errors: requestErrors(c, append(errors, ApiError{
+				Parent:  selectedObj.Parent,
+				Action:  "testHostPort",
+				Message: fmt.Sprintf("port %d closed", selectedObj.Port),
+			})),
+		})
+		return
+	}
 
-					switch val := disk.Wearout.(type) {
-					case float64:
-						details.Wearout = int(val)
-					case string:
-						details.Wearout = int(100)
-					}
+}
 
-					switch val := disk.Rpm.(type) {
-					case float64:
-						details.Rpm = int(val)
-					case string:
-						i, err := strconv.Atoi(val)
-						if err != nil {
-							log.Printf("Failed to convert the RPM value for %s - %v", n.Node, err)
-						}
-						details.Rpm = i
-					}
+// nodeDisks lists the physical disks of every online node of a parent. The
+// nodes are queried concurrently.
+func nodeDisks(ctx context.Context, client *pve.Client, host PVEConnectionObject, nodes []pve.Node) ([]NodeDiskInfo, []ApiError) {
+	var (
+		results []NodeDiskInfo
+		errors  []ApiError
+	)
+	ch := make(chan []NodeDiskInfo, len(nodes))
+	errCh := make(chan ApiError, len(nodes)*2)
+	for _, node := range nodes {
+		n := node
+		go func() {
+			var disks []NodeDiskInfo
+			if n.Status != "online" {
+				errCh <- ApiError{
+					Parent:  host.Parent,
+					Node:    n.Node,
+					Action:  "onlineStatus",
+					Message: fmt.Sprintf("The node %s appears to be offline", n.Node),
+				}
+				// Sends an empty response, so the the channel is not blocked
+				ch <- disks
+				slog.InfoContext(ctx, "Skipping an offline node", "node", n.Node)
+				return
+			}
+			nodeDisks, err := client.Disks(ctx, n.Node)
+			if err != nil {
+				errCh <- ApiError{
+					Parent:  host.Parent,
+					Node:    n.Node,
+					Action:  errorAction("getNodeDisks", err),
+					Message: err.Error(),
+				}
+				slog.WarnContext(ctx, "Failed to obtain the disks of the node", "node", n.Node, "error", err)
+				ch <- disks
+				return
+			}
 
-					details.Parent = selectedObj.Parent
-					details.Node = n.Node
-					details.NodeStatus = n.NodeStatus
-					details.Gpt = disk.Gpt
-					details.Vendor = disk.Vendor
-					details.Devpath = disk.Devpath
-					details.Health = disk.Health
-					details.Type = disk.Type
-					details.Serial = disk.Serial
-					details.Model = disk.Model
-					details.SizeGb = disk.Size / (1024 * 1024 * 1024)
+			for _, disk := range nodeDisks {
 
-					disks = append(disks, details)
+				var details NodeDiskInfo
 
+				if wearout, ok := disk.WearoutPercent(); ok {
+					details.Wearout = wearout
+					details.hasWearout = true
+				} else if _, ok := disk.Wearout.(string); ok {
+					details.Wearout = 100
+				}
+				if disk.RPM != nil {
+					rpm, ok := disk.Speed()
+					if !ok {
+						slog.WarnContext(ctx, "Failed to convert the RPM value of a disk", "node", n.Node, "devpath", disk.DevPath, "rpm", disk.RPM)
+					}
+					details.Rpm = rpm
 				}
 
-				ch <- disks
+				details.Parent = host.Parent
+				details.Node = n.Node
+				details.NodeStatus = n.Status
+				details.Gpt = disk.GPT
+				details.Vendor = disk.Vendor
+				details.Devpath = disk.DevPath
+				details.Health = disk.Health
+				details.Type = disk.Type
+				details.Serial = disk.Serial
+				details.Model = disk.Model
+				details.SizeGb = disk.Size / (1024 * 1024 * 1024)
+				details.sizeBytes = disk.Size
+
+				disks = append(disks, details)
 
-			}()
-		}
+			}
 
-		for range parentNodes.Data {
-			batch := <-ch
-			diskList.Data = append(diskList.Data, batch...)
-		}
+			ch <- disks
 
-		for len(errCh) > 0 {
-			errors = append(errors, <-errCh)
-		}
+		}()
+	}
 
-		c.JSON(http.StatusOK, NodeDiskObject{
-			Data:   diskList.Data,
-			Errors: errors,
-		})
-	} else {
-		c.JSON(http.StatusBadGateway, NodeDiskObject{
-			Data: diskList.Data,
-			Errors: append(errors, ApiError{
-				Parent:  selectedObj.Parent,
-				Action:  "testHostPort",
-				Message: fmt.Sprintf("port %d closed", selectedObj.Port),
-			}),
-		})
-		return
+	for range nodes {
+		batch := <-ch
+		results = append(results, batch...)
 	}
 
+	for len(errCh) > 0 {
+		errors = append(errors, <-errCh)
+	}
+	return results, errors
 }
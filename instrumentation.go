@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics about the API itself and its calls to the parents. They are kept
+// in the default registry and served on /metrics next to the inventory.
+var (
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pve_api_upstream_request_duration_seconds",
+		Help:    "The duration of requests to the Proxmox API, per attempt on an endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"parent", "endpoint"})
+	upstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pve_api_upstream_requests_total",
+		Help: "Requests to the Proxmox API by status code - code is \"error\" when no response was received.",
+	}, []string{"parent", "endpoint", "code"})
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pve_api_upstream_errors_total",
+		Help: "Failed calls to the parents after retries, by the reason of the failure.",
+	}, []string{"parent", "reason"})
+	upstreamInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pve_api_upstream_in_flight_requests",
+		Help: "Requests to the parent that are in flight, bounded by maxConcurrent.",
+	}, []string{"parent"})
+	upstreamLimitWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pve_api_upstream_limit_wait_seconds",
+		Help:    "How long requests waited for the concurrency and rate limits of the parent.",
+		Buckets: []float64{.001, .01, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"parent"})
+	probeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pve_api_probe_duration_seconds",
+		Help:    "The duration of the TCP checks of the endpoints.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"parent", "endpoint"})
+	probeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pve_api_probe_failures_total",
+		Help: "Failed TCP checks of the endpoints.",
+	}, []string{"parent", "endpoint"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pve_api_http_requests_total",
+		Help: "Requests served by the API, by route and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pve_api_http_request_duration_seconds",
+		Help:    "The duration of the requests served by the API, by route.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"method", "route"})
+)
+
+// recordUpstreamError counts a failed call to a parent, once after its
+// retries. Errors are counted under the same reason errorAction reports, e.g.
+// timeout or tlsVerify, and "connection" otherwise. Of the answers, only
+// rejected credentials ("authenticate") and server errors ("status") count -
+// a 404 is often expected, and every status is in upstreamRequests.
+func recordUpstreamError(parent string, res *http.Response, err error) {
+	switch {
+	case err != nil:
+		upstreamErrors.WithLabelValues(parent, errorAction("connection", err)).Inc()
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		upstreamErrors.WithLabelValues(parent, "authenticate").Inc()
+	case res.StatusCode >= http.StatusInternalServerError:
+		upstreamErrors.WithLabelValues(parent, "status").Inc()
+	}
+}
+
+// observeUpstream records one attempt of a request on an endpoint.
+func observeUpstream(parent, endpoint string, started time.Time, code int, err error) {
+	upstreamDuration.WithLabelValues(parent, endpoint).Observe(time.Since(started).Seconds())
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(code)
+	}
+	upstreamRequests.WithLabelValues(parent, endpoint, status).Inc()
+}
+
+// instrumentHTTP records the count and duration of the requests per route.
+// Requests that match no route are counted as "unmatched", so random paths
+// cannot create new series.
+func instrumentHTTP(c *gin.Context) {
+	started := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(started).Seconds())
+}
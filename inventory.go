@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve"
+)
+
+const defaultInventoryInterval = time.Minute
+
+// InventoryConfig enables the background collection of the inventory for the
+// summary, storage and disk routes. They then answer from the last
+// collection, unless the request asks for fresh data with ?fresh=true.
+type InventoryConfig struct {
+	Enabled  bool     `json:"enabled" yaml:"enabled"`
+	Interval Duration `json:"interval" yaml:"interval"`
+}
+
+func (i *InventoryConfig) applyDefaults() {
+	if i.Interval == 0 {
+		i.Interval = Duration(defaultInventoryInterval)
+	}
+}
+
+func (i *InventoryConfig) validate() error {
+	if i.Interval < Duration(time.Second) {
+		return fmt.Errorf("the inventory interval must be at least 1s")
+	}
+	return nil
+}
+
+// parentInventory is everything collected from one parent in a single pass.
+// Errors holds the failure that stopped the collection of the whole parent,
+// the other error lists the failures of single nodes.
+type parentInventory struct {
+	Parent    string
+	Source    ParentSource
+	Collected time.Time
+	Up        bool
+	Errors    []ApiError
+
+	NodeInfo       []pve.Node
+	Nodes          []nodeSummaryWrapper
+	NodeErrors     []ApiError
+	Resources      []pve.Resource
+	ResourceErrors []ApiError
+	Vms            []VmSummary
+	VmErrors       []ApiError
+	Lxc            []LxcInfo
+	LxcErrors      []ApiError
+	Storage        []NodeStorageInfo
+	StorageErrors  []ApiError
+	Disks          []NodeDiskInfo
+	DiskErrors     []ApiError
+}
+
+// collectInventory queries the nodes, guests, storage and disks of a parent.
+func collectInventory(ctx context.Context, host PVEConnectionObject) *parentInventory {
+	inv := &parentInventory{Parent: host.Parent, Source: ParentSource{Parent: host.Parent}, Collected: time.Now()}
+
+	client, err := clientFor(host)
+	if err != nil {
+		inv.Errors = append(inv.Errors, ApiError{
+			Parent:  host.Parent,
+			Action:  "tlsConfig",
+			Message: err.Error(),
+		})
+		slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+		return inv
+	}
+	portOpen, err := probeParent(ctx, host)
+	if !portOpen {
+		inv.Errors = append(inv.Errors, ApiError{
+			Parent:  host.Parent,
+			Action:  errorAction("testHostPort", err),
+			Message: fmt.Sprintf("The parent is not listening on %d - %v", host.Port, err),
+		})
+		slog.WarnContext(ctx, "Failed to reach the parent for the inventory", "error", err)
+		return inv
+	}
+	parentNodes, err := client.Nodes(ctx)
+	if err != nil {
+		inv.Errors = append(inv.Errors, ApiError{
+			Parent:  host.Parent,
+			Action:  errorAction("getParentNodes", err),
+			Message: err.Error(),
+		})
+		slog.WarnContext(ctx, "Failed to obtain the nodes of the parent for the inventory", "error", err)
+		return inv
+	}
+
+	inv.Up = true
+	inv.Source = parentSource(host)
+	inv.NodeInfo = parentNodes
+	inv.Nodes, inv.NodeErrors = nodeSummaries(host, parentNodes)
+	resources, resourceErrors, _ := parentResources(ctx, client, host, parentNodes)
+	inv.Resources, inv.ResourceErrors = resources, resourceErrors.of()
+	inv.Vms, inv.VmErrors = resourceVms(host, resources), resourceErrors.of(pve.ResourceQemu)
+	inv.Lxc, inv.LxcErrors = resourceLxc(host, resources), resourceErrors.of(pve.ResourceLXC)
+	inv.Storage, inv.StorageErrors = nodeStorage(ctx, client, host, parentNodes)
+	inv.Disks, inv.DiskErrors = nodeDisks(ctx, client, host, parentNodes)
+	inv.Collected = time.Now()
+	return inv
+}
+
+// inventoryStore holds the last inventory of every parent, refreshed in the
+// background so readers never wait for the parents.
+type inventoryStore struct {
+	mu      sync.RWMutex
+	parents map[string]*parentInventory
+}
+
+var inventory = &inventoryStore{}
+
+// run refreshes the inventory on the inventory interval until ctx is done,
+// when either the inventory or the metrics need it. The interval and parents
+// are read from the current config on every pass, so reloads apply to the
+// next one.
+func (s *inventoryStore) run(ctx context.Context) {
+	for {
+		cfg := currentConfig()
+		if cfg.Inventory.Enabled || cfg.Metrics.Enabled {
+			s.refresh(ctx, cfg.Parents)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(cfg.Inventory.Interval)):
+		}
+	}
+}
+
+// refresh collects all parents concurrently and swaps in the new inventory.
+// Parents that were removed from the config are dropped. Cancelling ctx
+// stops the calls in flight.
+func (s *inventoryStore) refresh(ctx context.Context, parents []PVEConnectionObject) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		collected = make(map[string]*parentInventory, len(parents))
+	)
+	for _, host := range parents {
+		wg.Add(1)
+		go func(host PVEConnectionObject) {
+			defer wg.Done()
+			ctx, cancel := parentContext(ctx, host)
+			defer cancel()
+			inv := collectInventory(ctx, host)
+			mu.Lock()
+			collected[host.Parent] = inv
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	s.parents = collected
+	s.mu.Unlock()
+}
+
+// wantsFresh reports whether the parents are queried live - the caller asked
+// with ?fresh=true, or the inventory is disabled in the config of the
+// request. Handlers read it once, before they fan out to the parents, as the
+// gin.Context must not be used from other goroutines.
+func wantsFresh(c *gin.Context) bool {
+	fresh, _ := strconv.ParseBool(c.Query("fresh"))
+	return fresh || !requestConfig(c).Inventory.Enabled
+}
+
+// cached returns the last inventory of a parent when the request should be
+// answered from it - the parent has been collected and the request does not
+// want fresh data (see wantsFresh).
+func (s *inventoryStore) cached(fresh bool, host PVEConnectionObject) *parentInventory {
+	if fresh {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.parents[host.Parent]
+}
+
+// source reports the endpoint the inventory was collected through, and how
+// old the inventory is. It is reported for parents that were down on the last
+// collection as well, so callers can tell how old that outcome is.
+func (inv *parentInventory) source() ParentSource {
+	source := inv.Source
+	source.Cached = true
+	source.SnapshotAge = int(time.Since(inv.Collected).Seconds())
+	return source
+}
+
+// snapshot returns the inventories of the given parents, sorted by name.
+// Parents that were not collected yet are left out.
+func (s *inventoryStore) snapshot(parents []PVEConnectionObject) []*parentInventory {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*parentInventory
+	for _, host := range parents {
+		if inv, ok := s.parents[host.Parent]; ok {
+			result = append(result, inv)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Parent < result[j].Parent })
+	return result
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const defaultMaxConcurrent = 8
+
+// limitTransport bounds the calls to a parent. At most cap(sem) requests are
+// in flight at once - a slot is held until the response body is closed - and
+// with a limiter requests are started no faster than its rate. Callers wait
+// for a slot until their context is done.
+//
+// The slots belong to the client of the parent. A config reload keeps the
+// client unless its transport settings change (see transportKey); when the
+// limits change, the new client starts with empty slots while the calls in
+// flight on the old one finish, so for that moment up to both limits
+// together can be in flight.
+type limitTransport struct {
+	base    http.RoundTripper
+	parent  string
+	sem     chan struct{}
+	limiter *rate.Limiter
+}
+
+func newLimitTransport(base http.RoundTripper, host PVEConnectionObject) *limitTransport {
+	t := &limitTransport{
+		base:   base,
+		parent: host.Parent,
+		sem:    make(chan struct{}, host.MaxConcurrent),
+	}
+	if host.RateLimit > 0 {
+		t.limiter = rate.NewLimiter(rate.Limit(host.RateLimit), host.RateBurst)
+	}
+	return t
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	started := time.Now()
+
+	select {
+	case t.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a free connection slot to %s - %w", t.parent, ctx.Err())
+	}
+	if t.limiter != nil {
+		if err := t.limiter.Wait(ctx); err != nil {
+			<-t.sem
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return nil, fmt.Errorf("waiting for the rate limit of %s - %w", t.parent, err)
+		}
+	}
+	upstreamLimitWait.WithLabelValues(t.parent).Observe(time.Since(started).Seconds())
+	upstreamInFlight.WithLabelValues(t.parent).Inc()
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.release()
+		return nil, err
+	}
+	res.Body = &releaseBody{ReadCloser: res.Body, release: t.release}
+	return res, nil
+}
+
+func (t *limitTransport) release() {
+	upstreamInFlight.WithLabelValues(t.parent).Dec()
+	<-t.sem
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the
+// wrapped transport.
+func (t *limitTransport) CloseIdleConnections() {
+	if ci, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// releaseBody frees the slot of a request once its body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// defaultRateBurst lets a full second worth of requests through at once, so
+// a handler fanning out over a few nodes is not spread out needlessly.
+func defaultRateBurst(limit float64) int {
+	return max(1, int(math.Ceil(limit)))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ksl28/proxmox-simple-api/pve/pvetest"
+)
+
+func TestConcurrencyLimitHoldsExtraRequests(t *testing.T) {
+	const limit = 2
+	srv, _ := newFakeParent(t, func(cfg *Config) {
+		cfg.Parents[0].MaxConcurrent = limit
+	})
+	srv.Inject("/nodes", pvetest.Fault{Delay: 300 * time.Millisecond})
+	client, err := clientFor(currentConfig().Parents[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold every slot with a response that is slow to arrive
+	var wg sync.WaitGroup
+	for range limit + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Nodes(context.Background()); err != nil {
+				t.Errorf("unexpected error - %v", err)
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := srv.Requests("/nodes"); got != limit {
+		t.Fatalf("expected %d requests to reach the parent while the slots are held, got %d", limit, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Nodes(ctx); err == nil || !strings.Contains(err.Error(), "waiting for a free connection slot") {
+		t.Errorf("expected a call to give up waiting for a slot, got %v", err)
+	}
+
+	wg.Wait()
+	if got := srv.Requests("/nodes"); got != limit+1 {
+		t.Errorf("expected the waiting request to be sent once a slot was free, got %d requests", got)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ListenerTLS serves the API over HTTPS. With ClientCAFile set, client
+// certificates signed by that CA are verified - and required when
+// RequireClientCert is true.
+type ListenerTLS struct {
+	CertFile          string `json:"certFile" yaml:"certFile"`
+	KeyFile           string `json:"keyFile" yaml:"keyFile"`
+	ClientCAFile      string `json:"clientCAFile" yaml:"clientCAFile"`
+	RequireClientCert bool   `json:"requireClientCert" yaml:"requireClientCert"`
+}
+
+func (l *ListenerTLS) enabled() bool {
+	return l.CertFile != "" || l.KeyFile != ""
+}
+
+func (l *ListenerTLS) validate() error {
+	if !l.enabled() {
+		if l.ClientCAFile != "" || l.RequireClientCert {
+			return fmt.Errorf("client certificates require certFile and keyFile on the listener")
+		}
+		return nil
+	}
+	if l.CertFile == "" || l.KeyFile == "" {
+		return fmt.Errorf("the listener needs both certFile and keyFile")
+	}
+	if l.RequireClientCert && l.ClientCAFile == "" {
+		return fmt.Errorf("requireClientCert needs a clientCAFile")
+	}
+	if _, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile); err != nil {
+		return fmt.Errorf("failed to load the listener certificate - %w", err)
+	}
+	if l.ClientCAFile != "" {
+		if _, err := loadCertPool(l.ClientCAFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA bundle %s - %w", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("the CA bundle %s contains no certificates", path)
+	}
+	return pool, nil
+}
+
+// certCheckInterval is how often the listener certificate, key and client CA
+// files are checked for changes.
+const certCheckInterval = 30 * time.Second
+
+// certReloader serves the listener certificate and client CA, and loads them
+// again when one of the files changes, so renewed certificates apply without
+// a restart. The files are checked on a timer rather than on every handshake.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes [3]time.Time
+}
+
+// reload loads the files again if any of them changed since the last load.
+// While the files are missing or broken, e.g. halfway through a renewal, the
+// previous certificate and CA are kept.
+func (r *certReloader) reload() error {
+	var modTimes [3]time.Time
+	for i, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("the listener certificate files are not readable - %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		if pool, err = loadCertPool(r.caFile); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil {
+		slog.Info("Reloaded the listener certificate", "path", r.certFile, "clientCA", r.caFile)
+	}
+	r.cert = &cert
+	r.clientCA = pool
+	r.modTimes = modTimes
+	return nil
+}
+
+// watch checks the files every interval until ctx is done.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				slog.Warn("Failed to reload the listener certificate, keeping the previous one", "error", err)
+			}
+		}
+	}
+}
+
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.clientCA
+}
+
+// listenerTLSConfig builds the server TLS settings for the listener. The
+// certificate and client CA are reloaded until ctx is done.
+func listenerTLSConfig(ctx context.Context, l ListenerTLS) (*tls.Config, error) {
+	reloader := &certReloader{certFile: l.CertFile, keyFile: l.KeyFile, caFile: l.ClientCAFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	go reloader.watch(ctx, certCheckInterval)
+	return reloader.serverConfig(l), nil
+}
+
+// serverConfig returns TLS settings that hand out the current certificate and
+// client CA on every handshake.
+func (r *certReloader) serverConfig(l ListenerTLS) *tls.Config {
+	clientAuth := tls.NoClientCert
+	if l.ClientCAFile != "" {
+		clientAuth = tls.VerifyClientCertIfGiven
+		if l.RequireClientCert {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"h2", "http/1.1"},
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   clientAuth,
+			}, nil
+		},
+	}
+}
+
+// ClientCertIdentity grants access to callers presenting a verified client
+// certificate. Subject matches a URI or DNS name in the subject alternative
+// names, as in "spiffe://lab/dashboard", the full subject DN, as in
+// "CN=dashboard,O=Team A", or only the common name - in that order.
+type ClientCertIdentity struct {
+	Subject string   `json:"subject" yaml:"subject"`
+	Parents []string `json:"parents" yaml:"parents"`
+	Routes  []string `json:"routes" yaml:"routes"`
+	Scopes  []string `json:"scopes" yaml:"scopes"`
+}
+
+type clientCertAuthenticator struct{}
+
+func (clientCertAuthenticator) authenticate(r *http.Request, cfg *Config) (*principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(cfg.Auth.ClientCerts) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	id, name, found := clientCertIdentity(cert, cfg.Auth.ClientCerts)
+	if !found {
+		return nil, nil
+	}
+	return &principal{Name: name, Method: "clientCert", Parents: id.Parents, Routes: id.Routes, Scopes: id.Scopes}, nil
+}
+
+// clientCertIdentity finds the identity of a certificate. The subject
+// alternative names are matched first, as the common name is deprecated for
+// identities, and then the subject DN and the common name. name is the value
+// that matched, or the subject DN.
+func clientCertIdentity(cert *x509.Certificate, ids []ClientCertIdentity) (id ClientCertIdentity, name string, found bool) {
+	var sans []string
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	sans = append(sans, cert.DNSNames...)
+	for _, id := range ids {
+		if slices.Contains(sans, id.Subject) {
+			return id, id.Subject, true
+		}
+	}
+	subject := cert.Subject.String()
+	for _, id := range ids {
+		if id.Subject == subject || id.Subject == cert.Subject.CommonName {
+			return id, subject, true
+		}
+	}
+	return ClientCertIdentity{}, "", false
+}
+
+// validateClientCerts checks the client certificate identities of the config.
+func validateClientCerts(ids []ClientCertIdentity, parents []PVEConnectionObject) error {
+	var seen []string
+	for i, id := range ids {
+		if id.Subject == "" {
+			return fmt.Errorf("client certificate identity #%d has no subject", i+1)
+		}
+		if slices.Contains(seen, id.Subject) {
+			return fmt.Errorf("client certificate subject %s is defined more than once", id.Subject)
+		}
+		seen = append(seen, id.Subject)
+		name := "client certificate " + id.Subject
+		if err := validateScopes(name, id.Parents, id.Routes, parents); err != nil {
+			return err
+		}
+		if err := validateAccessScopes(name, id.Scopes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, signed by itself or by a CA.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerial, testWrites int64
+
+func newTestCert(t *testing.T, template *x509.Certificate, ca *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	template.SerialNumber = big.NewInt(testSerial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+// write stores the certificate and key as PEM files.
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}))
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	}
+}
+
+// writeFile writes content and moves the mtime forward, so a reload notices
+// the change even on file systems with a coarse mtime.
+func writeFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	testWrites++
+	stamp := time.Now().Add(time.Duration(testWrites) * time.Second)
+	if err := os.Chtimes(path, stamp, stamp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+func TestListenerReloadsCertificateAndClientCA(t *testing.T) {
+	dir := t.TempDir()
+	l := ListenerTLS{
+		CertFile:          filepath.Join(dir, "tls.crt"),
+		KeyFile:           filepath.Join(dir, "tls.key"),
+		ClientCAFile:      filepath.Join(dir, "clients-ca.pem"),
+		RequireClientCert: true,
+	}
+	serverCA, oldClientCA, newClientCA := newTestCA(t, "server-ca"), newTestCA(t, "old-clients"), newTestCA(t, "new-clients")
+	serverTemplate := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "127.0.0.1"},
+			DNSNames:    []string{"localhost"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+	}
+	newTestCert(t, serverTemplate(), serverCA).write(t, l.CertFile, l.KeyFile)
+	oldClientCA.write(t, l.ClientCAFile, "")
+
+	reloader := &certReloader{certFile: l.CertFile, keyFile: l.KeyFile, caFile: l.ClientCAFile}
+	if err := reloader.reload(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = reloader.serverConfig(l)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	client := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "dashboard"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, newClientCA)
+	get := func() (*tls.ConnectionState, error) {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: []tls.Certificate{client.tlsCertificate()},
+		}}
+		defer transport.CloseIdleConnections()
+		res, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err != nil {
+			return nil, err
+		}
+		res.Body.Close()
+		return res.TLS, nil
+	}
+
+	if _, err := get(); err == nil {
+		t.Fatal("expected a client certificate of an unknown CA to be refused")
+	}
+
+	// Files that are halfway through a renewal keep the previous ones
+	writeFile(t, l.CertFile, []byte("truncated"))
+	if err := reloader.reload(); err == nil {
+		t.Error("expected a broken certificate file to fail the reload")
+	}
+	if cert, _ := reloader.current(); cert == nil {
+		t.Fatal("expected the previous certificate to be kept")
+	}
+
+	renewed := newTestCert(t, serverTemplate(), serverCA)
+	renewed.write(t, l.CertFile, l.KeyFile)
+	newClientCA.write(t, l.ClientCAFile, "")
+	// The watcher checks the files every certCheckInterval, run the check
+	// directly instead.
+	if err := reloader.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := get()
+	if err != nil {
+		t.Fatalf("expected the client certificate to be accepted after the CA was reloaded - %v", err)
+	}
+	if got := state.PeerCertificates[0].SerialNumber; got.Cmp(renewed.cert.SerialNumber) != 0 {
+		t.Errorf("expected the renewed certificate %v, got %v", renewed.cert.SerialNumber, got)
+	}
+}
+
+func TestClientCertIdentityPrefersSANs(t *testing.T) {
+	ca := newTestCA(t, "clients")
+	spiffe, _ := url.Parse("spiffe://lab/dashboard")
+	cert := newTestCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "dashboard", Organization: []string{"Team A"}},
+		URIs:     []*url.URL{spiffe},
+		DNSNames: []string{"dashboard.lab.example"},
+	}, ca).cert
+
+	tests := []struct {
+		name string
+		ids  []ClientCertIdentity
+		want string
+	}{
+		{"URI before CN", []ClientCertIdentity{{Subject: "dashboard"}, {Subject: "spiffe://lab/dashboard"}}, "spiffe://lab/dashboard"},
+		{"DNS before DN", []ClientCertIdentity{{Subject: "CN=dashboard,O=Team A"}, {Subject: "dashboard.lab.example"}}, "dashboard.lab.example"},
+		{"full DN", []ClientCertIdentity{{Subject: "CN=dashboard,O=Team A"}}, "CN=dashboard,O=Team A"},
+		{"common name", []ClientCertIdentity{{Subject: "dashboard"}}, "dashboard"},
+		{"no match", []ClientCertIdentity{{Subject: "spiffe://lab/other"}, {Subject: "other"}}, ""},
+	}
+	for _, tt := range tests {
+		id, _, found := clientCertIdentity(cert, tt.ids)
+		if tt.want == "" {
+			if found {
+				t.Errorf("%s: expected no identity, got %s", tt.name, id.Subject)
+			}
+			continue
+		}
+		if !found || id.Subject != tt.want {
+			t.Errorf("%s: expected %s, got %s (found %v)", tt.name, tt.want, id.Subject, found)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "requestID"
+)
+
+// LogConfig sets the level (debug, info, warn or error) and the format
+// (json or text) of the logs. Both apply on a reload.
+type LogConfig struct {
+	Level  string `json:"level" yaml:"level"`
+	Format string `json:"format" yaml:"format"`
+}
+
+func (l *LogConfig) applyDefaults() {
+	if l.Level == "" {
+		l.Level = "info"
+	}
+	if l.Format == "" {
+		l.Format = logFormatJSON
+	}
+}
+
+func (l *LogConfig) validate() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return fmt.Errorf("unknown log level %q - use debug, info, warn or error", l.Level)
+	}
+	if l.Format != logFormatJSON && l.Format != logFormatText {
+		return fmt.Errorf("unknown log format %q - use %s or %s", l.Format, logFormatJSON, logFormatText)
+	}
+	return nil
+}
+
+// configureLogging replaces the default logger. The standard log package
+// writes through it too, at the info level.
+func configureLogging(l LogConfig, w io.Writer) {
+	l.applyDefaults()
+	var level slog.Level
+	level.UnmarshalText([]byte(l.Level))
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewJSONHandler(w, opts)
+	if l.Format == logFormatText {
+		h = slog.NewTextHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+}
+
+func init() {
+	configureLogging(LogConfig{}, os.Stderr)
+}
+
+type logAttrsKey struct{}
+
+// withLogAttrs returns a context whose log records carry the given
+// attributes, e.g. the request ID or the parent, on top of those ctx
+// already carries.
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return context.WithValue(ctx, logAttrsKey{}, append(slices.Clip(existing), attrs...))
+}
+
+// contextHandler adds the attributes of withLogAttrs to every record logged
+// with a context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// assignRequestID takes the X-Request-ID of the caller, or creates one, and
+// echoes it in the response. It is added to the logs of the request and to
+// the errors in the response.
+func assignRequestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+
+	c.Request = c.Request.WithContext(withLogAttrs(c.Request.Context(), slog.String("request_id", id)))
+	c.Next()
+}
+
+// validRequestID accepts IDs of up to 128 printable ASCII characters, so a
+// caller cannot inject line breaks or huge values into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestErrors returns a copy of errors with the request ID of c, so
+// errors shared with the inventory are not changed.
+func requestErrors(c *gin.Context, errors []ApiError) []ApiError {
+	if errors == nil {
+		return nil
+	}
+	id := c.GetString(requestIDKey)
+	stamped := make([]ApiError, len(errors))
+	for i, e := range errors {
+		e.RequestID = id
+		stamped[i] = e
+	}
+	return stamped
+}
+
+// accessLog logs every request once it is served, with the caller, the
+// route and how long it took.
+func accessLog(c *gin.Context) {
+	started := time.Now()
+	c.Next()
+
+	identity := "-"
+	if p, ok := c.Keys[principalKey].(*principal); ok {
+		identity = p.Name
+	}
+	level := slog.LevelInfo
+	if c.Writer.Status() >= 500 {
+		level = slog.LevelWarn
+	}
+	attrs := []any{
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"route", c.FullPath(),
+		"status", c.Writer.Status(),
+		"duration_ms", msSince(started),
+		"client_ip", c.ClientIP(),
+		"identity", identity,
+	}
+	for _, param := range []string{"parent", "id"} {
+		if v := c.Param(param); v != "" {
+			attrs = append(attrs, logParamName(param), v)
+		}
+	}
+	if errs := c.Errors.String(); errs != "" {
+		attrs = append(attrs, "error", strings.TrimSpace(errs))
+	}
+	slog.Log(c.Request.Context(), level, "Served a request", attrs...)
+}
+
+// logParamName names the route parameters like the other log fields, e.g.
+// the :id of a VM becomes vmid.
+func logParamName(param string) string {
+	if param == "id" {
+		return "vmid"
+	}
+	return param
+}
+
+// upstreamLogAttrs describes a call to a parent by its path, with the node
+// and the guest it is about when the path names them.
+func upstreamLogAttrs(path string) []any {
+	attrs := []any{"upstream_path", path}
+	parts := strings.Split(strings.TrimPrefix(path, apiPathPrefix+"/"), "/")
+	if len(parts) >= 2 && parts[0] == "nodes" {
+		attrs = append(attrs, "node", parts[1])
+		if len(parts) >= 4 && (parts[2] == "qemu" || parts[2] == "lxc") {
+			if _, err := strconv.Atoi(parts[3]); err == nil {
+				attrs = append(attrs, "vmid", parts[3])
+			}
+		}
+	}
+	return attrs
+}
+
+// msSince returns the time since started in milliseconds.
+func msSince(started time.Time) float64 {
+	return float64(time.Since(started).Microseconds()) / 1000
+}
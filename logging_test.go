@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestRequestIDIsEchoedInErrors(t *testing.T) {
+	srv, router := newFakeParent(t)
+	srv.SetOffline("pve2", true)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/virtualization/vm/summary", nil)
+	req.Header.Set(requestIDHeader, "deploy-check-42")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); got != "deploy-check-42" {
+		t.Errorf("expected the request ID of the caller to be echoed, got %q", got)
+	}
+	var res VmSummaryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if e := findError(res.Errors, "pve2"); e == nil || e.RequestID != "deploy-check-42" {
+		t.Errorf("expected the error of pve2 to carry the request ID, got %+v", res.Errors)
+	}
+
+	// IDs with line breaks are replaced, so they cannot forge log lines
+	req = httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(requestIDHeader, "x\nlevel=ERROR")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(got) {
+		t.Errorf("expected a generated request ID, got %q", got)
+	}
+}
+
+func TestLogsCarryRequestContext(t *testing.T) {
+	_, router := newFakeParent(t)
+	var buf bytes.Buffer
+	configureLogging(LogConfig{Level: "debug", Format: logFormatJSON}, &buf)
+	t.Cleanup(func() { configureLogging(LogConfig{}, os.Stderr) })
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/virtualization/vm/detailed/lab/100", nil)
+	req.Header.Set(requestIDHeader, "trace-me")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var upstream, served map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("expected JSON logs, got %s", scanner.Text())
+		}
+		switch {
+		case line["msg"] == "Called the parent" && line["upstream_path"] == "/api2/json/nodes/pve1/qemu/100/status/current":
+			upstream = line
+		case line["msg"] == "Served a request":
+			served = line
+		}
+	}
+
+	if upstream == nil || upstream["request_id"] != "trace-me" || upstream["parent"] != "lab" ||
+		upstream["node"] != "pve1" || upstream["vmid"] != "100" || upstream["duration_ms"] == nil {
+		t.Errorf("expected the upstream call with the request context, got %v", upstream)
+	}
+	if served == nil || served["request_id"] != "trace-me" || served["status"] != float64(200) ||
+		served["route"] != "/api/v1/virtualization/vm/detailed/:parent/:id" || served["vmid"] != "100" {
+		t.Errorf("expected the access log of the request, got %v", served)
+	}
+}
@@ -1,102 +1,84 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve"
 )
 
 func lxcSummary(c *gin.Context) {
-	parentObjects, err := convertJSON()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to convert the JSON data - %v", err)})
-		return
-	}
-
 	var (
-		allLxc []LxcInfo
-		errors []ApiError
+		allLxc  []LxcInfo
+		errors  []ApiError
+		sources []ParentSource
 	)
 
+	fresh := wantsFresh(c)
+	parentObjects := parentsFor(c)
+
+	// Every parent sends exactly one batch, whether it failed or not
+	type parentBatch struct {
+		lxc    []LxcInfo
+		errors []ApiError
+		source *ParentSource
+	}
+	ch := make(chan parentBatch, len(parentObjects))
 	for _, host := range parentObjects {
-		portOpen, err := testHostPort(host.Parent, host.Port)
-		if err != nil {
-			errors = append(errors, ApiError{
-				Parent:  host.Parent,
-				Action:  "testHostPort",
-				Message: err.Error(),
-			})
-			log.Printf("Failed to check if the port %d for %s is open - %v", host.Port, host.Parent, err)
-			continue
-		}
-		if portOpen {
-			parentNodes, err := getParentNodes(host.Parent, host.Port, host.Token)
+		go func(host PVEConnectionObject) {
+			var batch parentBatch
+			defer func() { ch <- batch }()
+
+			if inv := inventory.cached(fresh, host); inv != nil {
+				batch.lxc = inv.Lxc
+				batch.errors = append(append(batch.errors, inv.Errors...), inv.LxcErrors...)
+				source := inv.source()
+				batch.source = &source
+				return
+			}
+
+			ctx, cancel := parentContext(c.Request.Context(), host)
+			defer cancel()
+
+			client, err := clientFor(host)
 			if err != nil {
-				errors = append(errors, ApiError{
+				batch.errors = append(batch.errors, ApiError{
 					Parent:  host.Parent,
-					Action:  "getParentNodes",
+					Action:  "tlsConfig",
 					Message: err.Error(),
 				})
-				log.Printf("Failed to obtain the datacenter nodes for %s - %v", host.Parent, err)
-				continue
+				slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+				return
 			}
-			for _, node := range parentNodes.Data {
-				if node.NodeStatus != "online" {
-					errors = append(errors, ApiError{
-						Parent:  host.Parent,
-						Node:    node.Node,
-						Action:  "onlineStatus",
-						Message: fmt.Sprintf("The node %s is offline according to Proxmox", node.Node),
-					})
-					log.Printf("Skipping node %s - its offline", node.Node)
-					continue
-				}
-				lxcNodeUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%v/lxc", host.Parent, host.Port, node.Node)
-				req, err := http.NewRequest(http.MethodGet, lxcNodeUrl, nil)
-				if err != nil {
-					errors = append(errors, ApiError{
-						Parent:  host.Parent,
-						Node:    node.Node,
-						Action:  "createRequest",
-						Message: err.Error(),
-					})
-					log.Printf("Failed to create the LXC per node url for %s - %v", node.Node, err)
-					continue
-				}
-
-				var details LxcEntryObject
-				if err := sendRequest(req, &details, host.Token); err != nil {
-					errors = append(errors, ApiError{
-						Parent:  host.Parent,
-						Node:    node.Node,
-						Action:  "fetchLXC",
-						Message: err.Error(),
-					})
-					log.Printf("Failed to process the request for %s - error %v", lxcNodeUrl, err)
-					continue
-				}
-
-				for _, entry := range details.Data {
-					allLxc = append(allLxc, LxcInfo{
-						Parent:      host.Parent,
-						Node:        node.Node,
-						NodeStatus:  node.NodeStatus,
-						DiskreadMb:  entry.Diskread / (1024 * 1024),
-						DiskwriteMb: entry.Diskwrite / (1024 * 1024),
-						MaxMemoryMb: entry.MaxMemory / (1024 * 1024),
-						MemoryMb:    entry.Memory / (1024 * 1024),
-						NetinMb:     entry.Netin / (1024 * 1024),
-						NetoutMb:    entry.Netout / (1024 * 1024),
-						Name:        entry.Name,
-						UptimeHours: entry.Uptime / (60 * 60),
-						Tags:        entry.Tags,
-						Status:      entry.Status,
-						Vmid:        entry.Vmid,
-					})
+			portOpen, err := probeParent(ctx, host)
+			if err != nil {
+				batch.errors = append(batch.errors, ApiError{
+					Parent:  host.Parent,
+					Action:  errorAction("testHostPort", err),
+					Message: err.Error(),
+				})
+				slog.WarnContext(ctx, "Failed to reach the parent", "port", host.Port, "error", err)
+				return
+			}
+			if portOpen {
+				resources, parentErrors, ok := parentResources(ctx, client, host, nil, pve.ResourceLXC)
+				batch.errors = append(batch.errors, parentErrors.of(pve.ResourceLXC)...)
+				if !ok {
+					return
 				}
+				source := parentSource(host)
+				batch.source = &source
+				batch.lxc = resourceLxc(host, resources)
 			}
+		}(host)
+	}
+	for range parentObjects {
+		batch := <-ch
+		allLxc = append(allLxc, batch.lxc...)
+		errors = append(errors, batch.errors...)
+		if batch.source != nil {
+			sources = append(sources, *batch.source)
 		}
 	}
 
@@ -105,7 +87,8 @@ func lxcSummary(c *gin.Context) {
 		return
 	}
 	c.JSON(http.StatusOK, LxcSummaryResponse{
-		Data:   allLxc,
-		Errors: errors,
+		Data:    allLxc,
+		Errors:  requestErrors(c, errors),
+		Sources: sources,
 	})
 }
@@ -1,82 +1,120 @@
 package main
 
 import (
-	"crypto/tls"
-	"encoding/json"
-	"fmt"
-	"log"
-	"net/http"
+	"context"
+	"flag"
+	"log/slog"
+	"net"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func convertJSON() ([]PVEConnectionObject, error) {
-	envVar, ok := os.LookupEnv("OBJECTS_JSON")
-	if !ok {
-		log.Println("No data found in OBJECTS_JSON variable")
-		return nil, fmt.Errorf("no data found in OBJECTS_JSON variable")
-	}
+func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or JSON config file (falls back to OBJECTS_JSON)")
+	flag.Parse()
 
-	var objects []PVEConnectionObject
-	if err := json.Unmarshal([]byte(envVar), &objects); err != nil {
-		var singleObject PVEConnectionObject
-		if err := json.Unmarshal([]byte(envVar), &singleObject); err != nil {
-			return objects, err
-		} else {
-			objects = []PVEConnectionObject{singleObject}
-		}
+	if err := activeConfig.loadInitial(*configPath); err != nil {
+		slog.Error("Failed to load the configuration", "error", err)
+		os.Exit(1)
 	}
-	return objects, nil
-}
-
-var httpClient *http.Client
-
-func main() {
-	httpClient = &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	cfg := currentConfig()
+	// The debug output of gin is not structured, so it is only shown along
+	// with the other debug logs
+	if cfg.Log.Level != "debug" {
+		gin.SetMode(gin.ReleaseMode)
 	}
 
-	allNodes, err := convertJSON()
+	// SIGTERM or SIGINT stop the background workers and drain the listener
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	shutdownTracing, err := setupTracing(ctx, cfg.Tracing, os.Stdout)
 	if err != nil {
-		log.Fatalf("Failed to decode the JSON content - %v", err)
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
 	}
 
-	for _, obj := range allNodes {
-		v, _ := testHostPort(obj.Parent, obj.Port)
-		if !v {
-			log.Printf("%v is not listening on port %d", obj.Parent, obj.Port)
-		} else {
-			log.Printf("%v is listening on port %d", obj.Parent, obj.Port)
-		}
-
+	var workers sync.WaitGroup
+	for _, worker := range []func(context.Context){activeConfig.watch, inventory.run, parentHealth.run} {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker(ctx)
+		}()
 	}
 
-	apiPort, ok := os.LookupEnv("apiport")
-	if !ok {
-		apiPort = "8080"
+	ln, err := net.Listen("tcp", cfg.listenAddress())
+	if err != nil {
+		slog.Error("Failed to listen", "address", cfg.listenAddress(), "error", err)
+		os.Exit(1)
+	}
+	if err := serve(ctx, ln, cfg, newRouter(cfg)); err != nil {
+		slog.Error("The listener stopped", "error", err)
+	}
+	stop()
+	workers.Wait()
+	// The spans still buffered are flushed within the shutdown timeout
+	flushCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Listen.Timeouts.Shutdown))
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("Failed to flush the traces", "error", err)
 	}
+	cancel()
+	slog.Info("Stopped")
+}
 
-	router := gin.Default()
+// newRouter sets up the middleware and routes of the API.
+func newRouter(cfg *Config) *gin.Engine {
+	router := gin.New()
+	router.Use(loadRequestConfig, assignRequestID, traceRequest, accessLog, gin.Recovery(), instrumentHTTP)
 
-	trustedProxy, ok := os.LookupEnv("trusted_proxy")
-	if ok {
-		router.SetTrustedProxies([]string{trustedProxy})
-		log.Printf("A trusted proxy was defined - %s", trustedProxy)
+	if len(cfg.Listen.TrustedProxies) > 0 {
+		router.SetTrustedProxies(cfg.Listen.TrustedProxies)
+		slog.Info("Trusted proxies were defined", "proxies", cfg.Listen.TrustedProxies)
 	} else {
 		router.SetTrustedProxies([]string{})
-		log.Printf("No trusted proxies were defined")
+		slog.Info("No trusted proxies were defined")
+	}
+	if !cfg.Auth.enabled() {
+		slog.Warn("No API keys or OIDC issuer are configured - the API is reachable without authentication")
 	}
-	router.GET("/api/v1/infrastructure/nodes/summary", quickHostOverview)
-	router.GET("/api/v1/infrastructure/nodes/detailed/:parent", detailedHostOverview)
-	router.GET("/api/v1/infrastructure/nodes/detailed/:parent/storage", getNodeStorageOverview)
-	router.GET("/api/v1/infrastructure/nodes/detailed/:parent/disks", getNodeDiskOverview)
-	router.GET("/api/v1/virtualization/vm/summary", vmSummary)
-	router.GET("/api/v1/virtualization/vm/detailed/:parent/:id", vmDetailedOverview)
-	router.GET("/api/v1/virtualization/lxc/summary", lxcSummary)
-
-	apiListener := fmt.Sprintf("0.0.0.0:%v", apiPort)
-	router.Run(apiListener)
+
+	router.GET("/health", health)
+	router.GET("/healthz", health)
+	router.GET("/readyz", readiness)
+	router.GET("/openapi.json", openAPISpec)
+	router.GET("/docs", openAPIUI)
+	router.GET("/docs/assets/:file", openAPIUIAsset)
+
+	router.GET("/metrics", authenticate, authorizeRoute("metrics"), metrics)
+
+	api := router.Group("/api/v1", authenticate)
+	api.GET("/parents", authorizeRoute("status"), parentHealthOverview)
+
+	infrastructure := api.Group("/infrastructure", authorizeRoute("infrastructure"))
+	infrastructure.GET("/nodes/summary", quickHostOverview)
+	infrastructure.GET("/nodes/detailed/:parent", detailedHostOverview)
+	infrastructure.GET("/nodes/detailed/:parent/storage", getNodeStorageOverview)
+	infrastructure.GET("/nodes/detailed/:parent/disks", getNodeDiskOverview)
+	infrastructure.GET("/clusters", clusterOverview)
+	infrastructure.GET("/clusters/:parent", parentClusterOverview)
+	infrastructure.GET("/ha", haOverview)
+	infrastructure.GET("/ha/:parent", parentHAOverview)
+
+	virtualization := api.Group("/virtualization", authorizeRoute("virtualization"))
+	virtualization.GET("/vm/summary", vmSummary)
+	virtualization.GET("/vm/detailed/:parent/:id", vmDetailedOverview)
+	virtualization.GET("/lxc/summary", lxcSummary)
+
+	inventoryRoutes := api.Group("/inventory", authorizeRoute("inventory"))
+	inventoryRoutes.GET("/resources", inventoryResources)
+
+	status := api.Group("/status", authorizeRoute("status"))
+	status.GET("/parents", parentStatus)
+
+	return router
 }
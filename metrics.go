@@ -0,0 +1,186 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MetricsConfig enables the inventory metrics. The nodes, guests, storage and
+// disks of every parent are collected in the background on the inventory
+// interval, and scrapes are answered from the last collection. Interval is
+// the deprecated name of the inventory interval, from before the inventory
+// served the API routes as well.
+type MetricsConfig struct {
+	Enabled  bool     `json:"enabled" yaml:"enabled"`
+	Interval Duration `json:"interval" yaml:"interval"`
+}
+
+var (
+	guestLabels   = []string{"parent", "node", "type", "vmid", "name", "tags"}
+	storageLabels = []string{"parent", "node", "storage", "type", "shared"}
+	diskLabels    = []string{"parent", "node", "devpath", "type", "model", "serial"}
+
+	parentUpDesc        = prometheus.NewDesc("pve_parent_up", "Whether the last inventory collection of the parent succeeded.", []string{"parent"}, nil)
+	parentCollectedDesc = prometheus.NewDesc("pve_inventory_collected_timestamp_seconds", "When the inventory of the parent was last collected.", []string{"parent"}, nil)
+	parentErrorsDesc    = prometheus.NewDesc("pve_inventory_errors", "The number of errors of the last inventory collection of the parent.", []string{"parent"}, nil)
+
+	nodeUpDesc          = prometheus.NewDesc("pve_node_up", "Whether the node is online.", []string{"parent", "node"}, nil)
+	nodeCpusDesc        = prometheus.NewDesc("pve_node_cpus", "The number of CPUs of the node.", []string{"parent", "node"}, nil)
+	nodeCpuDesc         = prometheus.NewDesc("pve_node_cpu_usage_ratio", "The CPU usage of the node, from 0 to 1.", []string{"parent", "node"}, nil)
+	nodeMemDesc         = prometheus.NewDesc("pve_node_memory_used_bytes", "The memory used on the node.", []string{"parent", "node"}, nil)
+	nodeMaxMemDesc      = prometheus.NewDesc("pve_node_memory_total_bytes", "The memory of the node.", []string{"parent", "node"}, nil)
+	nodeRootDiskDesc    = prometheus.NewDesc("pve_node_rootfs_used_bytes", "The space used on the root filesystem of the node.", []string{"parent", "node"}, nil)
+	nodeMaxRootDiskDesc = prometheus.NewDesc("pve_node_rootfs_size_bytes", "The size of the root filesystem of the node.", []string{"parent", "node"}, nil)
+	nodeUptimeDesc      = prometheus.NewDesc("pve_node_uptime_seconds", "The uptime of the node.", []string{"parent", "node"}, nil)
+
+	guestUpDesc     = prometheus.NewDesc("pve_guest_up", "Whether the VM or container is running.", guestLabels, nil)
+	guestCpusDesc   = prometheus.NewDesc("pve_guest_cpus", "The number of CPUs assigned to the VM or container.", guestLabels, nil)
+	guestCpuDesc    = prometheus.NewDesc("pve_guest_cpu_usage_ratio", "The CPU usage of the VM or container, from 0 to its number of CPUs.", guestLabels, nil)
+	guestMemDesc    = prometheus.NewDesc("pve_guest_memory_used_bytes", "The memory used by the VM or container.", guestLabels, nil)
+	guestMaxMemDesc = prometheus.NewDesc("pve_guest_memory_total_bytes", "The memory assigned to the VM or container.", guestLabels, nil)
+	guestUptimeDesc = prometheus.NewDesc("pve_guest_uptime_seconds", "The uptime of the VM or container.", guestLabels, nil)
+
+	storageActiveDesc = prometheus.NewDesc("pve_storage_active", "Whether the storage is active on the node.", storageLabels, nil)
+	storageSizeDesc   = prometheus.NewDesc("pve_storage_size_bytes", "The size of the storage.", storageLabels, nil)
+	storageUsedDesc   = prometheus.NewDesc("pve_storage_used_bytes", "The space used on the storage.", storageLabels, nil)
+	storageAvailDesc  = prometheus.NewDesc("pve_storage_available_bytes", "The space available on the storage.", storageLabels, nil)
+
+	diskHealthyDesc = prometheus.NewDesc("pve_disk_healthy", "Whether the SMART health of the disk is PASSED or OK. The reported value is in the health label.", append(diskLabels, "health"), nil)
+	diskWearoutDesc = prometheus.NewDesc("pve_disk_wearout_percent", "The wearout of the disk as reported by Proxmox. Only disks that report a wearout are included.", diskLabels, nil)
+	diskSizeDesc    = prometheus.NewDesc("pve_disk_size_bytes", "The size of the disk.", diskLabels, nil)
+)
+
+// inventoryCollector exports the inventories of a set of parents. A new one
+// is made for every scrape, with the parents the caller may read.
+type inventoryCollector struct {
+	inventories []*parentInventory
+}
+
+func (c inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		parentUpDesc, parentCollectedDesc, parentErrorsDesc,
+		nodeUpDesc, nodeCpusDesc, nodeCpuDesc, nodeMemDesc, nodeMaxMemDesc, nodeRootDiskDesc, nodeMaxRootDiskDesc, nodeUptimeDesc,
+		guestUpDesc, guestCpusDesc, guestCpuDesc, guestMemDesc, guestMaxMemDesc, guestUptimeDesc,
+		storageActiveDesc, storageSizeDesc, storageUsedDesc, storageAvailDesc,
+		diskHealthyDesc, diskWearoutDesc, diskSizeDesc,
+	} {
+		ch <- d
+	}
+}
+
+func (c inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	for _, inv := range c.inventories {
+		errors := len(inv.Errors) + len(inv.NodeErrors) + len(inv.VmErrors) + len(inv.LxcErrors) + len(inv.StorageErrors) + len(inv.DiskErrors)
+		gauge(parentUpDesc, boolValue(inv.Up), inv.Parent)
+		gauge(parentCollectedDesc, float64(inv.Collected.Unix()), inv.Parent)
+		gauge(parentErrorsDesc, float64(errors), inv.Parent)
+
+		for _, node := range inv.NodeInfo {
+			gauge(nodeUpDesc, boolValue(node.Status == "online"), inv.Parent, node.Node)
+			if node.Status != "online" {
+				continue
+			}
+			gauge(nodeCpusDesc, float64(node.MaxCPU), inv.Parent, node.Node)
+			gauge(nodeCpuDesc, node.CPU, inv.Parent, node.Node)
+			gauge(nodeMemDesc, float64(node.Mem), inv.Parent, node.Node)
+			gauge(nodeMaxMemDesc, float64(node.MaxMem), inv.Parent, node.Node)
+			gauge(nodeRootDiskDesc, float64(node.Disk), inv.Parent, node.Node)
+			gauge(nodeMaxRootDiskDesc, float64(node.MaxDisk), inv.Parent, node.Node)
+			gauge(nodeUptimeDesc, float64(node.Uptime), inv.Parent, node.Node)
+		}
+
+		for _, vm := range inv.Vms {
+			labels := []string{inv.Parent, vm.Node, "qemu", strconv.Itoa(vm.Vmid), vm.Name, vm.tags}
+			gauge(guestUpDesc, boolValue(vm.Status == "running"), labels...)
+			gauge(guestCpusDesc, float64(vm.Cpus), labels...)
+			gauge(guestCpuDesc, vm.cpu, labels...)
+			gauge(guestMemDesc, float64(vm.memBytes), labels...)
+			gauge(guestMaxMemDesc, float64(vm.maxMemBytes), labels...)
+			gauge(guestUptimeDesc, float64(vm.Uptime), labels...)
+		}
+
+		for _, ct := range inv.Lxc {
+			labels := []string{inv.Parent, ct.Node, "lxc", strconv.Itoa(ct.Vmid), ct.Name, ct.Tags}
+			gauge(guestUpDesc, boolValue(ct.Status == "running"), labels...)
+			gauge(guestCpusDesc, float64(ct.cpus), labels...)
+			gauge(guestCpuDesc, ct.cpu, labels...)
+			gauge(guestMemDesc, float64(ct.memBytes), labels...)
+			gauge(guestMaxMemDesc, float64(ct.maxMemBytes), labels...)
+			gauge(guestUptimeDesc, float64(ct.uptimeSeconds), labels...)
+		}
+
+		for _, st := range inv.Storage {
+			labels := []string{inv.Parent, st.Node, st.Storage, st.Type, strconv.Itoa(st.Shared)}
+			gauge(storageActiveDesc, float64(st.Active), labels...)
+			gauge(storageSizeDesc, float64(st.totalBytes), labels...)
+			gauge(storageUsedDesc, float64(st.usedBytes), labels...)
+			gauge(storageAvailDesc, float64(st.availableBytes), labels...)
+		}
+
+		for _, disk := range inv.Disks {
+			labels := []string{inv.Parent, disk.Node, disk.Devpath, disk.Type, disk.Model, disk.Serial}
+			gauge(diskHealthyDesc, boolValue(disk.Health == "PASSED" || disk.Health == "OK"), append(labels, disk.Health)...)
+			gauge(diskSizeDesc, float64(disk.sizeBytes), labels...)
+			if disk.hasWearout {
+				gauge(diskWearoutDesc, float64(disk.Wearout), labels...)
+			}
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parentGatherer drops the series of the API metrics whose parent label names
+// a parent the caller may not read, e.g. the upstream requests and probes.
+type parentGatherer struct {
+	prometheus.Gatherer
+	parents map[string]bool
+}
+
+func (g parentGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		family.Metric = slices.DeleteFunc(family.Metric, func(m *dto.Metric) bool {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "parent" {
+					return !g.parents[label.GetValue()]
+				}
+			}
+			return false
+		})
+	}
+	families = slices.DeleteFunc(families, func(f *dto.MetricFamily) bool { return len(f.Metric) == 0 })
+	return families, err
+}
+
+// metrics serves the metrics of the API itself and, when enabled, the last
+// collected inventory in the Prometheus text format. Both are limited to the
+// parents the caller may read.
+func metrics(c *gin.Context) {
+	parents := parentsFor(c)
+	allowed := make(map[string]bool, len(parents))
+	for _, host := range parents {
+		allowed[host.Parent] = true
+	}
+	gatherers := prometheus.Gatherers{parentGatherer{Gatherer: prometheus.DefaultGatherer, parents: allowed}}
+	if requestConfig(c).Metrics.Enabled {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(inventoryCollector{inventories: inventory.snapshot(parents)})
+		gatherers = append(gatherers, registry)
+	}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}).ServeHTTP(c.Writer, c.Request)
+}
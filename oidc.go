@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultJWKSRefresh = time.Hour
+	// jwksMinRefresh limits how often an unknown key ID can trigger a fetch
+	jwksMinRefresh = time.Minute
+)
+
+// OIDCConfig configures validation of bearer JWTs from an OIDC provider. The
+// signing keys are read from JWKSFile or fetched from JWKSURL.
+type OIDCConfig struct {
+	Issuer      string        `json:"issuer" yaml:"issuer"`
+	Audience    string        `json:"audience" yaml:"audience"`
+	JWKSFile    string        `json:"jwksFile" yaml:"jwksFile"`
+	JWKSURL     string        `json:"jwksUrl" yaml:"jwksUrl"`
+	JWKSRefresh Duration      `json:"jwksRefresh" yaml:"jwksRefresh"`
+	Claim       string        `json:"claim" yaml:"claim"`
+	Mappings    []ClaimScopes `json:"mappings" yaml:"mappings"`
+}
+
+// ClaimScopes grants parents, route groups and scopes to tokens that carry
+// Value in the configured claim.
+type ClaimScopes struct {
+	Value   string   `json:"value" yaml:"value"`
+	Parents []string `json:"parents" yaml:"parents"`
+	Routes  []string `json:"routes" yaml:"routes"`
+	Scopes  []string `json:"scopes" yaml:"scopes"`
+}
+
+func (o *OIDCConfig) enabled() bool {
+	return o.Issuer != ""
+}
+
+func (o *OIDCConfig) applyDefaults() {
+	if o.Claim == "" {
+		o.Claim = "groups"
+	}
+	if o.JWKSRefresh == 0 {
+		o.JWKSRefresh = Duration(defaultJWKSRefresh)
+	}
+	for i := range o.Mappings {
+		if len(o.Mappings[i].Scopes) == 0 {
+			o.Mappings[i].Scopes = []string{scopeRead}
+		}
+	}
+}
+
+func (o *OIDCConfig) validate(parents []PVEConnectionObject) error {
+	if !o.enabled() {
+		return nil
+	}
+	if (o.JWKSFile == "") == (o.JWKSURL == "") {
+		return fmt.Errorf("oidc must set exactly one of jwksFile and jwksUrl")
+	}
+	if o.JWKSURL != "" && !strings.HasPrefix(o.JWKSURL, "https://") {
+		return fmt.Errorf("oidc jwksUrl must use https")
+	}
+	for _, m := range o.Mappings {
+		name := fmt.Sprintf("the oidc mapping for %s", m.Value)
+		if m.Value == "" {
+			return fmt.Errorf("an oidc mapping has no value")
+		}
+		if err := validateScopes(name, m.Parents, m.Routes, parents); err != nil {
+			return err
+		}
+		if err := validateAccessScopes(name, m.Scopes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type oidcAuthenticator struct{}
+
+func (oidcAuthenticator) authenticate(r *http.Request, cfg *Config) (*principal, error) {
+	oidc := &cfg.Auth.OIDC
+	raw, ok := bearerToken(r)
+	if !ok || !oidc.enabled() || strings.Count(raw, ".") != 2 {
+		return nil, nil
+	}
+
+	keys := jwksFor(oidc)
+	options := []jwt.ParserOption{
+		jwt.WithIssuer(oidc.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+	}
+	if oidc.Audience != "" {
+		options = append(options, jwt.WithAudience(oidc.Audience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.key(kid)
+	}, options...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT - %w", err)
+	}
+
+	subject, _ := claims.GetSubject()
+	if name, ok := claims["preferred_username"].(string); ok && name != "" {
+		subject = name
+	}
+	p := &principal{Name: "oidc:" + subject, Method: "oidc"}
+	values := claimValues(claims[oidc.Claim])
+	for _, m := range oidc.Mappings {
+		if slices.Contains(values, m.Value) {
+			p.Parents = append(p.Parents, m.Parents...)
+			p.Routes = append(p.Routes, m.Routes...)
+			p.Scopes = append(p.Scopes, m.Scopes...)
+		}
+	}
+	return p, nil
+}
+
+// claimValues flattens a claim that can be a single string, a space
+// separated string or a list of strings.
+func claimValues(v any) []string {
+	switch val := v.(type) {
+	case string:
+		return strings.Fields(val)
+	case []any:
+		var values []string
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// jwks caches the signing keys of a key set. Keys are refreshed on an
+// interval, and early when a token references a key ID that is not known yet,
+// which is how providers roll over to a new key.
+type jwks struct {
+	file    string
+	url     string
+	refresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	failed  time.Time
+	lastErr error
+}
+
+var (
+	jwksCachesMu sync.Mutex
+	jwksCaches   = make(map[string]*jwks)
+)
+
+// jwksFor returns the cached key set for the config. The cache survives config
+// reloads as long as the source and refresh interval stay the same.
+func jwksFor(o *OIDCConfig) *jwks {
+	id := fmt.Sprintf("%s|%s|%s", o.JWKSFile, o.JWKSURL, time.Duration(o.JWKSRefresh))
+	jwksCachesMu.Lock()
+	defer jwksCachesMu.Unlock()
+	if cache, ok := jwksCaches[id]; ok {
+		return cache
+	}
+	cache := &jwks{file: o.JWKSFile, url: o.JWKSURL, refresh: time.Duration(o.JWKSRefresh)}
+	jwksCaches[id] = cache
+	return cache
+}
+
+func (j *jwks) key(kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	age := time.Since(j.fetched)
+	_, known := j.keys[kid]
+	due := j.keys == nil || age > j.refresh || (!known && age > jwksMinRefresh)
+	// After a failed attempt wait jwksMinRefresh before the next one, so an
+	// unreachable provider is not asked again on every request.
+	if due && time.Since(j.failed) > jwksMinRefresh {
+		keys, err := j.load()
+		j.lastErr = err
+		if err != nil {
+			j.failed = time.Now()
+		} else {
+			j.keys = keys
+			j.fetched = time.Now()
+		}
+	}
+
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+	if j.lastErr != nil {
+		return nil, fmt.Errorf("failed to load the JWKS - %w", j.lastErr)
+	}
+	return nil, fmt.Errorf("the signing key %q is not in the JWKS", kid)
+}
+
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+func (j *jwks) load() (map[string]crypto.PublicKey, error) {
+	var content []byte
+	if j.file != "" {
+		b, err := os.ReadFile(j.file)
+		if err != nil {
+			return nil, err
+		}
+		content = b
+	} else {
+		res, err := jwksClient.Get(j.url)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s returned %s", j.url, res.Status)
+		}
+		b, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+		content = b
+	}
+	return parseJWKS(content)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes the RSA and EC signing keys of a JWK set. Encryption
+// keys and unknown key types are skipped.
+func parseJWKS(content []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode the JWKS - %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the key %q - %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("the JWKS contains no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
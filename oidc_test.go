@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testIssuer = "https://sso.example.test/realms/lab"
+
+type testKey struct {
+	kid    string
+	method jwt.SigningMethod
+	signer any
+	jwk    map[string]string
+}
+
+func newRSAKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid: kid, method: jwt.SigningMethodRS256, signer: key, jwk: map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}
+}
+
+func newECKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid: kid, method: jwt.SigningMethodES256, signer: key, jwk: map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}
+}
+
+func writeJWKS(t *testing.T, path string, keys ...testKey) {
+	t.Helper()
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.jwk)
+	}
+	b, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (k testKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	signed, err := token.SignedString(k.signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims(groups ...string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":    testIssuer,
+		"aud":    "pve-api",
+		"sub":    "alice",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": groups,
+	}
+}
+
+func oidcTestConfig(t *testing.T, jwksFile string) *Config {
+	t.Helper()
+	cfg := &Config{
+		Parents: []PVEConnectionObject{
+			{Parent: "cluster-a", Token: "a"},
+			{Parent: "cluster-b", Token: "b"},
+		},
+		Auth: AuthConfig{OIDC: OIDCConfig{
+			Issuer:   testIssuer,
+			Audience: "pve-api",
+			JWKSFile: jwksFile,
+			Mappings: []ClaimScopes{
+				{Value: "team-a", Parents: []string{"cluster-a"}, Routes: []string{"infrastructure"}, Scopes: []string{scopeRead}},
+				{Value: "ops", Parents: []string{allScope}, Routes: []string{allScope}, Scopes: []string{scopeRead, scopeWrite}},
+				// Without scopes a mapping can read, like API keys
+				{Value: "team-b", Parents: []string{"cluster-b"}, Routes: []string{"infrastructure"}},
+			},
+		}},
+	}
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func authRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/infrastructure/nodes/summary", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestOIDCAuthenticatorMapsClaims(t *testing.T) {
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	rsaKey, ecKey := newRSAKey(t, "rsa-1"), newECKey(t, "ec-1")
+	writeJWKS(t, jwksFile, rsaKey, ecKey)
+	cfg := oidcTestConfig(t, jwksFile)
+
+	for _, key := range []testKey{rsaKey, ecKey} {
+		p, err := oidcAuthenticator{}.authenticate(authRequest(key.sign(t, validClaims("team-a"))), cfg)
+		if err != nil {
+			t.Fatalf("%s: unexpected error - %v", key.kid, err)
+		}
+		if p == nil || p.Name != "oidc:alice" {
+			t.Fatalf("%s: expected the principal oidc:alice, got %+v", key.kid, p)
+		}
+		if !p.allowsParent("cluster-a") || p.allowsParent("cluster-b") {
+			t.Errorf("%s: expected access to cluster-a only, got %v", key.kid, p.Parents)
+		}
+		if !p.allowsRoute("infrastructure") || p.allowsRoute("virtualization") {
+			t.Errorf("%s: expected the infrastructure routes only, got %v", key.kid, p.Routes)
+		}
+		if !p.allowsScope(scopeRead) || p.allowsScope(scopeWrite) {
+			t.Errorf("%s: expected the read scope only, got %v", key.kid, p.Scopes)
+		}
+	}
+}
+
+func TestOIDCAuthenticatorRejectsInvalidTokens(t *testing.T) {
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	key := newRSAKey(t, "rsa-1")
+	writeJWKS(t, jwksFile, key)
+	cfg := oidcTestConfig(t, jwksFile)
+
+	wrongIssuer := validClaims("ops")
+	wrongIssuer["iss"] = "https://evil.example.test"
+	expired := validClaims("ops")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	wrongAudience := validClaims("ops")
+	wrongAudience["aud"] = "someone-else"
+	noExpiry := validClaims("ops")
+	delete(noExpiry, "exp")
+
+	tests := map[string]string{
+		"wrong issuer":   key.sign(t, wrongIssuer),
+		"expired":        key.sign(t, expired),
+		"wrong audience": key.sign(t, wrongAudience),
+		"no expiry":      key.sign(t, noExpiry),
+		"unknown key":    newRSAKey(t, "rsa-1").sign(t, validClaims("ops")),
+	}
+	for name, token := range tests {
+		if p, err := (oidcAuthenticator{}).authenticate(authRequest(token), cfg); err == nil {
+			t.Errorf("%s: expected the token to be rejected, got %+v", name, p)
+		}
+	}
+}
+
+func TestJWKSPicksUpRotatedKeys(t *testing.T) {
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	oldKey, newKey := newRSAKey(t, "old"), newRSAKey(t, "new")
+	writeJWKS(t, jwksFile, oldKey)
+	cfg := oidcTestConfig(t, jwksFile)
+
+	if _, err := (oidcAuthenticator{}).authenticate(authRequest(oldKey.sign(t, validClaims("ops"))), cfg); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	writeJWKS(t, jwksFile, oldKey, newKey)
+	// Pretend the last fetch is old enough for an unknown key ID to trigger a
+	// refresh.
+	cache := jwksFor(&cfg.Auth.OIDC)
+	cache.mu.Lock()
+	cache.fetched = time.Now().Add(-2 * jwksMinRefresh)
+	cache.mu.Unlock()
+
+	if _, err := (oidcAuthenticator{}).authenticate(authRequest(newKey.sign(t, validClaims("ops"))), cfg); err != nil {
+		t.Fatalf("expected the rotated key to be accepted - %v", err)
+	}
+}
+
+func TestAuthenticateMiddlewareScopesParents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	key := newRSAKey(t, "rsa-1")
+	writeJWKS(t, jwksFile, key)
+	activeConfig.current.Store(oidcTestConfig(t, jwksFile))
+	t.Cleanup(func() { activeConfig.current.Store(nil) })
+
+	router := gin.New()
+	router.GET("/api/v1/infrastructure/parents", authenticate, authorizeRoute("infrastructure"), func(c *gin.Context) {
+		var names []string
+		for _, p := range parentsFor(c) {
+			names = append(names, p.Parent)
+		}
+		c.JSON(http.StatusOK, names)
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		body   string
+	}{
+		{"no token", "", http.StatusUnauthorized, ""},
+		{"garbage", "not.a.jwt", http.StatusUnauthorized, ""},
+		{"team-a", key.sign(t, validClaims("team-a")), http.StatusOK, `["cluster-a"]`},
+		{"ops", key.sign(t, validClaims("ops")), http.StatusOK, `["cluster-a","cluster-b"]`},
+		{"default scopes", key.sign(t, validClaims("team-b")), http.StatusOK, `["cluster-b"]`},
+		{"no mapping", key.sign(t, validClaims("guests")), http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/infrastructure/parents", nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, rec.Code)
+			continue
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.body, rec.Body.String())
+		}
+	}
+}
+
+func TestJWKSBacksOffAfterAFailedFetch(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	var hits int
+	down := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{key.jwk}})
+	}))
+	defer srv.Close()
+
+	cache := &jwks{url: srv.URL, refresh: time.Hour}
+	for range 3 {
+		if _, err := cache.key("rsa-1"); err == nil {
+			t.Fatal("expected an error while the provider is down")
+		}
+	}
+	if hits != 1 {
+		t.Errorf("expected one fetch while backing off, got %d", hits)
+	}
+
+	down = false
+	cache.mu.Lock()
+	cache.failed = time.Now().Add(-2 * jwksMinRefresh)
+	cache.mu.Unlock()
+	if _, err := cache.key("rsa-1"); err != nil {
+		t.Fatalf("expected the key once the provider is back - %v", err)
+	}
+	if hits != 2 {
+		t.Errorf("expected a second fetch after the back-off, got %d", hits)
+	}
+}
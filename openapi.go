@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPIConfig controls the documentation of the API. The OpenAPI document
+// is always served at /openapi.json - UI adds a Swagger UI page at /docs.
+// UIAssets is a local copy of the swagger-ui-dist package to serve the UI
+// from, instead of loading the pinned version from unpkg.com.
+type OpenAPIConfig struct {
+	UI       bool   `json:"ui" yaml:"ui"`
+	UIAssets string `json:"uiAssets" yaml:"uiAssets"`
+}
+
+// swaggerUIVersion is the swagger-ui-dist release the page loads from the
+// CDN. It is pinned, so an upstream release cannot change the page.
+const swaggerUIVersion = "5.17.14"
+
+// swaggerUIFiles are the files of swagger-ui-dist the page uses.
+var swaggerUIFiles = []string{"swagger-ui.css", "swagger-ui-bundle.js"}
+
+func (o *OpenAPIConfig) validate() error {
+	if o.UIAssets == "" {
+		return nil
+	}
+	for _, name := range swaggerUIFiles {
+		if _, err := os.Stat(filepath.Join(o.UIAssets, name)); err != nil {
+			return fmt.Errorf("the Swagger UI assets in %s are incomplete - %w", o.UIAssets, err)
+		}
+	}
+	return nil
+}
+
+// apiOperation documents a route. The response bodies are described by a
+// value of the type the handler answers with, so the document follows the
+// structs. A nil body is documented as text.
+type apiOperation struct {
+	Path      string
+	Summary   string
+	Tag       string
+	Secured   bool
+	Query     []apiQueryParameter
+	Responses map[int]apiResponse
+}
+
+// apiQueryParameter is an optional query parameter of a route.
+type apiQueryParameter struct {
+	Name        string
+	Description string
+	Enum        []string
+}
+
+type apiResponse struct {
+	Description string
+	Body        any
+}
+
+// ErrorResponse is the answer when a request is rejected before any parent
+// is contacted.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+var (
+	parentNotFound = apiResponse{"The parent is not in the configuration", ErrorResponse{}}
+	unauthorized   = apiResponse{"No valid bearer token or client certificate was sent", ErrorResponse{}}
+	forbidden      = apiResponse{"The caller may not read the routes of this group", ErrorResponse{}}
+)
+
+// apiOperations lists every route of newRouter. Paths use the gin syntax.
+var apiOperations = []apiOperation{
+	{
+		Path:    "/health",
+		Summary: "Report that the process is up",
+		Tag:     "status",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {"The process is up", HealthResponse{}},
+		},
+	},
+	{
+		Path:    "/healthz",
+		Summary: "Report that the process is up, for liveness probes",
+		Tag:     "status",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {"The process is up", HealthResponse{}},
+		},
+	},
+	{
+		Path:    "/readyz",
+		Summary: "Report whether enough parents are reachable, by the readiness policy",
+		Tag:     "status",
+		Responses: map[int]apiResponse{
+			http.StatusOK:                 {"The API is ready", ReadinessResponse{}},
+			http.StatusServiceUnavailable: {"Too few parents were reachable on their last check", ReadinessResponse{}},
+		},
+	},
+	{
+		Path:    "/metrics",
+		Summary: "Metrics of the API and the inventory in the Prometheus text format",
+		Tag:     "metrics",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK: {"The metrics", nil},
+		},
+	},
+	{
+		Path:    "/api/v1/infrastructure/nodes/summary",
+		Summary: "Usage of every node of every parent",
+		Tag:     "infrastructure",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK: {"The nodes, with an error for every call that failed", NodeSummaryResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/infrastructure/nodes/detailed/:parent",
+		Summary: "Hardware, version, DNS and time of the nodes of a parent",
+		Tag:     "infrastructure",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {"The nodes, with an error for every call that failed", NodeDetailsResponse{}},
+			http.StatusNotFound:            parentNotFound,
+			http.StatusInternalServerError: {"The nodes of the parent could not be listed", NodeDetailsResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/infrastructure/nodes/detailed/:parent/storage",
+		Summary: "Storage of the nodes of a parent",
+		Tag:     "infrastructure",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {"The storage, with an error for every call that failed", NodeStorageResponse{}},
+			http.StatusNotFound:            parentNotFound,
+			http.StatusInternalServerError: {"The nodes of the parent could not be listed", NodeStorageResponse{}},
+			http.StatusBadGateway:          {"No storage could be read from any node", NodeStorageResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/infrastructure/nodes/detailed/:parent/disks",
+		Summary: "Physical disks of the nodes of a parent",
+		Tag:     "infrastructure",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {"The disks, with an error for every call that failed", NodeDiskObject{}},
+			http.StatusNotFound:            parentNotFound,
+			http.StatusInternalServerError: {"The nodes of the parent could not be listed", NodeDiskObject{}},
+			http.StatusBadGateway:          {"No disks could be read from any node", NodeDiskObject{}},
+		},
+	},
+	{
+		Path:    "/api/v1/infrastructure/clusters",
+		Summary: "Cluster name, quorum, votes and corosync addresses of every parent",
+		Tag:     "infrastructure",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK: {"The clusters, with an error for every call that failed", ClustersResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/infrastructure/clusters/:parent",
+		Summary: "Cluster name, quorum, votes and corosync addresses of a parent",
+		Tag:     "infrastructure",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {"The cluster, or the parent as standalone", ClustersResponse{}},
+			http.StatusNotFound:            parentNotFound,
+			http.StatusInternalServerError: {"The cluster status of the parent could not be read", ClustersResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/infrastructure/ha",
+		Summary: "HA manager, resources and groups of every parent",
+		Tag:     "infrastructure",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK: {"The HA stacks, with an error for every call that failed", HAResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/infrastructure/ha/:parent",
+		Summary: "HA manager, resources and groups of a parent",
+		Tag:     "infrastructure",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {"The HA stack, with an error for every call that failed", HAResponse{}},
+			http.StatusNotFound:            parentNotFound,
+			http.StatusInternalServerError: {"The HA manager status of the parent could not be read", HAResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/virtualization/vm/summary",
+		Summary: "Every QEMU VM of every parent",
+		Tag:     "virtualization",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK: {"The VMs, with an error for every call that failed", VmSummaryResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/virtualization/vm/detailed/:parent/:id",
+		Summary: "Status of a VM and what its guest agent reports",
+		Tag:     "virtualization",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {"The VM, with an error for every agent call that failed", QemuGuestWrapper{}},
+			http.StatusNotFound:            {"The parent or the VM was not found", ErrorResponse{}},
+			http.StatusInternalServerError: {"The nodes of the parent could not be listed", QemuGuestWrapper{}},
+			http.StatusBadGateway:          {"The VM could not be read", QemuGuestWrapper{}},
+		},
+	},
+	{
+		Path:    "/api/v1/virtualization/lxc/summary",
+		Summary: "Every LXC container of every parent",
+		Tag:     "virtualization",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK:       {"The containers, with an error for every call that failed", LxcSummaryResponse{}},
+			http.StatusNotFound: {"No containers were found", ErrorResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/inventory/resources",
+		Summary: "Every node, guest and storage of every parent, from /cluster/resources",
+		Tag:     "inventory",
+		Secured: true,
+		Query: []apiQueryParameter{
+			{Name: "type", Description: "Only list resources of this type - vm is both QEMU VMs and LXC containers", Enum: resourceTypes},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {"The resources, with an error for every call that failed", ClusterResourcesResponse{}},
+			http.StatusBadRequest: {"The type is not known", ErrorResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/parents",
+		Summary: "Reachability, last contact, latency, version and credentials of every parent",
+		Tag:     "status",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK: {"The last background check of the parents", ParentHealthResponse{}},
+		},
+	},
+	{
+		Path:    "/api/v1/status/parents",
+		Summary: "Endpoint in use and circuit breaker state of every parent",
+		Tag:     "status",
+		Secured: true,
+		Responses: map[int]apiResponse{
+			http.StatusOK: {"The state of the parents", ParentStatusResponse{}},
+		},
+	},
+}
+
+// openAPIDocument is built once - the routes and types do not change while
+// the process runs.
+var openAPIDocument = sync.OnceValue(func() []byte {
+	b, err := json.MarshalIndent(buildOpenAPI(apiOperations), "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("the OpenAPI document cannot be encoded - %v", err))
+	}
+	return b
+})
+
+func openAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPIDocument())
+}
+
+// openAPIUI serves a Swagger UI page for the document, when enabled. The UI
+// itself comes from the local assets, or else from the pinned release on the
+// CDN.
+func openAPIUI(c *gin.Context) {
+	cfg := requestConfig(c).OpenAPI
+	if !cfg.UI {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The API documentation page is not enabled"})
+		return
+	}
+	base := "https://unpkg.com/swagger-ui-dist@" + swaggerUIVersion
+	if cfg.UIAssets != "" {
+		base = "/docs/assets"
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(fmt.Sprintf(swaggerUIPage, base, base)))
+}
+
+// openAPIUIAsset serves a file of the local Swagger UI assets. Only the files
+// the page uses are served.
+func openAPIUIAsset(c *gin.Context) {
+	cfg := requestConfig(c).OpenAPI
+	name := c.Param("file")
+	if !cfg.UI || cfg.UIAssets == "" || !slices.Contains(swaggerUIFiles, name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The file is not part of the API documentation page"})
+		return
+	}
+	c.File(filepath.Join(cfg.UIAssets, name))
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Proxmox Simple API</title>
+  <link rel="stylesheet" href="%s/swagger-ui.css" crossorigin="anonymous" referrerpolicy="no-referrer">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="%s/swagger-ui-bundle.js" crossorigin="anonymous" referrerpolicy="no-referrer"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+func buildOpenAPI(operations []apiOperation) map[string]any {
+	schemas := make(map[string]any)
+	paths := make(map[string]any)
+
+	for _, op := range operations {
+		responses := make(map[string]any)
+		for status, res := range op.Responses {
+			responses[strconv.Itoa(status)] = responseObject(res, schemas)
+		}
+		if op.Secured {
+			responses[strconv.Itoa(http.StatusUnauthorized)] = responseObject(unauthorized, schemas)
+			responses[strconv.Itoa(http.StatusForbidden)] = responseObject(forbidden, schemas)
+		}
+
+		get := map[string]any{
+			"summary":   op.Summary,
+			"tags":      []string{op.Tag},
+			"responses": responses,
+		}
+		params := pathParameters(op.Path)
+		for _, q := range op.Query {
+			schema := map[string]any{"type": "string"}
+			if len(q.Enum) > 0 {
+				schema["enum"] = q.Enum
+			}
+			params = append(params, map[string]any{
+				"name":        q.Name,
+				"in":          "query",
+				"description": q.Description,
+				"schema":      schema,
+			})
+		}
+		if len(params) > 0 {
+			get["parameters"] = params
+		}
+		if op.Secured {
+			get["security"] = []map[string][]string{{"bearerAuth": {}}}
+		} else {
+			get["security"] = []map[string][]string{}
+		}
+		paths[openAPIPath(op.Path)] = map[string]any{"get": get}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Proxmox Simple API",
+			"description": "A read only summary of the nodes, guests and storage of one or more Proxmox VE clusters.",
+			"version":     "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "An API key or an OIDC access token. Client certificates are accepted too, when configured.",
+				},
+			},
+		},
+	}
+}
+
+func responseObject(res apiResponse, schemas map[string]any) map[string]any {
+	obj := map[string]any{"description": res.Description}
+	if res.Body == nil {
+		obj["content"] = map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}}
+	} else {
+		obj["content"] = map[string]any{"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(res.Body), schemas)}}
+	}
+	return obj
+}
+
+// openAPIPath turns /nodes/:parent into /nodes/{parent}.
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func pathParameters(path string) []map[string]any {
+	var params []map[string]any
+	for _, p := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(p, ":"); ok {
+			params = append(params, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+	}
+	return params
+}
+
+// schemaFor describes t as a JSON schema. Named structs are added to
+// schemas and referenced. Fields are required unless they are omitempty, and
+// lists and maps may be null, as encoding/json writes nil ones.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaFor(t.Elem(), schemas)
+		if _, ref := s["$ref"]; ref {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas), "nullable": true}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas), "nullable": true}
+	case reflect.Interface:
+		return map[string]any{"nullable": true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		name := schemaName(t)
+		if _, ok := schemas[name]; !ok {
+			// Registered before the fields are walked, so a type that refers
+			// to itself does not recurse forever
+			schemas[name] = nil
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	panic(fmt.Sprintf("no OpenAPI schema for the type %s", t))
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaFor(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	s := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// schemaName exports the names of unexported types, e.g. nodeSummaryWrapper
+// becomes NodeSummaryWrapper.
+func schemaName(t reflect.Type) string {
+	r := []rune(t.Name())
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve/pvetest"
+)
+
+// loadOpenAPI fetches the document the router serves.
+func loadOpenAPI(t *testing.T, router *gin.Engine) map[string]any {
+	t.Helper()
+	var doc map[string]any
+	getJSON(t, router, "/openapi.json", http.StatusOK, &doc)
+	return doc
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	_, router := newFakeParent(t)
+	paths := loadOpenAPI(t, router)["paths"].(map[string]any)
+
+	for _, route := range router.Routes() {
+		// The document does not describe itself
+		if route.Path == "/openapi.json" || strings.HasPrefix(route.Path, "/docs") {
+			continue
+		}
+		if _, ok := paths[openAPIPath(route.Path)]; !ok {
+			t.Errorf("%s %s is not in the OpenAPI document", route.Method, route.Path)
+		}
+	}
+}
+
+func TestResponsesMatchOpenAPI(t *testing.T) {
+	tests := []struct {
+		route, path string
+		status      int
+		setup       func(srv *pvetest.Server)
+	}{
+		{route: "/health", path: "/health", status: http.StatusOK},
+		{route: "/api/v1/infrastructure/nodes/summary", path: "/api/v1/infrastructure/nodes/summary", status: http.StatusOK},
+		{route: "/api/v1/infrastructure/nodes/summary", path: "/api/v1/infrastructure/nodes/summary", status: http.StatusOK,
+			setup: func(srv *pvetest.Server) { srv.SetOffline("pve2", true) }},
+		{route: "/api/v1/infrastructure/nodes/detailed/:parent", path: "/api/v1/infrastructure/nodes/detailed/lab", status: http.StatusOK},
+		{route: "/api/v1/infrastructure/nodes/detailed/:parent", path: "/api/v1/infrastructure/nodes/detailed/missing", status: http.StatusNotFound},
+		{route: "/api/v1/infrastructure/nodes/detailed/:parent/storage", path: "/api/v1/infrastructure/nodes/detailed/lab/storage", status: http.StatusOK},
+		{route: "/api/v1/infrastructure/nodes/detailed/:parent/disks", path: "/api/v1/infrastructure/nodes/detailed/lab/disks", status: http.StatusOK},
+		{route: "/api/v1/infrastructure/clusters", path: "/api/v1/infrastructure/clusters", status: http.StatusOK},
+		{route: "/api/v1/infrastructure/clusters/:parent", path: "/api/v1/infrastructure/clusters/lab", status: http.StatusOK},
+		{route: "/api/v1/infrastructure/clusters/:parent", path: "/api/v1/infrastructure/clusters/lab", status: http.StatusInternalServerError,
+			setup: func(srv *pvetest.Server) { srv.Inject("/cluster/status", pvetest.Fault{Status: http.StatusForbidden}) }},
+		{route: "/api/v1/infrastructure/ha", path: "/api/v1/infrastructure/ha", status: http.StatusOK},
+		{route: "/api/v1/infrastructure/ha/:parent", path: "/api/v1/infrastructure/ha/lab", status: http.StatusOK,
+			setup: func(srv *pvetest.Server) { srv.SetOffline("pve2", true) }},
+		{route: "/api/v1/infrastructure/ha/:parent", path: "/api/v1/infrastructure/ha/missing", status: http.StatusNotFound},
+		{route: "/api/v1/virtualization/vm/summary", path: "/api/v1/virtualization/vm/summary", status: http.StatusOK},
+		{route: "/api/v1/virtualization/vm/detailed/:parent/:id", path: "/api/v1/virtualization/vm/detailed/lab/100", status: http.StatusOK},
+		{route: "/api/v1/virtualization/vm/detailed/:parent/:id", path: "/api/v1/virtualization/vm/detailed/lab/101", status: http.StatusOK},
+		{route: "/api/v1/virtualization/vm/detailed/:parent/:id", path: "/api/v1/virtualization/vm/detailed/lab/999", status: http.StatusNotFound},
+		{route: "/api/v1/virtualization/vm/detailed/:parent/:id", path: "/api/v1/virtualization/vm/detailed/missing/100", status: http.StatusNotFound},
+		{route: "/api/v1/virtualization/lxc/summary", path: "/api/v1/virtualization/lxc/summary", status: http.StatusOK},
+		{route: "/api/v1/inventory/resources", path: "/api/v1/inventory/resources", status: http.StatusOK},
+		{route: "/api/v1/inventory/resources", path: "/api/v1/inventory/resources?type=vm", status: http.StatusOK,
+			setup: func(srv *pvetest.Server) { srv.SetOffline("pve2", true) }},
+		{route: "/api/v1/inventory/resources", path: "/api/v1/inventory/resources?type=pool", status: http.StatusBadRequest},
+		{route: "/api/v1/status/parents", path: "/api/v1/status/parents", status: http.StatusOK},
+		{route: "/healthz", path: "/healthz", status: http.StatusOK},
+		{route: "/readyz", path: "/readyz", status: http.StatusServiceUnavailable},
+		{route: "/api/v1/parents", path: "/api/v1/parents", status: http.StatusOK},
+		{route: "/api/v1/parents", path: "/api/v1/parents", status: http.StatusOK,
+			setup: func(srv *pvetest.Server) { parentHealth.refresh(context.Background(), currentConfig().Parents) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			srv, router := newFakeParent(t)
+			t.Cleanup(func() { parentHealth.refresh(context.Background(), nil) })
+			if tt.setup != nil {
+				tt.setup(srv)
+			}
+			doc := loadOpenAPI(t, router)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d - %s", tt.status, rec.Code, rec.Body.String())
+			}
+			var body any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			op := doc["paths"].(map[string]any)[openAPIPath(tt.route)].(map[string]any)["get"].(map[string]any)
+			res, ok := op["responses"].(map[string]any)[strconv.Itoa(tt.status)].(map[string]any)
+			if !ok {
+				t.Fatalf("the status %d is not documented for %s", tt.status, tt.route)
+			}
+			schema := res["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+			if err := validateSchema(doc, schema, body, "$"); err != nil {
+				t.Errorf("the response does not match the document - %v\n%s", err, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestOpenAPIUIIsOptional(t *testing.T) {
+	_, router := newFakeParent(t)
+	getJSON(t, router, "/docs", http.StatusNotFound, nil)
+
+	_, router = newFakeParent(t, func(cfg *Config) { cfg.OpenAPI.UI = true })
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/openapi.json") {
+		t.Errorf("expected the documentation page, got %d - %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "swagger-ui-dist@"+swaggerUIVersion+"/swagger-ui-bundle.js") {
+		t.Errorf("expected the UI to be loaded from the pinned release, got %s", rec.Body.String())
+	}
+}
+
+func TestOpenAPIUIFromLocalAssets(t *testing.T) {
+	assets := t.TempDir()
+	for _, name := range swaggerUIFiles {
+		if err := os.WriteFile(filepath.Join(assets, name), []byte("/* "+name+" */"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, router := newFakeParent(t, func(cfg *Config) {
+		cfg.OpenAPI = OpenAPIConfig{UI: true, UIAssets: assets}
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if page := get("/docs").Body.String(); strings.Contains(page, "unpkg.com") || !strings.Contains(page, `src="/docs/assets/swagger-ui-bundle.js"`) {
+		t.Errorf("expected the page to use the local assets, got %s", page)
+	}
+	if rec := get("/docs/assets/swagger-ui.css"); rec.Code != http.StatusOK || rec.Body.String() != "/* swagger-ui.css */" {
+		t.Errorf("expected the local stylesheet, got %d - %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/docs/assets/index.html"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected only the files of the page to be served, got %d", rec.Code)
+	}
+
+	os.Remove(filepath.Join(assets, "swagger-ui-bundle.js"))
+	cfg := OpenAPIConfig{UI: true, UIAssets: assets}
+	if err := cfg.validate(); err == nil {
+		t.Error("expected incomplete assets to be rejected")
+	}
+}
+
+// validateSchema checks a decoded JSON value against the subset of OpenAPI
+// schemas that buildOpenAPI writes.
+func validateSchema(doc, schema map[string]any, value any, at string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		return validateSchema(doc, doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any), value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s is null", at)
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, s := range all {
+			if err := validateSchema(doc, s.(map[string]any), value, at); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch schema["type"] {
+	case nil:
+		return nil
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s is %T, not a boolean", at, value)
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s is %v, not an integer", at, value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s is %T, not a number", at, value)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s is %T, not a string", at, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("%s is not a date-time - %v", at, err)
+			}
+		}
+	case "array":
+		list, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s is %T, not an array", at, value)
+		}
+		for i, v := range list {
+			if err := validateSchema(doc, schema["items"].(map[string]any), v, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s is %T, not an object", at, value)
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%s.%s is missing", at, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range slices.Sorted(maps.Keys(obj)) {
+			s, ok := properties[name].(map[string]any)
+			if !ok {
+				if extra, ok := schema["additionalProperties"].(map[string]any); ok {
+					s = extra
+				} else {
+					return fmt.Errorf("%s.%s is not documented", at, name)
+				}
+			}
+			if err := validateSchema(doc, s, obj[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
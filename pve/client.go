@@ -0,0 +1,127 @@
+// Package pve is a small typed client for the Proxmox VE API. It covers the
+// read calls the API server needs - nodes, guests, storage, disks, the QEMU
+// guest agent and tasks - and returns every failure as an *Error.
+package pve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const apiTokenPrefix = "PVEAPIToken="
+
+// Client talks to one Proxmox VE host or cluster. It is safe for concurrent
+// use.
+type Client struct {
+	host    string
+	baseURL string
+	http    *http.Client
+	token   string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends the requests through client, e.g. one with a custom
+// TLS configuration. By default http.DefaultClient is used.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// WithAPIToken authenticates every request with an API token, given as
+// "user@realm!tokenid=secret" with or without the PVEAPIToken= prefix.
+func WithAPIToken(token string) Option {
+	return func(c *Client) {
+		if !strings.HasPrefix(token, apiTokenPrefix) {
+			token = apiTokenPrefix + token
+		}
+		c.token = token
+	}
+}
+
+// New returns a client for the API on host and port, e.g. 8006.
+func New(host string, port int, opts ...Option) *Client {
+	c := &Client{
+		host:    host,
+		baseURL: "https://" + net.JoinHostPort(host, strconv.Itoa(port)) + "/api2/json",
+		http:    http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Host returns the host the client was created for.
+func (c *Client) Host() string {
+	return c.host
+}
+
+// Get sends a GET request for path, relative to /api2/json, and decodes the
+// data of the response into out. It is meant for the calls that have no
+// method of their own.
+func (c *Client) Get(ctx context.Context, path string, out any) error {
+	envelope := struct {
+		Data any `json:"data"`
+	}{Data: out}
+	return c.do(ctx, http.MethodGet, path, &envelope)
+}
+
+// get is Get for the typed methods.
+func get[T any](ctx context.Context, c *Client, path string) (T, error) {
+	var envelope struct {
+		Data T `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, path, &envelope)
+	return envelope.Data, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, out any) error {
+	fail := func(code int, body []byte, err error) error {
+		return &Error{Host: c.host, Method: method, Path: path, StatusCode: code, Body: body, Err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return fail(0, nil, err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", c.token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fail(0, nil, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fail(res.StatusCode, nil, err)
+	}
+	if res.StatusCode/100 != 2 {
+		return statusError(c.host, method, path, res, body)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fail(res.StatusCode, body, fmt.Errorf("failed to decode the response - %w", err))
+	}
+	return nil
+}
+
+// nodePath builds the path of a node, escaping the node name.
+func nodePath(node string, elem ...string) string {
+	return "/nodes/" + url.PathEscape(node) + "/" + strings.Join(elem, "/")
+}
+
+// guestPath builds the path of a VM or container on a node.
+func guestPath(node, kind string, vmid int, elem ...string) string {
+	return nodePath(node, append([]string{kind, strconv.Itoa(vmid)}, elem...)...)
+}
@@ -0,0 +1,141 @@
+package pve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Types of the entries in /cluster/resources.
+const (
+	ResourceNode    = "node"
+	ResourceQemu    = "qemu"
+	ResourceLXC     = "lxc"
+	ResourceStorage = "storage"
+)
+
+// Resource is an entry of /cluster/resources - a node, guest, storage or
+// other object, by Type. Guests on offline nodes are listed with the node
+// they were last seen on and the status unknown. The sizes are bytes; for
+// storage Disk and MaxDisk are the used and total space.
+type Resource struct {
+	ID         string  `json:"id"`
+	Type       string  `json:"type"`
+	Node       string  `json:"node"`
+	Status     string  `json:"status"`
+	Name       string  `json:"name"`
+	Vmid       int     `json:"vmid"`
+	Tags       string  `json:"tags"`
+	Template   int     `json:"template"`
+	HAState    string  `json:"hastate"`
+	Pool       string  `json:"pool"`
+	Lock       string  `json:"lock"`
+	MaxCPU     int     `json:"maxcpu"`
+	CPU        float64 `json:"cpu"`
+	Mem        int     `json:"mem"`
+	MaxMem     int     `json:"maxmem"`
+	Disk       int     `json:"disk"`
+	MaxDisk    int     `json:"maxdisk"`
+	NetIn      int     `json:"netin"`
+	NetOut     int     `json:"netout"`
+	DiskRead   int     `json:"diskread"`
+	DiskWrite  int     `json:"diskwrite"`
+	Uptime     int     `json:"uptime"`
+	Storage    string  `json:"storage"`
+	PluginType string  `json:"plugintype"`
+	Content    string  `json:"content"`
+	Shared     int     `json:"shared"`
+}
+
+// Resources lists the nodes, guests and storage of the whole cluster in one
+// call, or of the host itself when it is not part of a cluster.
+func (c *Client) Resources(ctx context.Context) ([]Resource, error) {
+	return get[[]Resource](ctx, c, "/cluster/resources")
+}
+
+// ClusterStatus is an entry of /cluster/status - the cluster itself, with
+// Type "cluster", or one of its nodes, with Type "node". A standalone host
+// only lists itself as a node.
+type ClusterStatus struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	Nodes   int    `json:"nodes"`
+	Quorate int    `json:"quorate"`
+	NodeID  int    `json:"nodeid"`
+	IP      string `json:"ip"`
+	Online  int    `json:"online"`
+	Local   int    `json:"local"`
+	Level   string `json:"level"`
+}
+
+// ClusterConfigNode is a node in the corosync configuration, from
+// /cluster/config/nodes. Rings holds the ring0_addr, ring1_addr and further
+// addresses of the node, in ring order.
+type ClusterConfigNode struct {
+	Node        string
+	NodeID      int
+	QuorumVotes int
+	Rings       []string
+}
+
+func (n *ClusterConfigNode) UnmarshalJSON(b []byte) error {
+	var fields map[string]any
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	*n = ClusterConfigNode{}
+	n.Node, _ = fields["node"].(string)
+	if n.Node == "" {
+		n.Node, _ = fields["name"].(string)
+	}
+	n.NodeID = looseInt(fields["nodeid"])
+	n.QuorumVotes = looseInt(fields["quorum_votes"])
+	for i := 0; ; i++ {
+		addr, ok := fields[fmt.Sprintf("ring%d_addr", i)].(string)
+		if !ok {
+			break
+		}
+		n.Rings = append(n.Rings, addr)
+	}
+	return nil
+}
+
+// MarshalJSON writes the node like Proxmox does, with the numbers as strings.
+func (n ClusterConfigNode) MarshalJSON() ([]byte, error) {
+	fields := map[string]string{
+		"node":         n.Node,
+		"name":         n.Node,
+		"nodeid":       strconv.Itoa(n.NodeID),
+		"quorum_votes": strconv.Itoa(n.QuorumVotes),
+	}
+	for i, addr := range n.Rings {
+		fields[fmt.Sprintf("ring%d_addr", i)] = addr
+	}
+	return json.Marshal(fields)
+}
+
+// looseInt reads a number that Proxmox sends either as a JSON number or as
+// a string.
+func looseInt(v any) int {
+	switch v := v.(type) {
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
+
+// ClusterStatus returns the cluster and its nodes as corosync sees them.
+func (c *Client) ClusterStatus(ctx context.Context) ([]ClusterStatus, error) {
+	return get[[]ClusterStatus](ctx, c, "/cluster/status")
+}
+
+// ClusterConfigNodes returns the nodes in the corosync configuration.
+func (c *Client) ClusterConfigNodes(ctx context.Context) ([]ClusterConfigNode, error) {
+	return get[[]ClusterConfigNode](ctx, c, "/cluster/config/nodes")
+}
@@ -0,0 +1,78 @@
+package pve
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Error describes a failed call. StatusCode is 0 when no response was
+// received, and Err holds the cause - a network or TLS error, or a response
+// that could not be decoded. For responses other than 2xx, Message and
+// Errors hold what Proxmox reported, and Body the raw response.
+type Error struct {
+	Host       string
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string
+	// Errors are the problems with single parameters, by parameter name
+	Errors map[string]string
+	Body   []byte
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		if e.StatusCode == 0 {
+			return e.Err.Error()
+		}
+		return fmt.Sprintf("%s %s on %s: %v", e.Method, e.Path, e.Host, e.Err)
+	}
+
+	msg := fmt.Sprintf("%s %s on %s returned %s", e.Method, e.Path, e.Host, e.Status)
+	if e.Message != "" && !strings.HasSuffix(e.Status, e.Message) {
+		msg += " - " + e.Message
+	}
+	for _, param := range slices.Sorted(maps.Keys(e.Errors)) {
+		msg += fmt.Sprintf(" - %s: %s", param, e.Errors[param])
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NotFound reports whether the API answered 404, or 500 with a message that
+// the object does not exist, as Proxmox does for unknown guests.
+func (e *Error) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound ||
+		(e.StatusCode == http.StatusInternalServerError && strings.Contains(e.Message, "does not exist"))
+}
+
+// statusError builds the Error for a response other than 2xx. Proxmox puts
+// the reason in the status line and, for most calls, in the body as well.
+func statusError(host, method, path string, res *http.Response, body []byte) *Error {
+	e := &Error{
+		Host:       host,
+		Method:     method,
+		Path:       path,
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Body:       body,
+	}
+	var payload struct {
+		Message string            `json:"message"`
+		Errors  map[string]string `json:"errors"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		e.Message = strings.TrimSpace(payload.Message)
+		e.Errors = payload.Errors
+	}
+	return e
+}
@@ -0,0 +1,130 @@
+package pve
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		body     string
+		message  string
+		errors   map[string]string
+		text     string
+		notFound bool
+	}{
+		{
+			name:    "parameter errors",
+			status:  "400 Parameter verification failed.",
+			body:    `{"data":null,"message":"Parameter verification failed.\n","errors":{"vmid":"invalid format","node":"required"}}`,
+			message: "Parameter verification failed.",
+			errors:  map[string]string{"vmid": "invalid format", "node": "required"},
+			text:    "GET /nodes/pve1/qemu on pve1 returned 400 Parameter verification failed. - node: required - vmid: invalid format",
+		},
+		{
+			name:     "unknown guest",
+			status:   "500 Configuration file 'nodes/pve1/qemu-server/999.conf' does not exist",
+			body:     `{"data":null,"message":"Configuration file 'nodes/pve1/qemu-server/999.conf' does not exist\n"}`,
+			message:  "Configuration file 'nodes/pve1/qemu-server/999.conf' does not exist",
+			text:     "GET /nodes/pve1/qemu on pve1 returned 500 Configuration file 'nodes/pve1/qemu-server/999.conf' does not exist",
+			notFound: true,
+		},
+		{
+			name:     "unknown path",
+			status:   "404 Not Found",
+			body:     `{"data":null}`,
+			text:     "GET /nodes/pve1/qemu on pve1 returned 404 Not Found",
+			notFound: true,
+		},
+		{
+			name:   "rejected token",
+			status: "401 authentication failure",
+			body:   "",
+			text:   "GET /nodes/pve1/qemu on pve1 returned 401 authentication failure",
+		},
+		{
+			name:    "offline node",
+			status:  "595 No route to host",
+			body:    `{"data":null,"message":"No route to host\n"}`,
+			message: "No route to host",
+			text:    "GET /nodes/pve1/qemu on pve1 returned 595 No route to host",
+		},
+		{
+			name:   "proxy error page",
+			status: "502 Bad Gateway",
+			body:   "<html><body>Bad Gateway</body></html>",
+			text:   "GET /nodes/pve1/qemu on pve1 returned 502 Bad Gateway",
+		},
+		{
+			name:    "message not in the status line",
+			status:  "500 Internal Server Error",
+			body:    `{"data":null,"message":"storage 'ceph' is not online"}`,
+			message: "storage 'ceph' is not online",
+			text:    "GET /nodes/pve1/qemu on pve1 returned 500 Internal Server Error - storage 'ceph' is not online",
+		},
+	}
+	for _, tt := range tests {
+		code, _ := strconv.Atoi(strings.Fields(tt.status)[0])
+		res := &http.Response{StatusCode: code, Status: tt.status}
+		e := statusError("pve1", http.MethodGet, "/nodes/pve1/qemu", res, []byte(tt.body))
+
+		if e.StatusCode != code || e.Message != tt.message || string(e.Body) != tt.body || e.Err != nil {
+			t.Errorf("%s: unexpected error %+v", tt.name, e)
+		}
+		if len(e.Errors) != len(tt.errors) {
+			t.Errorf("%s: expected the parameter errors %v, got %v", tt.name, tt.errors, e.Errors)
+		}
+		for param, msg := range tt.errors {
+			if e.Errors[param] != msg {
+				t.Errorf("%s: expected %s for %s, got %q", tt.name, msg, param, e.Errors[param])
+			}
+		}
+		if got := e.Error(); got != tt.text {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.text, got)
+		}
+		if e.NotFound() != tt.notFound {
+			t.Errorf("%s: expected NotFound to be %v", tt.name, tt.notFound)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/nodes":
+			w.Write([]byte(`{"data": [`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"data":null,"message":"Permission check failed (/nodes/pve1, Sys.Audit)\n"}`))
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	client := New(u.Hostname(), port, WithHTTPClient(srv.Client()))
+
+	_, err := client.NodeStatus(context.Background(), "pve1")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || apiErr.Path != "/nodes/pve1/status" || apiErr.Message != "Permission check failed (/nodes/pve1, Sys.Audit)" {
+		t.Errorf("expected the 403 with the message of Proxmox, got %#v", err)
+	}
+
+	_, err = client.Nodes(context.Background())
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusOK || apiErr.Err == nil || !strings.Contains(err.Error(), "failed to decode the response") {
+		t.Errorf("expected a decode error with the status of the response, got %#v", err)
+	}
+
+	srv.Close()
+	_, err = client.Nodes(context.Background())
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 0 || apiErr.Err == nil || err.Error() != apiErr.Err.Error() {
+		t.Errorf("expected a network error without a status, got %#v", err)
+	}
+}
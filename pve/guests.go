@@ -0,0 +1,127 @@
+package pve
+
+import "context"
+
+// VM is a QEMU guest as listed by /nodes/{node}/qemu. The sizes are bytes.
+type VM struct {
+	Vmid      int     `json:"vmid"`
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Tags      string  `json:"tags"`
+	Cpus      int     `json:"cpus"`
+	CPU       float64 `json:"cpu"`
+	Mem       int     `json:"mem"`
+	MaxMem    int     `json:"maxmem"`
+	MaxDisk   int     `json:"maxdisk"`
+	NetIn     int     `json:"netin"`
+	NetOut    int     `json:"netout"`
+	DiskRead  int     `json:"diskread"`
+	DiskWrite int     `json:"diskwrite"`
+	Uptime    int     `json:"uptime"`
+}
+
+// Container is an LXC container as listed by /nodes/{node}/lxc. The sizes
+// are bytes.
+type Container struct {
+	Vmid      int     `json:"vmid"`
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Tags      string  `json:"tags"`
+	Cpus      int     `json:"cpus"`
+	CPU       float64 `json:"cpu"`
+	Mem       int     `json:"mem"`
+	MaxMem    int     `json:"maxmem"`
+	Disk      int     `json:"disk"`
+	MaxDisk   int     `json:"maxdisk"`
+	NetIn     int     `json:"netin"`
+	NetOut    int     `json:"netout"`
+	DiskRead  int     `json:"diskread"`
+	DiskWrite int     `json:"diskwrite"`
+	Uptime    int     `json:"uptime"`
+}
+
+// VMStatus is the current status of a QEMU guest. Agent is 1 when the guest
+// agent is enabled in the VM config.
+type VMStatus struct {
+	Name           string  `json:"name"`
+	Status         string  `json:"status"`
+	QMPStatus      string  `json:"qmpstatus"`
+	Agent          int     `json:"agent"`
+	Cpus           int     `json:"cpus"`
+	CPU            float64 `json:"cpu"`
+	Mem            int     `json:"mem"`
+	MaxMem         int     `json:"maxmem"`
+	DiskRead       int     `json:"diskread"`
+	DiskWrite      int     `json:"diskwrite"`
+	NetIn          int     `json:"netin"`
+	NetOut         int     `json:"netout"`
+	Uptime         int     `json:"uptime"`
+	RunningMachine string  `json:"running-machine"`
+}
+
+// OSInfo is the operating system reported by the guest agent.
+type OSInfo struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	PrettyName    string `json:"pretty-name"`
+	Version       string `json:"version"`
+	VersionID     string `json:"version-id"`
+	KernelVersion string `json:"kernel-version"`
+	KernelRelease string `json:"kernel-release"`
+	Machine       string `json:"machine"`
+}
+
+// NetworkInterface is a network interface reported by the guest agent.
+type NetworkInterface struct {
+	Name            string      `json:"name"`
+	HardwareAddress string      `json:"hardware-address"`
+	IPAddresses     []IPAddress `json:"ip-addresses"`
+}
+
+// IPAddress is an address of a NetworkInterface. Type is ipv4 or ipv6.
+type IPAddress struct {
+	Address string `json:"ip-address"`
+	Prefix  int    `json:"prefix"`
+	Type    string `json:"ip-address-type"`
+}
+
+// agentResult unwraps the result of a guest agent command.
+type agentResult[T any] struct {
+	Result T `json:"result"`
+}
+
+// VMs lists the QEMU guests of a node.
+func (c *Client) VMs(ctx context.Context, node string) ([]VM, error) {
+	return get[[]VM](ctx, c, nodePath(node, "qemu"))
+}
+
+// Containers lists the LXC containers of a node.
+func (c *Client) Containers(ctx context.Context, node string) ([]Container, error) {
+	return get[[]Container](ctx, c, nodePath(node, "lxc"))
+}
+
+// VMStatus returns the current status of a QEMU guest.
+func (c *Client) VMStatus(ctx context.Context, node string, vmid int) (VMStatus, error) {
+	return get[VMStatus](ctx, c, guestPath(node, "qemu", vmid, "status", "current"))
+}
+
+// AgentHostName asks the guest agent of a running VM for its hostname.
+func (c *Client) AgentHostName(ctx context.Context, node string, vmid int) (string, error) {
+	res, err := get[agentResult[struct {
+		HostName string `json:"host-name"`
+	}]](ctx, c, guestPath(node, "qemu", vmid, "agent", "get-host-name"))
+	return res.Result.HostName, err
+}
+
+// AgentOSInfo asks the guest agent of a running VM for its operating system.
+func (c *Client) AgentOSInfo(ctx context.Context, node string, vmid int) (OSInfo, error) {
+	res, err := get[agentResult[OSInfo]](ctx, c, guestPath(node, "qemu", vmid, "agent", "get-osinfo"))
+	return res.Result, err
+}
+
+// AgentNetworkInterfaces asks the guest agent of a running VM for its
+// network interfaces and addresses.
+func (c *Client) AgentNetworkInterfaces(ctx context.Context, node string, vmid int) ([]NetworkInterface, error) {
+	res, err := get[agentResult[[]NetworkInterface]](ctx, c, guestPath(node, "qemu", vmid, "agent", "network-get-interfaces"))
+	return res.Result, err
+}
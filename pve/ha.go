@@ -0,0 +1,76 @@
+package pve
+
+import "context"
+
+// HAManagerStatus is the state of the HA stack from
+// /cluster/ha/status/manager_status: what the cluster resource manager (CRM)
+// decided and what the local resource manager (LRM) of every node reports.
+type HAManagerStatus struct {
+	ManagerStatus HAManager              `json:"manager_status"`
+	LRMStatus     map[string]HALRMStatus `json:"lrm_status"`
+}
+
+// HAManager is the state of the CRM. NodeStatus is the state of every node as
+// the master sees it - online, maintenance, unknown, fence or gone.
+// ServiceStatus is keyed by the service ID, e.g. vm:100.
+type HAManager struct {
+	MasterNode    string                     `json:"master_node"`
+	NodeStatus    map[string]string          `json:"node_status"`
+	ServiceStatus map[string]HAServiceStatus `json:"service_status"`
+	Timestamp     int64                      `json:"timestamp"`
+}
+
+// HAServiceStatus is the current state of an HA resource - started, stopped,
+// request_stop, migrate, relocate, fence, recovery, freeze or error.
+type HAServiceStatus struct {
+	Node  string `json:"node"`
+	State string `json:"state"`
+	UID   string `json:"uid"`
+}
+
+// HALRMStatus is the LRM of a node. Mode is active, restart, shutdown or
+// maintenance; State is wait_for_agent_lock, active or lost_agent_lock.
+type HALRMStatus struct {
+	Mode      string `json:"mode"`
+	State     string `json:"state"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// HAResource is a guest managed by HA, from /cluster/ha/resources. State is
+// the requested state - started, stopped, enabled, disabled or ignored.
+type HAResource struct {
+	SID         string `json:"sid"`
+	Type        string `json:"type"`
+	State       string `json:"state"`
+	Group       string `json:"group"`
+	MaxRestart  int    `json:"max_restart"`
+	MaxRelocate int    `json:"max_relocate"`
+	Comment     string `json:"comment"`
+}
+
+// HAGroup is an HA group from /cluster/ha/groups. Nodes is the list of
+// members as node[:priority], separated by commas.
+type HAGroup struct {
+	Group      string `json:"group"`
+	Nodes      string `json:"nodes"`
+	Restricted int    `json:"restricted"`
+	NoFailback int    `json:"nofailback"`
+	Comment    string `json:"comment"`
+}
+
+// HAManagerStatus returns the state of the HA manager and the LRM of every
+// node.
+func (c *Client) HAManagerStatus(ctx context.Context) (HAManagerStatus, error) {
+	return get[HAManagerStatus](ctx, c, "/cluster/ha/status/manager_status")
+}
+
+// HAResources returns the configured HA resources.
+func (c *Client) HAResources(ctx context.Context) ([]HAResource, error) {
+	return get[[]HAResource](ctx, c, "/cluster/ha/resources")
+}
+
+// HAGroups returns the HA groups. Since PVE 9 groups are migrated to rules,
+// and the call fails once that happened.
+func (c *Client) HAGroups(ctx context.Context) ([]HAGroup, error) {
+	return get[[]HAGroup](ctx, c, "/cluster/ha/groups")
+}
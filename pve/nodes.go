@@ -0,0 +1,104 @@
+package pve
+
+import "context"
+
+// Node is a node as listed by /nodes. The usage fields are only set for
+// online nodes.
+type Node struct {
+	Node    string  `json:"node"`
+	Status  string  `json:"status"`
+	MaxCPU  int     `json:"maxcpu"`
+	CPU     float64 `json:"cpu"`
+	Mem     int     `json:"mem"`
+	MaxMem  int     `json:"maxmem"`
+	Disk    int     `json:"disk"`
+	MaxDisk int     `json:"maxdisk"`
+	Uptime  int     `json:"uptime"`
+}
+
+// Online reports whether Proxmox considers the node online.
+func (n Node) Online() bool {
+	return n.Status == "online"
+}
+
+// NodeStatus is the status of a node from /nodes/{node}/status.
+type NodeStatus struct {
+	CurrentKernel struct {
+		Version string `json:"version"`
+		Sysname string `json:"sysname"`
+		Release string `json:"release"`
+		Machine string `json:"machine"`
+	} `json:"current-kernel"`
+	Memory   Usage `json:"memory"`
+	Swap     Usage `json:"swap"`
+	BootInfo struct {
+		Secureboot int    `json:"secureboot"`
+		Mode       string `json:"mode"`
+	} `json:"boot-info"`
+	CPUInfo struct {
+		Cpus    int    `json:"cpus"`
+		Flags   string `json:"flags"`
+		Hvm     string `json:"hvm"`
+		Mhz     string `json:"mhz"`
+		Model   string `json:"model"`
+		UserHz  int    `json:"user_hz"`
+		Sockets int    `json:"sockets"`
+		Cores   int    `json:"cores"`
+	} `json:"cpuinfo"`
+	PVEVersion string   `json:"pveversion"`
+	LoadAvg    []string `json:"loadavg"`
+	KSM        struct {
+		Shared int `json:"shared"`
+	} `json:"ksm"`
+	Wait   float64 `json:"wait"`
+	Uptime int     `json:"uptime"`
+	RootFS struct {
+		Usage
+		Avail int `json:"avail"`
+	} `json:"rootfs"`
+	CPU  float64 `json:"cpu"`
+	Idle int     `json:"idle"`
+}
+
+// Usage is the used, free and total bytes of memory, swap or a filesystem.
+type Usage struct {
+	Used  int `json:"used"`
+	Free  int `json:"free"`
+	Total int `json:"total"`
+}
+
+// NodeDNS is the DNS configuration of a node.
+type NodeDNS struct {
+	Search string `json:"search"`
+	DNS1   string `json:"dns1"`
+	DNS2   string `json:"dns2"`
+	DNS3   string `json:"dns3"`
+}
+
+// NodeTime is the clock of a node, as Unix times.
+type NodeTime struct {
+	Time      int    `json:"time"`
+	LocalTime int    `json:"localtime"`
+	Timezone  string `json:"timezone"`
+}
+
+// Nodes lists the nodes of the cluster, or the host itself when it is not
+// part of a cluster.
+func (c *Client) Nodes(ctx context.Context) ([]Node, error) {
+	return get[[]Node](ctx, c, "/nodes")
+}
+
+// NodeStatus returns the status of a node.
+func (c *Client) NodeStatus(ctx context.Context, node string) (NodeStatus, error) {
+	return get[NodeStatus](ctx, c, nodePath(node, "status"))
+}
+
+// NodeDNS returns the DNS configuration of a node.
+func (c *Client) NodeDNS(ctx context.Context, node string) (NodeDNS, error) {
+	return get[NodeDNS](ctx, c, nodePath(node, "dns"))
+}
+
+// NodeTime returns the time and timezone of a node.
+func (c *Client) NodeTime(ctx context.Context, node string) (NodeTime, error) {
+	return get[NodeTime](ctx, c, nodePath(node, "time"))
+}
@@ -0,0 +1,126 @@
+package pvetest
+
+import "github.com/ksl28/proxmox-simple-api/pve"
+
+const (
+	gib = 1024 * 1024 * 1024
+	mib = 1024 * 1024
+)
+
+// DefaultFixtures returns a two node cluster. pve1 runs VM 100 with the
+// guest agent, has the stopped VM 101, container 200, two storages and two
+// disks. pve2 runs container 201 and has one storage and one disk. Both
+// nodes have one vote in the cluster lab-cluster. VM 100 and container 201
+// are HA resources with pve1 as master; VM 100 prefers pve1 through the
+// group prefer-pve1. Every call returns a fresh copy, so tests may change
+// it.
+func DefaultFixtures() Fixtures {
+	return Fixtures{
+		Version: pve.Version{Version: "8.3.2", Release: "8.3", RepoID: "3e76eec21c4a14a7"},
+		Nodes:   []Node{pve1(), pve2()},
+		Cluster: &Cluster{
+			Name:    "lab-cluster",
+			Version: 2,
+			Nodes: []pve.ClusterConfigNode{
+				{Node: "pve1", NodeID: 1, QuorumVotes: 1, Rings: []string{"10.0.0.11", "10.1.0.11"}},
+				{Node: "pve2", NodeID: 2, QuorumVotes: 1, Rings: []string{"10.0.0.12", "10.1.0.12"}},
+			},
+		},
+		HA: &HA{
+			Master: "pve1",
+			Resources: []pve.HAResource{
+				{SID: "vm:100", Type: "vm", State: "started", Group: "prefer-pve1", MaxRestart: 1, MaxRelocate: 1},
+				{SID: "ct:201", Type: "ct", State: "started", MaxRestart: 1, MaxRelocate: 1, Comment: "reverse proxy"},
+			},
+			Groups: []pve.HAGroup{
+				{Group: "prefer-pve1", Nodes: "pve1:2,pve2:1", NoFailback: 1},
+			},
+		},
+	}
+}
+
+func pve1() Node {
+	n := Node{
+		Node: pve.Node{
+			Node: "pve1", Status: "online", MaxCPU: 16, CPU: 0.25,
+			Mem: 24 * gib, MaxMem: 64 * gib, Disk: 20 * gib, MaxDisk: 100 * gib, Uptime: 86400,
+		},
+		DNS:  pve.NodeDNS{Search: "lab.example", DNS1: "10.0.0.53", DNS2: "10.0.0.54"},
+		Time: pve.NodeTime{Time: 1735689600, LocalTime: 1735693200, Timezone: "Europe/Copenhagen"},
+		Storage: []pve.Storage{
+			{Storage: "local", Type: "dir", Content: "iso,vztmpl,backup", Active: 1, Enabled: 1, Total: 100 * gib, Used: 20 * gib, Avail: 80 * gib},
+			{Storage: "ceph", Type: "rbd", Content: "images,rootdir", Active: 1, Enabled: 1, Shared: 1, Total: 4096 * gib, Used: 1024 * gib, Avail: 3072 * gib},
+		},
+		Disks: []pve.Disk{
+			{DevPath: "/dev/nvme0n1", Model: "Samsung SSD 980 PRO 1TB", Serial: "S5GXNF0R100001", Type: "nvme", Health: "PASSED", GPT: 1, Size: 1000 * gib, Wearout: float64(97), RPM: float64(0), Used: "LVM"},
+			{DevPath: "/dev/sda", Vendor: "ATA", Model: "ST4000NM0035", Serial: "ZC1ABCDE", Type: "hdd", Health: "PASSED", GPT: 1, Size: 4000 * gib, Wearout: "N/A", RPM: float64(7200), Used: "ZFS"},
+		},
+		VMs: []VM{
+			{
+				VM: pve.VM{Vmid: 100, Name: "web01", Status: "running", Tags: "prod;web", Cpus: 4, CPU: 0.12, Mem: 3 * gib, MaxMem: 8 * gib, MaxDisk: 32 * gib, Uptime: 7200},
+				Current: pve.VMStatus{
+					Name: "web01", Status: "running", QMPStatus: "running", Agent: 1, Cpus: 4, CPU: 0.12,
+					Mem: 3 * gib, MaxMem: 8 * gib, DiskRead: 512 * mib, DiskWrite: 256 * mib, NetIn: 64 * mib, NetOut: 128 * mib,
+					Uptime: 7200, RunningMachine: "pc-i440fx-8.1+pve0",
+				},
+				HostName: "web01.lab.example",
+				OSInfo:   pve.OSInfo{ID: "debian", Name: "Debian GNU/Linux", PrettyName: "Debian GNU/Linux 12 (bookworm)", Version: "12 (bookworm)", VersionID: "12", KernelVersion: "#1 SMP PREEMPT_DYNAMIC Debian 6.1.119-1", KernelRelease: "6.1.0-28-amd64", Machine: "x86_64"},
+				Interfaces: []pve.NetworkInterface{
+					{Name: "lo", HardwareAddress: "00:00:00:00:00:00", IPAddresses: []pve.IPAddress{{Address: "127.0.0.1", Prefix: 8, Type: "ipv4"}}},
+					{Name: "eth0", HardwareAddress: "bc:24:11:00:01:00", IPAddresses: []pve.IPAddress{{Address: "10.0.1.10", Prefix: 24, Type: "ipv4"}, {Address: "fe80::be24:11ff:fe00:100", Prefix: 64, Type: "ipv6"}}},
+				},
+			},
+			{
+				VM:      pve.VM{Vmid: 101, Name: "build01", Status: "stopped", Cpus: 2, MaxMem: 4 * gib, MaxDisk: 64 * gib},
+				Current: pve.VMStatus{Name: "build01", Status: "stopped", QMPStatus: "stopped", Agent: 1, Cpus: 2, MaxMem: 4 * gib},
+			},
+		},
+		Containers: []pve.Container{
+			{Vmid: 200, Name: "dns01", Status: "running", Tags: "infra", Cpus: 1, CPU: 0.01, Mem: 256 * mib, MaxMem: 512 * mib, Disk: 1 * gib, MaxDisk: 8 * gib, NetIn: 10 * mib, NetOut: 20 * mib, Uptime: 3600},
+		},
+	}
+	n.NodeStatus.PVEVersion = "pve-manager/8.3.2/3e76eec21c4a14a7"
+	n.NodeStatus.CurrentKernel.Release = "6.8.12-5-pve"
+	n.NodeStatus.CurrentKernel.Machine = "x86_64"
+	n.NodeStatus.CurrentKernel.Sysname = "Linux"
+	n.NodeStatus.BootInfo.Mode = "efi"
+	n.NodeStatus.CPUInfo.Model = "AMD EPYC 7302P 16-Core Processor"
+	n.NodeStatus.CPUInfo.Mhz = "3000.000"
+	n.NodeStatus.CPUInfo.Cpus = 16
+	n.NodeStatus.CPUInfo.Cores = 16
+	n.NodeStatus.CPUInfo.Sockets = 1
+	n.NodeStatus.Memory = pve.Usage{Used: 24 * gib, Free: 40 * gib, Total: 64 * gib}
+	n.NodeStatus.Uptime = 86400
+	return n
+}
+
+func pve2() Node {
+	n := Node{
+		Node: pve.Node{
+			Node: "pve2", Status: "online", MaxCPU: 8, CPU: 0.05,
+			Mem: 4 * gib, MaxMem: 32 * gib, Disk: 10 * gib, MaxDisk: 100 * gib, Uptime: 172800,
+		},
+		DNS:  pve.NodeDNS{Search: "lab.example", DNS1: "10.0.0.53"},
+		Time: pve.NodeTime{Time: 1735689600, LocalTime: 1735693200, Timezone: "Europe/Copenhagen"},
+		Storage: []pve.Storage{
+			{Storage: "local", Type: "dir", Content: "iso,vztmpl,backup", Active: 1, Enabled: 1, Total: 100 * gib, Used: 10 * gib, Avail: 90 * gib},
+		},
+		Disks: []pve.Disk{
+			{DevPath: "/dev/sda", Model: "INTEL SSDSC2KB480G8", Serial: "PHYF000000AB", Type: "ssd", Health: "OK", GPT: 1, Size: 480 * gib, Wearout: float64(88), RPM: float64(0)},
+		},
+		Containers: []pve.Container{
+			{Vmid: 201, Name: "proxy01", Status: "running", Cpus: 2, CPU: 0.03, Mem: 512 * mib, MaxMem: 1024 * mib, Disk: 2 * gib, MaxDisk: 8 * gib, Uptime: 7200},
+		},
+	}
+	n.NodeStatus.PVEVersion = "pve-manager/8.3.2/3e76eec21c4a14a7"
+	n.NodeStatus.CurrentKernel.Release = "6.8.12-5-pve"
+	n.NodeStatus.CurrentKernel.Machine = "x86_64"
+	n.NodeStatus.BootInfo.Mode = "legacy-bios"
+	n.NodeStatus.CPUInfo.Model = "Intel(R) Xeon(R) E-2278G CPU @ 3.40GHz"
+	n.NodeStatus.CPUInfo.Mhz = "3400.000"
+	n.NodeStatus.CPUInfo.Cpus = 8
+	n.NodeStatus.CPUInfo.Cores = 8
+	n.NodeStatus.CPUInfo.Sockets = 1
+	n.NodeStatus.Uptime = 172800
+	return n
+}
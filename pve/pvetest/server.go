@@ -0,0 +1,581 @@
+// Package pvetest provides an in-process fake of the Proxmox VE API for
+// tests. It serves the read calls of the pve package from fixture data, and
+// can inject offline nodes, error responses, slow responses and bad JSON.
+// Besides API tokens it accepts ticket logins for the users of the fixtures.
+package pvetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksl28/proxmox-simple-api/pve"
+)
+
+const apiPrefix = "/api2/json"
+
+// Fixtures is the data the server answers with.
+type Fixtures struct {
+	Version pve.Version
+	Nodes   []Node
+	// Cluster is nil for a standalone host
+	Cluster *Cluster
+	// HA is nil when no HA resources are configured
+	HA *HA
+	// Users are the password logins /access/ticket accepts, by user name
+	Users map[string]string
+}
+
+// HA is the HA configuration of the cluster. The current state of a resource
+// follows from its guest - started while it runs, stopped otherwise and
+// fence while its node is offline. The LRM of a node is active while the
+// node runs an HA resource.
+type HA struct {
+	Master    string
+	Resources []pve.HAResource
+	Groups    []pve.HAGroup
+}
+
+// Cluster is the corosync configuration of the nodes. The quorum in
+// /cluster/status follows from the votes of the nodes that are online. The
+// first node is the one that answers.
+type Cluster struct {
+	Name    string
+	Version int
+	Nodes   []pve.ClusterConfigNode
+}
+
+// Node is a node with everything that is served below /nodes/{node}. The
+// status in the embedded pve.Node is overridden by SetOffline.
+type Node struct {
+	pve.Node
+	NodeStatus pve.NodeStatus
+	DNS        pve.NodeDNS
+	Time       pve.NodeTime
+	Storage    []pve.Storage
+	Disks      []pve.Disk
+	VMs        []VM
+	Containers []pve.Container
+	Tasks      []pve.Task
+}
+
+// VM is a QEMU guest with its current status and what its guest agent
+// reports. The agent calls fail like on a real node unless the VM runs with
+// the agent enabled.
+type VM struct {
+	pve.VM
+	Current    pve.VMStatus
+	HostName   string
+	OSInfo     pve.OSInfo
+	Interfaces []pve.NetworkInterface
+}
+
+// Fault changes the answer to the requests it is injected for. The delay is
+// applied first, then either the status or the bad JSON is sent.
+type Fault struct {
+	Delay   time.Duration
+	Status  int
+	Message string
+	BadJSON bool
+}
+
+// Server is a fake Proxmox VE API on a TLS test server. Requests must carry
+// an API token, like on a real node.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	fixtures Fixtures
+	offline  map[string]bool
+	faults   map[string]Fault
+	requests map[string]int
+	// tickets maps the issued tickets to their CSRF prevention token
+	tickets map[string]string
+	issued  int
+}
+
+// NewServer starts a server with the given fixtures. Close it when done.
+func NewServer(fixtures Fixtures) *Server {
+	s := &Server{
+		fixtures: fixtures,
+		offline:  make(map[string]bool),
+		faults:   make(map[string]Fault),
+		requests: make(map[string]int),
+		tickets:  make(map[string]string),
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Address returns the host:port the server listens on.
+func (s *Server) Address() string {
+	return s.Listener.Addr().String()
+}
+
+// SetOffline reports the node as offline in /nodes and answers the calls
+// for the node with 595, as a real cluster does for unreachable nodes.
+func (s *Server) SetOffline(node string, offline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offline[node] = offline
+}
+
+// Inject applies f to the requests whose path below /api2/json matches
+// pattern, as with path.Match - e.g. "/nodes/*/storage".
+func (s *Server) Inject(pattern string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[pattern] = f
+}
+
+// Reset removes all faults and brings every node back online.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.faults)
+	clear(s.offline)
+}
+
+// ExpireTickets invalidates every ticket issued so far, as if they ran out or
+// the cluster restarted.
+func (s *Server) ExpireTickets() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.tickets)
+}
+
+// Requests returns how often the path below /api2/json was requested.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	s.mu.Lock()
+	s.requests[p]++
+	fault, faulty := s.fault(p)
+	s.mu.Unlock()
+
+	if p == "/access/ticket" && r.Method == http.MethodPost {
+		s.login(w, r)
+		return
+	}
+	if status, msg := s.authenticate(r); status != http.StatusOK {
+		writeError(w, status, msg)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusNotImplemented, "Method '"+r.Method+" "+p+"' not implemented")
+		return
+	}
+	if faulty {
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		switch {
+		case fault.BadJSON:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"data":[{"node":`))
+			return
+		case fault.Status != 0:
+			writeError(w, fault.Status, fault.Message)
+			return
+		}
+	}
+
+	data, status, msg := s.route(p)
+	if status != http.StatusOK {
+		writeError(w, status, msg)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+// authenticate accepts an API token, or a ticket cookie together with its
+// CSRF prevention token on writes.
+func (s *Server) authenticate(r *http.Request) (int, string) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "PVEAPIToken=") {
+		return http.StatusOK, ""
+	}
+	cookie, err := r.Cookie("PVEAuthCookie")
+	if err != nil {
+		return http.StatusUnauthorized, "authentication failure"
+	}
+	s.mu.Lock()
+	csrf, ok := s.tickets[cookie.Value]
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		return http.StatusUnauthorized, "authentication failure"
+	case r.Method != http.MethodGet && r.Header.Get("CSRFPreventionToken") != csrf:
+		return http.StatusUnauthorized, "Permission check failed (invalid csrf token)"
+	}
+	return http.StatusOK, ""
+}
+
+// login answers /access/ticket for a user with its password, or with a
+// ticket that is still valid, which renews it.
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	user, password := r.PostFormValue("username"), r.PostFormValue("password")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, renewal := s.tickets[password]
+	want, known := s.fixtures.Users[user]
+	if !renewal && (!known || password != want) {
+		writeError(w, http.StatusUnauthorized, "authentication failure")
+		return
+	}
+	s.issued++
+	ticket := fmt.Sprintf("PVE:%s:%08X::fixture-%d", user, s.issued, s.issued)
+	csrf := fmt.Sprintf("%08X:csrf-%d", s.issued, s.issued)
+	s.tickets[ticket] = csrf
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{
+		"username": user, "ticket": ticket, "CSRFPreventionToken": csrf,
+	}})
+}
+
+// fault returns the fault for a path. The caller holds s.mu.
+func (s *Server) fault(p string) (Fault, bool) {
+	for pattern, f := range s.faults {
+		if ok, _ := path.Match(pattern, p); ok {
+			return f, true
+		}
+	}
+	return Fault{}, false
+}
+
+// route looks up the fixture data for a path.
+func (s *Server) route(p string) (any, int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	switch p {
+	case "/version":
+		return s.fixtures.Version, http.StatusOK, ""
+	case "/cluster/resources":
+		return s.resources(), http.StatusOK, ""
+	case "/cluster/status":
+		return s.clusterStatus(), http.StatusOK, ""
+	case "/cluster/config/nodes":
+		if s.fixtures.Cluster == nil {
+			return nil, http.StatusInternalServerError, "unable to read file '/etc/pve/corosync.conf'"
+		}
+		return orEmpty(s.fixtures.Cluster.Nodes), http.StatusOK, ""
+	case "/cluster/ha/status/manager_status":
+		return s.haManagerStatus(), http.StatusOK, ""
+	case "/cluster/ha/resources", "/cluster/ha/groups":
+		var ha HA
+		if s.fixtures.HA != nil {
+			ha = *s.fixtures.HA
+		}
+		if p == "/cluster/ha/groups" {
+			return orEmpty(ha.Groups), http.StatusOK, ""
+		}
+		return orEmpty(ha.Resources), http.StatusOK, ""
+	}
+	if parts[0] != "nodes" {
+		return nil, http.StatusNotImplemented, "Method 'GET " + p + "' not implemented"
+	}
+	if len(parts) == 1 {
+		nodes := make([]pve.Node, 0, len(s.fixtures.Nodes))
+		for _, n := range s.fixtures.Nodes {
+			node := n.Node
+			if s.offline[node.Node] {
+				node = pve.Node{Node: node.Node, Status: "offline"}
+			}
+			nodes = append(nodes, node)
+		}
+		return nodes, http.StatusOK, ""
+	}
+
+	node := s.node(parts[1])
+	if node == nil {
+		return nil, http.StatusInternalServerError, fmt.Sprintf("hostname lookup '%s' failed - failed to get address info for: %s: Name or service not known", parts[1], parts[1])
+	}
+	if s.offline[node.Node.Node] {
+		return nil, 595, "No route to host"
+	}
+
+	switch rest := strings.Join(parts[2:], "/"); rest {
+	case "status":
+		return node.NodeStatus, http.StatusOK, ""
+	case "dns":
+		return node.DNS, http.StatusOK, ""
+	case "time":
+		return node.Time, http.StatusOK, ""
+	case "storage":
+		return orEmpty(node.Storage), http.StatusOK, ""
+	case "disks/list":
+		return orEmpty(node.Disks), http.StatusOK, ""
+	case "tasks":
+		return orEmpty(node.Tasks), http.StatusOK, ""
+	case "lxc":
+		return orEmpty(node.Containers), http.StatusOK, ""
+	case "qemu":
+		vms := make([]pve.VM, 0, len(node.VMs))
+		for _, vm := range node.VMs {
+			vms = append(vms, vm.VM)
+		}
+		return vms, http.StatusOK, ""
+	}
+
+	if len(parts) < 5 || parts[2] != "qemu" {
+		return nil, http.StatusNotImplemented, "Method 'GET " + p + "' not implemented"
+	}
+	vmid, _ := strconv.Atoi(parts[3])
+	vm := node.vm(vmid)
+	if vm == nil {
+		return nil, http.StatusInternalServerError, fmt.Sprintf("Configuration file 'nodes/%s/qemu-server/%s.conf' does not exist", node.Node.Node, parts[3])
+	}
+	call := strings.Join(parts[4:], "/")
+	if call == "status/current" {
+		return vm.Current, http.StatusOK, ""
+	}
+	if parts[4] == "agent" {
+		if vm.Current.Status != "running" {
+			return nil, http.StatusInternalServerError, fmt.Sprintf("VM %d is not running", vmid)
+		}
+		if vm.Current.Agent != 1 {
+			return nil, http.StatusInternalServerError, "No QEMU guest agent configured"
+		}
+		switch call {
+		case "agent/get-host-name":
+			return map[string]any{"result": map[string]string{"host-name": vm.HostName}}, http.StatusOK, ""
+		case "agent/get-osinfo":
+			return map[string]any{"result": vm.OSInfo}, http.StatusOK, ""
+		case "agent/network-get-interfaces":
+			return map[string]any{"result": orEmpty(vm.Interfaces)}, http.StatusOK, ""
+		}
+	}
+	return nil, http.StatusNotImplemented, "Method 'GET " + p + "' not implemented"
+}
+
+// resources lists the nodes, guests and storage like /cluster/resources.
+// The guests and storage of offline nodes are kept with the status unknown
+// and without usage. The caller holds s.mu.
+func (s *Server) resources() []pve.Resource {
+	resources := []pve.Resource{}
+	for _, n := range s.fixtures.Nodes {
+		name := n.Node.Node
+		offline := s.offline[name]
+		node := pve.Resource{
+			ID: "node/" + name, Type: pve.ResourceNode, Node: name, Status: n.Status,
+			MaxCPU: n.MaxCPU, CPU: n.CPU, Mem: n.Mem, MaxMem: n.MaxMem, Disk: n.Disk, MaxDisk: n.MaxDisk, Uptime: n.Uptime,
+		}
+		if offline {
+			node = pve.Resource{ID: node.ID, Type: pve.ResourceNode, Node: name, Status: "offline"}
+		}
+		resources = append(resources, node)
+
+		for _, vm := range n.VMs {
+			r := pve.Resource{
+				ID: "qemu/" + strconv.Itoa(vm.Vmid), Type: pve.ResourceQemu, Node: name, Vmid: vm.Vmid, Name: vm.Name, Status: vm.Status, Tags: vm.Tags,
+				MaxCPU: vm.Cpus, CPU: vm.CPU, Mem: vm.Mem, MaxMem: vm.MaxMem, MaxDisk: vm.MaxDisk,
+				NetIn: vm.NetIn, NetOut: vm.NetOut, DiskRead: vm.DiskRead, DiskWrite: vm.DiskWrite, Uptime: vm.Uptime,
+			}
+			if offline {
+				r = pve.Resource{ID: r.ID, Type: r.Type, Node: name, Vmid: vm.Vmid, Name: vm.Name, Status: "unknown", Tags: vm.Tags, MaxCPU: vm.Cpus, MaxMem: vm.MaxMem, MaxDisk: vm.MaxDisk}
+			}
+			if _, state, ok := s.haService("vm:" + strconv.Itoa(vm.Vmid)); ok {
+				r.HAState = state
+			}
+			resources = append(resources, r)
+		}
+		for _, ct := range n.Containers {
+			r := pve.Resource{
+				ID: "lxc/" + strconv.Itoa(ct.Vmid), Type: pve.ResourceLXC, Node: name, Vmid: ct.Vmid, Name: ct.Name, Status: ct.Status, Tags: ct.Tags,
+				MaxCPU: ct.Cpus, CPU: ct.CPU, Mem: ct.Mem, MaxMem: ct.MaxMem, Disk: ct.Disk, MaxDisk: ct.MaxDisk,
+				NetIn: ct.NetIn, NetOut: ct.NetOut, DiskRead: ct.DiskRead, DiskWrite: ct.DiskWrite, Uptime: ct.Uptime,
+			}
+			if offline {
+				r = pve.Resource{ID: r.ID, Type: r.Type, Node: name, Vmid: ct.Vmid, Name: ct.Name, Status: "unknown", Tags: ct.Tags, MaxCPU: ct.Cpus, MaxMem: ct.MaxMem, MaxDisk: ct.MaxDisk}
+			}
+			if _, state, ok := s.haService("ct:" + strconv.Itoa(ct.Vmid)); ok {
+				r.HAState = state
+			}
+			resources = append(resources, r)
+		}
+		for _, st := range n.Storage {
+			r := pve.Resource{
+				ID: "storage/" + name + "/" + st.Storage, Type: pve.ResourceStorage, Node: name, Storage: st.Storage, Status: "available",
+				PluginType: st.Type, Content: st.Content, Shared: st.Shared, Disk: st.Used, MaxDisk: st.Total,
+			}
+			if offline || st.Active != 1 {
+				r.Status, r.Disk, r.MaxDisk = "unknown", 0, 0
+			}
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// clusterStatus lists the cluster and its nodes like /cluster/status, or
+// only the first node for a standalone host. The caller holds s.mu.
+func (s *Server) clusterStatus() []pve.ClusterStatus {
+	c := s.fixtures.Cluster
+	if c == nil {
+		if len(s.fixtures.Nodes) == 0 {
+			return []pve.ClusterStatus{}
+		}
+		name := s.fixtures.Nodes[0].Node.Node
+		return []pve.ClusterStatus{{ID: "node/" + name, Type: "node", Name: name, Online: 1, Local: 1}}
+	}
+
+	var (
+		status      []pve.ClusterStatus
+		votes, seen int
+	)
+	for i, n := range c.Nodes {
+		votes += n.QuorumVotes
+		entry := pve.ClusterStatus{ID: "node/" + n.Node, Type: "node", Name: n.Node, NodeID: n.NodeID, Online: 1}
+		if len(n.Rings) > 0 {
+			entry.IP = n.Rings[0]
+		}
+		if i == 0 {
+			entry.Local = 1
+		}
+		if s.offline[n.Node] {
+			entry.Online = 0
+		} else {
+			seen += n.QuorumVotes
+		}
+		status = append(status, entry)
+	}
+	cluster := pve.ClusterStatus{ID: "cluster", Type: "cluster", Name: c.Name, Version: c.Version, Nodes: len(c.Nodes)}
+	if seen > votes/2 {
+		cluster.Quorate = 1
+	}
+	return append([]pve.ClusterStatus{cluster}, status...)
+}
+
+// haManagerStatus reports the HA manager like
+// /cluster/ha/status/manager_status. The caller holds s.mu.
+func (s *Server) haManagerStatus() pve.HAManagerStatus {
+	status := pve.HAManagerStatus{LRMStatus: make(map[string]pve.HALRMStatus)}
+	ha := s.fixtures.HA
+	if ha == nil {
+		for _, n := range s.fixtures.Nodes {
+			status.LRMStatus[n.Node.Node] = pve.HALRMStatus{Mode: "active", State: "wait_for_agent_lock", Timestamp: haTimestamp}
+		}
+		return status
+	}
+
+	status.ManagerStatus = pve.HAManager{
+		MasterNode:    ha.Master,
+		NodeStatus:    make(map[string]string),
+		ServiceStatus: make(map[string]pve.HAServiceStatus),
+		Timestamp:     haTimestamp,
+	}
+	busy := make(map[string]bool)
+	for _, r := range ha.Resources {
+		if node, state, ok := s.haService(r.SID); ok {
+			status.ManagerStatus.ServiceStatus[r.SID] = pve.HAServiceStatus{Node: node, State: state, UID: "fixture"}
+			busy[node] = true
+		}
+	}
+	for _, n := range s.fixtures.Nodes {
+		name := n.Node.Node
+		lrm := pve.HALRMStatus{Mode: "active", State: "wait_for_agent_lock", Timestamp: haTimestamp}
+		status.ManagerStatus.NodeStatus[name] = "online"
+		if s.offline[name] {
+			status.ManagerStatus.NodeStatus[name] = "unknown"
+			lrm.State = "lost_agent_lock"
+		} else if busy[name] {
+			lrm.State = "active"
+		}
+		status.LRMStatus[name] = lrm
+	}
+	return status
+}
+
+// haTimestamp is the time of every HA status the server reports.
+const haTimestamp = 1735689600
+
+// haService returns the node and the current state of an HA resource by its
+// service ID, e.g. vm:100. ok is false when the guest is not managed by HA.
+// The caller holds s.mu.
+func (s *Server) haService(sid string) (node, state string, ok bool) {
+	if s.fixtures.HA == nil {
+		return "", "", false
+	}
+	managed := false
+	for _, r := range s.fixtures.HA.Resources {
+		managed = managed || r.SID == sid
+	}
+	if !managed {
+		return "", "", false
+	}
+	kind, id, _ := strings.Cut(sid, ":")
+	vmid, _ := strconv.Atoi(id)
+	for _, n := range s.fixtures.Nodes {
+		status := ""
+		if vm := n.vm(vmid); kind == "vm" && vm != nil {
+			status = vm.Status
+		}
+		for _, ct := range n.Containers {
+			if kind == "ct" && ct.Vmid == vmid {
+				status = ct.Status
+			}
+		}
+		switch {
+		case status == "":
+			continue
+		case s.offline[n.Node.Node]:
+			return n.Node.Node, "fence", true
+		case status == "running":
+			return n.Node.Node, "started", true
+		default:
+			return n.Node.Node, "stopped", true
+		}
+	}
+	return "", "", false
+}
+
+func (s *Server) node(name string) *Node {
+	for i := range s.fixtures.Nodes {
+		if s.fixtures.Nodes[i].Node.Node == name {
+			return &s.fixtures.Nodes[i]
+		}
+	}
+	return nil
+}
+
+func (n *Node) vm(vmid int) *VM {
+	for i := range n.VMs {
+		if n.VMs[i].Vmid == vmid {
+			return &n.VMs[i]
+		}
+	}
+	return nil
+}
+
+// writeError answers like Proxmox - the message is in the status line and
+// in the body.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"data": nil, "message": msg + "\n"})
+}
+
+// orEmpty keeps empty lists from being encoded as null.
+func orEmpty[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
@@ -0,0 +1,69 @@
+package pve
+
+import (
+	"context"
+	"strconv"
+)
+
+// Storage is a storage as seen from a node. The sizes are bytes and are 0
+// for storage that is not active.
+type Storage struct {
+	Storage string `json:"storage"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Active  int    `json:"active"`
+	Enabled int    `json:"enabled"`
+	Shared  int    `json:"shared"`
+	Total   int    `json:"total"`
+	Used    int    `json:"used"`
+	Avail   int    `json:"avail"`
+}
+
+// Disk is a physical disk of a node. Wearout and RPM are numbers, or a
+// string such as "N/A" when Proxmox does not know them - see WearoutPercent
+// and Speed.
+type Disk struct {
+	DevPath string `json:"devpath"`
+	Vendor  string `json:"vendor"`
+	Model   string `json:"model"`
+	Serial  string `json:"serial"`
+	Type    string `json:"type"`
+	Health  string `json:"health"`
+	Used    string `json:"used"`
+	GPT     int    `json:"gpt"`
+	Size    int    `json:"size"`
+	Wearout any    `json:"wearout"`
+	RPM     any    `json:"rpm"`
+}
+
+// WearoutPercent returns the wearout of the disk, and false when Proxmox
+// reports none.
+func (d Disk) WearoutPercent() (int, bool) {
+	if v, ok := d.Wearout.(float64); ok {
+		return int(v), true
+	}
+	return 0, false
+}
+
+// Speed returns the rotation speed of the disk, and false when it is not
+// known or not a number.
+func (d Disk) Speed() (int, bool) {
+	switch v := d.RPM.(type) {
+	case float64:
+		return int(v), true
+	case string:
+		rpm, err := strconv.Atoi(v)
+		return rpm, err == nil
+	}
+	return 0, false
+}
+
+// Storage lists the storage available on a node.
+func (c *Client) Storage(ctx context.Context, node string) ([]Storage, error) {
+	return get[[]Storage](ctx, c, nodePath(node, "storage"))
+}
+
+// Disks lists the physical disks of a node.
+func (c *Client) Disks(ctx context.Context, node string) ([]Disk, error) {
+	return get[[]Disk](ctx, c, nodePath(node, "disks", "list"))
+}
@@ -0,0 +1,52 @@
+package pve
+
+import (
+	"context"
+	"net/url"
+)
+
+// Task is a task in the task list of a node. EndTime and Status are empty
+// while the task runs.
+type Task struct {
+	UPID      string `json:"upid"`
+	Node      string `json:"node"`
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	User      string `json:"user"`
+	StartTime int    `json:"starttime"`
+	EndTime   int    `json:"endtime"`
+	Status    string `json:"status"`
+}
+
+// TaskStatus is the state of a single task. Status is "running" or
+// "stopped", and ExitStatus is "OK" for tasks that succeeded.
+type TaskStatus struct {
+	UPID       string `json:"upid"`
+	Node       string `json:"node"`
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	User       string `json:"user"`
+	StartTime  int    `json:"starttime"`
+	Status     string `json:"status"`
+	ExitStatus string `json:"exitstatus"`
+}
+
+// Running reports whether the task has not finished yet.
+func (s TaskStatus) Running() bool {
+	return s.Status == "running"
+}
+
+// Succeeded reports whether the task finished without an error.
+func (s TaskStatus) Succeeded() bool {
+	return s.Status == "stopped" && s.ExitStatus == "OK"
+}
+
+// Tasks lists the recent tasks of a node.
+func (c *Client) Tasks(ctx context.Context, node string) ([]Task, error) {
+	return get[[]Task](ctx, c, nodePath(node, "tasks"))
+}
+
+// TaskStatus returns the state of a task by its UPID.
+func (c *Client) TaskStatus(ctx context.Context, node, upid string) (TaskStatus, error) {
+	return get[TaskStatus](ctx, c, nodePath(node, "tasks", url.PathEscape(upid), "status"))
+}
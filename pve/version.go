@@ -0,0 +1,16 @@
+package pve
+
+import "context"
+
+// Version is the version of Proxmox VE from /version. Any authenticated
+// user may read it, which makes it a cheap check of the credentials.
+type Version struct {
+	Version string `json:"version"`
+	Release string `json:"release"`
+	RepoID  string `json:"repoid"`
+}
+
+// Version returns the version of the node that answered.
+func (c *Client) Version(ctx context.Context) (Version, error) {
+	return get[Version](ctx, c, "/version")
+}
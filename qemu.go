@@ -2,116 +2,86 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
-	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve"
 )
 
 func vmSummary(c *gin.Context) {
-	parentObjects, err := convertJSON()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to convert the JSON data - %v", err)})
-		return
-	}
+	parentObjects := parentsFor(c)
+	fresh := wantsFresh(c)
 
 	var (
-		allVms []VmSummary
-		errors []ApiError
+		allVms  []VmSummary
+		errors  []ApiError
+		sources []ParentSource
 	)
 
-	ch := make(chan []VmSummary, len(parentObjects))
-	errCh := make(chan ApiError, len(parentObjects)*2)
-	var wg sync.WaitGroup
-	wg.Add(len(parentObjects))
+	// Every parent sends exactly one batch, whether it failed or not
+	type parentBatch struct {
+		vms    []VmSummary
+		errors []ApiError
+		source *ParentSource
+	}
+	ch := make(chan parentBatch, len(parentObjects))
 	for _, host := range parentObjects {
 		go func(host PVEConnectionObject) {
-			defer wg.Done()
-			var vmSlice []VmSummary
-			portOpen, err := testHostPort(host.Parent, host.Port)
-			if err != nil {
-				log.Printf("Failed to check if the port %d for %s is open - %v", host.Port, host.Parent, err)
+			var batch parentBatch
+			defer func() { ch <- batch }()
+
+			if inv := inventory.cached(fresh, host); inv != nil {
+				batch.vms = inv.Vms
+				batch.errors = append(append(batch.errors, inv.Errors...), inv.VmErrors...)
+				source := inv.source()
+				batch.source = &source
+				return
 			}
-			if portOpen {
-				parentNodes, err := getParentNodes(host.Parent, host.Port, host.Token)
-				if err != nil {
-					errCh <- ApiError{
-						Parent:  host.Parent,
-						Action:  "getParentNodes",
-						Message: err.Error(),
-					}
-					log.Printf("Failed to obtain the datacenter nodes for %s - %v", host.Parent, err)
-				}
-
-				for _, node := range parentNodes.Data {
-					if node.NodeStatus != "online" {
-						errCh <- ApiError{
-							Parent:  host.Parent,
-							Node:    node.Node,
-							Action:  "onlineStatus",
-							Message: fmt.Sprintf("The node %s appears to be offline according to Proxmox", node.Node),
-						}
-						log.Printf("Skipping node %s (offline)", node.Node)
-						continue
-					}
-
-					vmNodeUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%v/qemu", host.Parent, host.Port, node.Node)
-					req, err := http.NewRequest(http.MethodGet, vmNodeUrl, nil)
-					if err != nil {
-						errCh <- ApiError{
-							Parent:  host.Parent,
-							Node:    node.Node,
-							Action:  "createRequest",
-							Message: err.Error(),
-						}
-						log.Printf("Failed to create HTTP request for %v - %v", node.Node, err)
-						continue
-					}
-
-					var vmWrapper VmSummaryObject
-					if err := sendRequest(req, &vmWrapper, host.Token); err != nil {
-						errCh <- ApiError{
-							Parent:  host.Parent,
-							Node:    node.Node,
-							Action:  "sendRequest",
-							Message: err.Error(),
-						}
-						log.Printf("Failed to process the request for %s - error %v", vmNodeUrl, err)
-						continue
-					}
 
-					for i := range vmWrapper.Data {
-						vmWrapper.Data[i].Parent = host.Parent
-						vmWrapper.Data[i].Node = node.Node
-						vmWrapper.Data[i].NodeStatus = node.NodeStatus
-						vmWrapper.Data[i].MaxMemoryGb = vmWrapper.Data[i].MaxMemoryGb / 1024 / 1024
-						vmWrapper.Data[i].GuestMemoryGb = vmWrapper.Data[i].GuestMemoryGb / 1024 / 1024
-						vmWrapper.Data[i].UptimeHours = vmWrapper.Data[i].Uptime / 3600
+			ctx, cancel := parentContext(c.Request.Context(), host)
+			defer cancel()
 
-					}
-
-					vmSlice = append(vmSlice, vmWrapper.Data...)
-					ch <- vmSlice
+			client, err := clientFor(host)
+			if err != nil {
+				batch.errors = append(batch.errors, ApiError{
+					Parent:  host.Parent,
+					Action:  "tlsConfig",
+					Message: err.Error(),
+				})
+				slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+				return
+			}
+			portOpen, err := probeParent(ctx, host)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to reach the parent", "port", host.Port, "error", err)
+			}
+			if portOpen {
+				resources, parentErrors, ok := parentResources(ctx, client, host, nil, pve.ResourceQemu)
+				batch.errors = parentErrors.of(pve.ResourceQemu)
+				if !ok {
+					return
 				}
-
+				source := parentSource(host)
+				batch.source = &source
+				batch.vms = resourceVms(host, resources)
 			}
 		}(host)
 	}
 	for range parentObjects {
 		batch := <-ch
-		allVms = append(allVms, batch...)
-	}
-	wg.Wait()
-	close(errCh)
-	for e := range errCh {
-		errors = append(errors, e)
+		allVms = append(allVms, batch.vms...)
+		errors = append(errors, batch.errors...)
+		if batch.source != nil {
+			sources = append(sources, *batch.source)
+		}
 	}
 
 	c.JSON(http.StatusOK, VmSummaryResponse{
-		Data:   allVms,
-		Errors: errors,
+		Data:    allVms,
+		Errors:  requestErrors(c, errors),
+		Sources: sources,
 	})
 
 }
@@ -128,140 +98,159 @@ func vmDetailedOverview(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "The VM or Parent id is not added to the query."})
 		return
 	}
-	parentObjects, err := convertJSON()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to convert the JSON data - %v", err)})
+	if _, found := parentFor(c, parentName); !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("The parent entered (%s) is not present in the configuration - please adjust.", parentName)})
 		return
 	}
-	for _, host := range parentObjects {
+	for _, host := range parentsFor(c) {
 		if host.Parent == parentName {
-			portOpen, err := testHostPort(host.Parent, host.Port)
+			ctx, cancel := parentContext(c.Request.Context(), host)
+			defer cancel()
+
+			client, err := clientFor(host)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+				c.JSON(http.StatusInternalServerError, QemuGuestWrapper{
+					Data: result.Data,
+					Errors: requestErrors(c, append(errors, ApiError{
+						Parent:  host.Parent,
+						Action:  "tlsConfig",
+						Message: err.Error(),
+					})),
+				})
+				return
+			}
+			portOpen, err := probeParent(ctx, host)
 			if err != nil {
 				errors = append(errors, ApiError{
 					Parent:  host.Parent,
-					Action:  "testHostPort",
+					Action:  errorAction("testHostPort", err),
 					Message: err.Error(),
 				})
-				log.Printf("Failed to check if the port was open on %s:%d - %v", host.Parent, host.Port, err)
+				slog.WarnContext(ctx, "Failed to reach the parent", "port", host.Port, "error", err)
 				c.JSON(http.StatusBadGateway, QemuGuestWrapper{
 					Data:   result.Data,
-					Errors: errors,
+					Errors: requestErrors(c, errors),
 				})
 				return
 			}
 			if portOpen {
-				parentNodes, err := getParentNodes(host.Parent, host.Port, host.Token)
+				parentNodes, err := client.Nodes(ctx)
 				if err != nil {
 					errors = append(errors, ApiError{
 						Parent:  host.Parent,
-						Action:  "testHostPort",
+						Action:  errorAction("getNodes", err),
 						Message: err.Error(),
 					})
-					log.Printf("Failed to obtain the cluster nodes for %s - %v", host.Parent, err)
+					slog.WarnContext(ctx, "Failed to obtain the nodes of the parent", "error", err)
 					c.JSON(http.StatusInternalServerError, QemuGuestWrapper{
 						Data:   result.Data,
-						Errors: errors,
+						Errors: requestErrors(c, errors),
 					})
 					return
 				}
-				var vmObj GuestInfo
-				for _, node := range parentNodes.Data {
-					if node.NodeStatus != "online" {
-						log.Printf("Skipping node %s (offline)", node.Node)
+				var (
+					vmObj  pve.VM
+					vmNode string
+				)
+				for _, node := range parentNodes {
+					if node.Status != "online" {
+						slog.InfoContext(ctx, "Skipping an offline node", "node", node.Node)
 						continue
 					}
-					guestsResult, err := nodeGuestsOverview("qemu", host.Parent, host.Port, node.Node, host.Token)
+					vms, err := client.VMs(ctx, node.Node)
 					if err != nil {
 						errors = append(errors, ApiError{
 							Parent:  host.Parent,
 							Node:    node.Node,
-							Action:  "nodeGuestsOverview",
+							Action:  errorAction("nodeGuestsOverview", err),
 							Message: fmt.Sprintf("Failed to get the guests for %v - %v", host.Parent, err),
 						})
-						log.Printf("Failed to get the guests for %v - %v", host.Parent, err)
+						slog.WarnContext(ctx, "Failed to obtain the VMs of the node", "node", node.Node, "error", err)
 						continue
 					}
-					for _, guest := range guestsResult.Data {
-						if strconv.Itoa(guest.Vmid) == qemuId {
-							vmObj = guest
+					for _, vm := range vms {
+						if strconv.Itoa(vm.Vmid) == qemuId {
+							vmObj = vm
+							vmNode = node.Node
 						}
 					}
 				}
 
 				if vmObj.Vmid != 0 {
 					var qemuCombined QemuGuestInfo
-					qemuStatus, err := qemuCurrentStatus(vmObj.Vmid, host.Parent, host.Port, vmObj.Node, host.Token)
+					qemuStatus, err := client.VMStatus(ctx, vmNode, vmObj.Vmid)
 					if err == nil {
 						qemuCombined.Status = QemuGuestStatus{
 							Parent:         parentName,
-							Node:           vmObj.Node,
-							Name:           qemuStatus.Data.Name,
-							Status:         qemuStatus.Data.Status,
-							Agent:          qemuStatus.Data.Agent,
-							DiskreadMB:     qemuStatus.Data.Diskread / 1024 / 1024,
-							DiskwriteMB:    qemuStatus.Data.Diskwrite / 1024 / 1024,
-							NetoutMB:       qemuStatus.Data.Netout / 1024 / 1024,
-							NetinMB:        qemuStatus.Data.Netin / 1024 / 1024,
-							Cpus:           qemuStatus.Data.Cpus,
-							CpuLoad:        qemuStatus.Data.CpuLoad,
-							MemoryMB:       qemuStatus.Data.Memory / 1024 / 1024,
-							MaxMemoryMB:    qemuStatus.Data.Maxmemory / 1024 / 1024,
-							MachineVersion: qemuStatus.Data.MachineVersion,
+							Node:           vmNode,
+							Name:           qemuStatus.Name,
+							Status:         qemuStatus.Status,
+							Agent:          qemuStatus.Agent,
+							DiskreadMB:     qemuStatus.DiskRead / 1024 / 1024,
+							DiskwriteMB:    qemuStatus.DiskWrite / 1024 / 1024,
+							NetoutMB:       qemuStatus.NetOut / 1024 / 1024,
+							NetinMB:        qemuStatus.NetIn / 1024 / 1024,
+							Cpus:           qemuStatus.Cpus,
+							CpuLoad:        qemuStatus.CPU,
+							MemoryMB:       qemuStatus.Mem / 1024 / 1024,
+							MaxMemoryMB:    qemuStatus.MaxMem / 1024 / 1024,
+							MachineVersion: qemuStatus.RunningMachine,
 						}
 					} else {
-						log.Printf("Failed to obtain the status for the qemu %v - %v\n", vmObj.Vmid, err)
+						slog.WarnContext(ctx, "Failed to obtain the status of the VM", "node", vmNode, "vmid", vmObj.Vmid, "error", err)
 					}
 
-					if qemuStatus.Data.Agent == 1 && qemuStatus.Data.Status == "running" {
-						qemuHostName, err := qemuGuestHostName(vmObj.Vmid, host.Parent, host.Port, vmObj.Node, host.Token)
+					if qemuStatus.Agent == 1 && qemuStatus.Status == "running" {
+						qemuHostName, err := client.AgentHostName(ctx, vmNode, vmObj.Vmid)
 						if err != nil {
 							errors = append(errors, ApiError{
 								Parent:  host.Parent,
-								Node:    vmObj.Node,
-								Action:  "qemuGuestHostName",
+								Node:    vmNode,
+								Action:  errorAction("qemuGuestHostName", err),
 								Message: err.Error(),
 							})
-							log.Printf("Failed to obtain the hostname for qemu %v - %v", vmObj.Vmid, err)
+							slog.WarnContext(ctx, "Failed to obtain the hostname from the guest agent", "node", vmNode, "vmid", vmObj.Vmid, "error", err)
 						} else {
 							qemuCombined.Hostname = QemuHostNameInfo{
-								HostName: qemuHostName.Data.Result.Hostname,
+								HostName: qemuHostName,
 							}
 						}
 
-						qemuOsInfo, err := qemuGuestOsInfo(vmObj.Vmid, host.Parent, host.Port, vmObj.Node, host.Token)
+						qemuOsInfo, err := client.AgentOSInfo(ctx, vmNode, vmObj.Vmid)
 						if err != nil {
 							errors = append(errors, ApiError{
 								Parent:  host.Parent,
-								Node:    vmObj.Node,
-								Action:  "qemuGuestOsInfo",
+								Node:    vmNode,
+								Action:  errorAction("qemuGuestOsInfo", err),
 								Message: err.Error(),
 							})
-							log.Printf("Failed to obtain the OS info for qemu %v - %v\n", vmObj.Vmid, err)
+							slog.WarnContext(ctx, "Failed to obtain the OS info from the guest agent", "node", vmNode, "vmid", vmObj.Vmid, "error", err)
 						} else {
 							qemuCombined.OSInfo = QemuOSInfo{
-								MajorVersion:     qemuOsInfo.Data.Result.Version,
-								Name:             qemuOsInfo.Data.Result.Name,
-								MajorBuildNumber: qemuOsInfo.Data.Result.KernelVersion,
-								MinorBuildNumber: qemuOsInfo.Data.Result.KernelRelease,
+								MajorVersion:     qemuOsInfo.VersionID,
+								Name:             qemuOsInfo.PrettyName,
+								MajorBuildNumber: qemuOsInfo.KernelVersion,
+								MinorBuildNumber: qemuOsInfo.KernelRelease,
 							}
 						}
 
-						qemuIpInfo, err := qemuGuestIpInfo(vmObj.Vmid, host.Parent, host.Port, vmObj.Node, host.Token)
+						qemuIpInfo, err := client.AgentNetworkInterfaces(ctx, vmNode, vmObj.Vmid)
 						if err != nil {
 							errors = append(errors, ApiError{
 								Parent:  host.Parent,
-								Node:    vmObj.Node,
-								Action:  "qemuGuestIpInfo",
+								Node:    vmNode,
+								Action:  errorAction("qemuGuestIpInfo", err),
 								Message: err.Error(),
 							})
-							log.Printf("Failed to obtain the IP info for qemu %v - %v", vmObj.Vmid, err)
+							slog.WarnContext(ctx, "Failed to obtain the IP info from the guest agent", "node", vmNode, "vmid", vmObj.Vmid, "error", err)
 						} else {
-							qemuCombined.NetworkInfo = qemuIpInfo
+							qemuCombined.NetworkInfo = guestNetworkInfo(qemuIpInfo)
 						}
 					} else {
 						errors = append(errors, ApiError{
 							Parent:  host.Parent,
-							Node:    vmObj.Node,
+							Node:    vmNode,
 							Action:  "checkAgent",
 							Message: fmt.Sprintf("The VM %s is either not powered on or have an agent installed in the Guest OS - skipping guest inventory.", vmObj.Name),
 						})
@@ -275,87 +264,41 @@ func vmDetailedOverview(c *gin.Context) {
 			} else {
 				c.JSON(http.StatusBadGateway, QemuGuestWrapper{
 					Data: result.Data,
-					Errors: append(errors, ApiError{
+					Errors: requestErrors(c, append(errors, ApiError{
 						Parent:  host.Parent,
 						Action:  "testHostPort",
 						Message: fmt.Sprintf("port %d closed", host.Port),
-					}),
+					})),
 				})
 				return
 			}
 		}
 	}
 
-	result.Errors = errors
-	c.JSON(http.StatusOK, result)
-}
-
-func qemuCurrentStatus(qemuId int, parent string, port int, node string, apiToken string) (QemuCurrentStatusObject, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%v/qemu/%v/status/current", parent, port, node, qemuId)
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Failed to create HTTP request for qemuCurrentStatus - %v", err)
-		return QemuCurrentStatusObject{}, err
-	}
-
-	var qemuStatus QemuCurrentStatusObject
-	if err := sendRequest(req, &qemuStatus, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return QemuCurrentStatusObject{}, err
+	result.Errors = requestErrors(c, errors)
+	if host, ok := parentFor(c, parentName); ok {
+		result.Sources = []ParentSource{parentSource(host)}
 	}
-
-	return qemuStatus, nil
-
-}
-
-func qemuGuestHostName(qemuId int, parent string, port int, node string, apiToken string) (QemuHostNameObject, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%v/qemu/%v/agent/get-host-name", parent, port, node, qemuId)
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Failed to create HTTP request for qemuGuestHostName - %s - %v", customUrl, err)
-		return QemuHostNameObject{}, err
-	}
-
-	var qemuHostName QemuHostNameObject
-	if err := sendRequest(req, &qemuHostName, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return QemuHostNameObject{}, err
-	}
-	return qemuHostName, nil
-}
-
-func qemuGuestOsInfo(qemuId int, parent string, port int, node string, apiToken string) (QemuOSInfoObject, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%v/qemu/%v/agent/get-osinfo", parent, port, node, qemuId)
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Failed to create HTTP request for qemuGuestOsInfo - %s - %v", customUrl, err)
-		return QemuOSInfoObject{}, err
-	}
-
-	var qemuOsInfo QemuOSInfoObject
-	if err := sendRequest(req, &qemuOsInfo, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return QemuOSInfoObject{}, err
-	}
-
-	return qemuOsInfo, nil
-
+	c.JSON(http.StatusOK, result)
 }
 
-func qemuGuestIpInfo(qemuId int, parent string, port int, node string, apiToken string) ([]QemuGuestNetworkInfoObjectResult, error) {
-	customUrl := fmt.Sprintf("https://%s:%d/api2/json/nodes/%v/qemu/%v/agent/network-get-interfaces", parent, port, node, qemuId)
-	req, err := http.NewRequest(http.MethodGet, customUrl, nil)
-	if err != nil {
-		log.Printf("Failed to create HTTP request for qemuGuestIpInfo - %s - %v", customUrl, err)
-		return []QemuGuestNetworkInfoObjectResult{}, err
-	}
-
-	var qemuIpInfo QemuGuestNetworkInfoObject
-	if err := sendRequest(req, &qemuIpInfo, apiToken); err != nil {
-		log.Printf("Failed to process the request for %s - error %v", customUrl, err)
-		return []QemuGuestNetworkInfoObjectResult{}, err
+// guestNetworkInfo converts the interfaces reported by the guest agent for
+// the response.
+func guestNetworkInfo(interfaces []pve.NetworkInterface) []QemuGuestNetworkInfoObjectResult {
+	var result []QemuGuestNetworkInfoObjectResult
+	for _, iface := range interfaces {
+		info := QemuGuestNetworkInfoObjectResult{
+			Name:            iface.Name,
+			HardwareAddress: iface.HardwareAddress,
+		}
+		for _, ip := range iface.IPAddresses {
+			info.IPAddressList = append(info.IPAddressList, QemuGuestIPAddress{
+				IPAddress: ip.Address,
+				Prefix:    ip.Prefix,
+				Type:      ip.Type,
+			})
+		}
+		result = append(result, info)
 	}
-
-	return qemuIpInfo.Data.Result, nil
-
+	return result
 }
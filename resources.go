@@ -0,0 +1,403 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksl28/proxmox-simple-api/pve"
+)
+
+// resourceTypes are the values of ?type on /api/v1/inventory/resources. vm
+// selects both QEMU VMs and LXC containers, as in Proxmox.
+var resourceTypes = []string{pve.ResourceNode, pve.ResourceQemu, pve.ResourceLXC, pve.ResourceStorage, "vm"}
+
+// inventoryResources lists the nodes, guests and storage of every parent the
+// caller may read, optionally of one type.
+func inventoryResources(c *gin.Context) {
+	kind := c.Query("type")
+	if kind != "" && !slices.Contains(resourceTypes, kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown resource type %q - use one of %v", kind, resourceTypes)})
+		return
+	}
+
+	var (
+		results []ClusterResource
+		errors  []ApiError
+		sources []ParentSource
+	)
+	fresh := wantsFresh(c)
+	for _, host := range parentsFor(c) {
+		var (
+			resources  []pve.Resource
+			parentErrs []ApiError
+		)
+		if inv := inventory.cached(fresh, host); inv != nil {
+			resources = inv.Resources
+			parentErrs = append(append(parentErrs, inv.Errors...), inv.ResourceErrors...)
+			sources = append(sources, inv.source())
+		} else {
+			ctx, cancel := parentContext(c.Request.Context(), host)
+			var reached bool
+			resources, parentErrs, reached = liveResources(ctx, host)
+			cancel()
+			if reached {
+				sources = append(sources, parentSource(host))
+			}
+		}
+		errors = append(errors, parentErrs...)
+
+		for _, r := range resources {
+			if kind == "" || r.Type == kind || (kind == "vm" && (r.Type == pve.ResourceQemu || r.Type == pve.ResourceLXC)) {
+				results = append(results, clusterResource(host.Parent, r))
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, ClusterResourcesResponse{
+		Data:    results,
+		Errors:  requestErrors(c, errors),
+		Sources: sources,
+	})
+}
+
+// liveResources checks that a parent is reachable and lists its resources.
+// reached is false when the parent could not be queried at all.
+func liveResources(ctx context.Context, host PVEConnectionObject) (resources []pve.Resource, errors []ApiError, reached bool) {
+	client, err := clientFor(host)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to build the HTTP client", "error", err)
+		return nil, []ApiError{{Parent: host.Parent, Action: "tlsConfig", Message: err.Error()}}, false
+	}
+	portOpen, err := probeParent(ctx, host)
+	if !portOpen {
+		slog.WarnContext(ctx, "Failed to reach the parent", "port", host.Port, "error", err)
+		return nil, []ApiError{{
+			Parent:  host.Parent,
+			Action:  errorAction("testHostPort", err),
+			Message: fmt.Sprintf("The parent is not listening on %d - %v", host.Port, err),
+		}}, false
+	}
+	resources, resourceErrs, ok := parentResources(ctx, client, host, nil)
+	return resources, resourceErrs.of(), ok
+}
+
+func clusterResource(parent string, r pve.Resource) ClusterResource {
+	return ClusterResource{
+		Parent:     parent,
+		ID:         r.ID,
+		Type:       r.Type,
+		Node:       r.Node,
+		Status:     r.Status,
+		Name:       r.Name,
+		Vmid:       r.Vmid,
+		Tags:       r.Tags,
+		Template:   r.Template == 1,
+		HAState:    r.HAState,
+		Cpus:       r.MaxCPU,
+		Cpu:        r.CPU,
+		Mem:        r.Mem,
+		MaxMem:     r.MaxMem,
+		Disk:       r.Disk,
+		MaxDisk:    r.MaxDisk,
+		Uptime:     r.Uptime,
+		Storage:    r.Storage,
+		PluginType: r.PluginType,
+		Content:    r.Content,
+		Shared:     r.Shared == 1,
+	}
+}
+
+// parentResources lists the nodes, guests and storage of a parent with a
+// single call to /cluster/resources. When the parent answers that call with
+// an error status, the online nodes are walked instead - one call to /nodes,
+// three per node and one for the HA state of the guests. Offline nodes are reported as errors either way; only
+// /cluster/resources still lists their guests, with the status unknown.
+// nodes are the nodes of the parent when the caller already has them.
+// Without kinds, all guests and storage are listed, else only the given
+// types - the nodes are always listed. ok is false when the parent could not
+// be queried at all.
+func parentResources(ctx context.Context, client *pve.Client, host PVEConnectionObject, nodes []pve.Node, kinds ...string) (resources []pve.Resource, errors resourceErrors, ok bool) {
+	errors = make(resourceErrors)
+	all, err := client.Resources(ctx)
+	switch {
+	case err == nil:
+		for _, r := range all {
+			if r.Type == pve.ResourceNode && r.Status != "online" {
+				errors.add(pve.ResourceNode, offlineNodeError(ctx, host, r.Node))
+			}
+			if r.Type == pve.ResourceNode || wantsKind(kinds, r.Type) {
+				resources = append(resources, r)
+			}
+		}
+	case walkNodesInstead(err):
+		slog.DebugContext(ctx, "The parent does not list the cluster resources - walking the nodes", "error", err)
+		if nodes == nil {
+			nodes, err = client.Nodes(ctx)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to obtain the nodes of the parent", "error", err)
+				errors.add(pve.ResourceNode, ApiError{Parent: host.Parent, Action: errorAction("getParentNodes", err), Message: err.Error()})
+				return nil, errors, false
+			}
+		}
+		resources = walkResources(ctx, client, host, nodes, kinds, errors)
+	default:
+		slog.WarnContext(ctx, "Failed to obtain the cluster resources of the parent", "error", err)
+		errors.add(pve.ResourceNode, ApiError{Parent: host.Parent, Action: errorAction("getClusterResources", err), Message: err.Error()})
+		return nil, errors, false
+	}
+
+	slices.SortStableFunc(resources, func(a, b pve.Resource) int {
+		return cmp.Or(cmp.Compare(a.Type, b.Type), cmp.Compare(a.Node, b.Node), cmp.Compare(a.Vmid, b.Vmid), cmp.Compare(a.ID, b.ID))
+	})
+	return resources, errors, true
+}
+
+// walkNodesInstead reports whether the parent answered /cluster/resources
+// with an error status other than a rejected login, so the nodes can still
+// be walked.
+func walkNodesInstead(err error) bool {
+	var apiErr *pve.Error
+	return errors.As(err, &apiErr) && apiErr.Err == nil &&
+		apiErr.StatusCode >= http.StatusBadRequest && apiErr.StatusCode != http.StatusUnauthorized
+}
+
+// resourceErrors are the failures of listing the resources of a parent, by
+// resource type. Offline nodes, and a failure of the whole parent, are
+// reported under pve.ResourceNode.
+type resourceErrors map[string][]ApiError
+
+func (e resourceErrors) add(kind string, err ApiError) {
+	e[kind] = append(e[kind], err)
+}
+
+// of returns the errors of the nodes and of the given kinds, or all errors
+// without kinds.
+func (e resourceErrors) of(kinds ...string) []ApiError {
+	if len(kinds) == 0 {
+		kinds = []string{pve.ResourceQemu, pve.ResourceLXC, pve.ResourceStorage}
+	}
+	errors := slices.Clone(e[pve.ResourceNode])
+	for _, kind := range kinds {
+		errors = append(errors, e[kind]...)
+	}
+	return errors
+}
+
+func wantsKind(kinds []string, kind string) bool {
+	return len(kinds) == 0 || slices.Contains(kinds, kind)
+}
+
+// walkResources lists the resources of the given kinds of every online node
+// of a parent, in the shape of /cluster/resources. The failures are added to
+// errors.
+func walkResources(ctx context.Context, client *pve.Client, host PVEConnectionObject, nodes []pve.Node, kinds []string, errors resourceErrors) []pve.Resource {
+	var resources []pve.Resource
+	nodeError := func(kind, node, action string, err error) {
+		errors.add(kind, ApiError{
+			Parent:  host.Parent,
+			Node:    node,
+			Action:  errorAction(action, err),
+			Message: err.Error(),
+		})
+	}
+
+	for _, node := range nodes {
+		if !node.Online() {
+			resources = append(resources, pve.Resource{ID: "node/" + node.Node, Type: pve.ResourceNode, Node: node.Node, Status: node.Status})
+			errors.add(pve.ResourceNode, offlineNodeError(ctx, host, node.Node))
+			continue
+		}
+		resources = append(resources, pve.Resource{
+			ID: "node/" + node.Node, Type: pve.ResourceNode, Node: node.Node, Status: node.Status,
+			MaxCPU: node.MaxCPU, CPU: node.CPU, Mem: node.Mem, MaxMem: node.MaxMem,
+			Disk: node.Disk, MaxDisk: node.MaxDisk, Uptime: node.Uptime,
+		})
+
+		if wantsKind(kinds, pve.ResourceQemu) {
+			resources = append(resources, walkVms(ctx, client, node.Node, nodeError)...)
+		}
+		if wantsKind(kinds, pve.ResourceLXC) {
+			resources = append(resources, walkContainers(ctx, client, node.Node, nodeError)...)
+		}
+		if wantsKind(kinds, pve.ResourceStorage) {
+			resources = append(resources, walkStorage(ctx, client, node.Node, nodeError)...)
+		}
+	}
+	if wantsKind(kinds, pve.ResourceQemu) || wantsKind(kinds, pve.ResourceLXC) {
+		walkHAStates(ctx, client, resources)
+	}
+	return resources
+}
+
+// walkHAStates sets the HA state of the walked guests from the HA manager,
+// which /cluster/resources would have included. Without the manager status
+// the guests are listed as not managed by HA.
+func walkHAStates(ctx context.Context, client *pve.Client, resources []pve.Resource) {
+	status, err := client.HAManagerStatus(ctx)
+	if err != nil {
+		slog.DebugContext(ctx, "Failed to obtain the HA manager status - the HA state of the guests is unknown", "error", err)
+		return
+	}
+	for i, r := range resources {
+		sid := ""
+		switch r.Type {
+		case pve.ResourceQemu:
+			sid = fmt.Sprintf("vm:%d", r.Vmid)
+		case pve.ResourceLXC:
+			sid = fmt.Sprintf("ct:%d", r.Vmid)
+		}
+		if service, ok := status.ManagerStatus.ServiceStatus[sid]; ok {
+			resources[i].HAState = service.State
+		}
+	}
+}
+
+func walkVms(ctx context.Context, client *pve.Client, node string, nodeError func(kind, node, action string, err error)) []pve.Resource {
+	var resources []pve.Resource
+	vms, err := client.VMs(ctx, node)
+	if err != nil {
+		nodeError(pve.ResourceQemu, node, "sendRequest", err)
+		slog.WarnContext(ctx, "Failed to obtain the VMs of the node", "node", node, "error", err)
+	}
+	for _, vm := range vms {
+		resources = append(resources, pve.Resource{
+			ID: fmt.Sprintf("qemu/%d", vm.Vmid), Type: pve.ResourceQemu, Node: node, Status: vm.Status,
+			Name: vm.Name, Vmid: vm.Vmid, Tags: vm.Tags, MaxCPU: vm.Cpus, CPU: vm.CPU,
+			Mem: vm.Mem, MaxMem: vm.MaxMem, MaxDisk: vm.MaxDisk, NetIn: vm.NetIn, NetOut: vm.NetOut,
+			DiskRead: vm.DiskRead, DiskWrite: vm.DiskWrite, Uptime: vm.Uptime,
+		})
+	}
+	return resources
+}
+
+func walkContainers(ctx context.Context, client *pve.Client, node string, nodeError func(kind, node, action string, err error)) []pve.Resource {
+	var resources []pve.Resource
+	containers, err := client.Containers(ctx, node)
+	if err != nil {
+		nodeError(pve.ResourceLXC, node, "fetchLXC", err)
+		slog.WarnContext(ctx, "Failed to obtain the containers of the node", "node", node, "error", err)
+	}
+	for _, ct := range containers {
+		resources = append(resources, pve.Resource{
+			ID: fmt.Sprintf("lxc/%d", ct.Vmid), Type: pve.ResourceLXC, Node: node, Status: ct.Status,
+			Name: ct.Name, Vmid: ct.Vmid, Tags: ct.Tags, MaxCPU: ct.Cpus, CPU: ct.CPU,
+			Mem: ct.Mem, MaxMem: ct.MaxMem, Disk: ct.Disk, MaxDisk: ct.MaxDisk, NetIn: ct.NetIn, NetOut: ct.NetOut,
+			DiskRead: ct.DiskRead, DiskWrite: ct.DiskWrite, Uptime: ct.Uptime,
+		})
+	}
+	return resources
+}
+
+func walkStorage(ctx context.Context, client *pve.Client, node string, nodeError func(kind, node, action string, err error)) []pve.Resource {
+	var resources []pve.Resource
+	storage, err := client.Storage(ctx, node)
+	if err != nil {
+		nodeError(pve.ResourceStorage, node, "getNodeStorage", err)
+		slog.WarnContext(ctx, "Failed to obtain the storage of the node", "node", node, "error", err)
+	}
+	for _, st := range storage {
+		status := "available"
+		if st.Active != 1 {
+			status = "unknown"
+		}
+		resources = append(resources, pve.Resource{
+			ID: "storage/" + node + "/" + st.Storage, Type: pve.ResourceStorage, Node: node, Status: status,
+			Storage: st.Storage, PluginType: st.Type, Content: st.Content, Shared: st.Shared,
+			Disk: st.Used, MaxDisk: st.Total,
+		})
+	}
+	return resources
+}
+
+func offlineNodeError(ctx context.Context, host PVEConnectionObject, node string) ApiError {
+	slog.InfoContext(ctx, "The node is offline", "node", node)
+	return ApiError{
+		Parent:  host.Parent,
+		Node:    node,
+		Action:  "onlineStatus",
+		Message: fmt.Sprintf("The node %s appears to be offline according to Proxmox", node),
+	}
+}
+
+// nodeStatuses maps the nodes in resources to their status.
+func nodeStatuses(resources []pve.Resource) map[string]string {
+	statuses := make(map[string]string)
+	for _, r := range resources {
+		if r.Type == pve.ResourceNode {
+			statuses[r.Node] = r.Status
+		}
+	}
+	return statuses
+}
+
+// resourceVms returns the QEMU VMs in the resources of a parent.
+func resourceVms(host PVEConnectionObject, resources []pve.Resource) []VmSummary {
+	statuses := nodeStatuses(resources)
+	var vms []VmSummary
+	for _, r := range resources {
+		if r.Type != pve.ResourceQemu {
+			continue
+		}
+		vms = append(vms, VmSummary{
+			Parent:        host.Parent,
+			Node:          r.Node,
+			NodeStatus:    statuses[r.Node],
+			Name:          r.Name,
+			Vmid:          r.Vmid,
+			Status:        r.Status,
+			Cpus:          r.MaxCPU,
+			GuestMemoryGb: r.Mem / 1024 / 1024,
+			MaxMemoryGb:   r.MaxMem / 1024 / 1024,
+			Uptime:        r.Uptime,
+			UptimeHours:   r.Uptime / 3600,
+			HAManaged:     r.HAState != "",
+			HAState:       r.HAState,
+			tags:          r.Tags,
+			cpu:           r.CPU,
+			memBytes:      r.Mem,
+			maxMemBytes:   r.MaxMem,
+		})
+	}
+	return vms
+}
+
+// resourceLxc returns the LXC containers in the resources of a parent.
+func resourceLxc(host PVEConnectionObject, resources []pve.Resource) []LxcInfo {
+	statuses := nodeStatuses(resources)
+	var containers []LxcInfo
+	for _, r := range resources {
+		if r.Type != pve.ResourceLXC {
+			continue
+		}
+		containers = append(containers, LxcInfo{
+			Parent:        host.Parent,
+			Node:          r.Node,
+			NodeStatus:    statuses[r.Node],
+			DiskreadMb:    r.DiskRead / (1024 * 1024),
+			DiskwriteMb:   r.DiskWrite / (1024 * 1024),
+			MaxMemoryMb:   r.MaxMem / (1024 * 1024),
+			MemoryMb:      r.Mem / (1024 * 1024),
+			NetinMb:       r.NetIn / (1024 * 1024),
+			NetoutMb:      r.NetOut / (1024 * 1024),
+			Name:          r.Name,
+			UptimeHours:   r.Uptime / (60 * 60),
+			Tags:          r.Tags,
+			Status:        r.Status,
+			Vmid:          r.Vmid,
+			HAManaged:     r.HAState != "",
+			HAState:       r.HAState,
+			cpus:          r.MaxCPU,
+			cpu:           r.CPU,
+			uptimeSeconds: r.Uptime,
+			memBytes:      r.Mem,
+			maxMemBytes:   r.MaxMem,
+		})
+	}
+	return containers
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryBackoff    = 250 * time.Millisecond
+	maxRetryBackoff        = 5 * time.Second
+	defaultBreakerCooldown = 30 * time.Second
+
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "halfOpen"
+)
+
+// breakerOpenError is returned without calling the parent while its circuit
+// breaker is open.
+type breakerOpenError struct {
+	parent  string
+	retryAt time.Time
+}
+
+func (e *breakerOpenError) Error() string {
+	return fmt.Sprintf("the circuit breaker for %s is open after repeated failures - the next attempt is made at %s", e.parent, e.retryAt.Format(time.RFC3339))
+}
+
+// circuitBreaker fails calls to a parent fast once threshold calls in a row
+// have failed. After the cooldown a single call is let through to probe the
+// parent - if it succeeds the breaker closes again, otherwise it stays open
+// for another cooldown.
+type circuitBreaker struct {
+	parent    string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(host PVEConnectionObject) *circuitBreaker {
+	return &circuitBreaker{
+		parent:    host.Parent,
+		threshold: host.BreakerThreshold,
+		cooldown:  time.Duration(host.BreakerCooldown),
+		state:     breakerClosed,
+	}
+}
+
+// allow reports whether a call may be sent to the parent.
+func (b *circuitBreaker) allow() error {
+	if b.threshold == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return &breakerOpenError{parent: b.parent, retryAt: b.openedAt.Add(b.cooldown)}
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return &breakerOpenError{parent: b.parent, retryAt: time.Now().Add(b.cooldown)}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a call.
+func (b *circuitBreaker) record(failed bool) {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state != breakerOpen {
+			slog.Warn("Opened the circuit breaker", "parent", b.parent, "failures", b.failures)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// release gives up the probe of a half open breaker without an outcome, when
+// the caller went away before the parent answered.
+func (b *circuitBreaker) release() {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// BreakerStatus is the state of the circuit breaker of a parent.
+type BreakerStatus struct {
+	State    string     `json:"state"`
+	Failures int        `json:"consecutiveFailures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+	RetryAt  *time.Time `json:"retryAt,omitempty"`
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	if b.threshold == 0 {
+		return BreakerStatus{State: "disabled"}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state != breakerClosed {
+		openedAt, retryAt := b.openedAt, b.openedAt.Add(b.cooldown)
+		status.OpenedAt = &openedAt
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// retryTransport retries GET requests that failed with a network error, a
+// timeout or an unavailable status (see unavailableStatus), with jittered exponential backoff, and keeps the circuit
+// breaker of the parent up to date. A call that still failed after its
+// retries is counted once in pve_api_upstream_errors_total.
+type retryTransport struct {
+	base    http.RoundTripper
+	parent  string
+	retries int
+	backoff time.Duration
+	breaker *circuitBreaker
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		recordUpstreamError(t.parent, nil, err)
+		return nil, err
+	}
+
+	retries := t.retries
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		retries = 0
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := t.base.RoundTrip(req)
+		if !retryable(res, err) || attempt >= retries || req.Context().Err() != nil {
+			recordUpstreamError(t.parent, res, err)
+			if errors.Is(err, context.Canceled) {
+				// A caller that went away says nothing about the parent
+				t.breaker.release()
+			} else {
+				// Errors that are not worth retrying, e.g. a rejected
+				// certificate, still count against the parent. A plain 500
+				// is Proxmox reporting an error about the call, such as a
+				// stopped VM, and says nothing about the parent.
+				t.breaker.record(err != nil || unavailableStatus(res.StatusCode))
+			}
+			return res, err
+		}
+
+		if res != nil {
+			res.Body.Close()
+		}
+		select {
+		case <-time.After(backoffDelay(t.backoff, attempt)):
+		case <-req.Context().Done():
+			recordUpstreamError(t.parent, nil, req.Context().Err())
+			if errors.Is(req.Context().Err(), context.Canceled) {
+				t.breaker.release()
+			} else {
+				t.breaker.record(true)
+			}
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryable reports whether a call failed in a way that is worth retrying.
+// Authentication and certificate errors, and the plain 500 Proxmox answers
+// ordinary errors with, fail the same way on every attempt.
+func retryable(res *http.Response, err error) bool {
+	var authErr *authError
+	if err != nil {
+		return !errors.As(err, &authErr) && !isTLSError(err)
+	}
+	return unavailableStatus(res.StatusCode)
+}
+
+// backoffDelay doubles base for every attempt up to maxRetryBackoff, and
+// picks a random delay in the upper half so callers do not retry in step.
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	d := base << attempt
+	if d > maxRetryBackoff || d <= 0 {
+		d = maxRetryBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the
+// wrapped transport.
+func (t *retryTransport) CloseIdleConnections() {
+	if ci, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
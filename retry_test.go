@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ksl28/proxmox-simple-api/pve/pvetest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// roundTripFunc answers requests without a network, e.g. with an error.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// statusServer answers with the status in status and counts the requests.
+func statusServer(t *testing.T, status *atomic.Int32, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestRetryTransport(base http.RoundTripper, retries, threshold int, cooldown time.Duration) *retryTransport {
+	return &retryTransport{
+		base:    base,
+		parent:  "lab",
+		retries: retries,
+		backoff: time.Millisecond,
+		breaker: newCircuitBreaker(PVEConnectionObject{Parent: "lab", BreakerThreshold: threshold, BreakerCooldown: Duration(cooldown)}),
+	}
+}
+
+func send(t *testing.T, rt http.RoundTripper, ctx context.Context, method, url string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := rt.RoundTrip(req)
+	if res != nil {
+		res.Body.Close()
+	}
+	return res, err
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		for range 20 {
+			if d := backoffDelay(100*time.Millisecond, attempt); d < want/2 || d > want {
+				t.Fatalf("attempt %d: expected a delay between %s and %s, got %s", attempt, want/2, want, d)
+			}
+		}
+	}
+	if d := backoffDelay(time.Second, 10); d < maxRetryBackoff/2 || d > maxRetryBackoff {
+		t.Errorf("expected the delay to be capped at %s, got %s", maxRetryBackoff, d)
+	}
+	if d := backoffDelay(time.Second, 80); d > maxRetryBackoff {
+		t.Errorf("expected an overflowing shift to be capped, got %s", d)
+	}
+}
+
+func TestRetryLimit(t *testing.T) {
+	var status, hits atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	srv := statusServer(t, &status, &hits)
+	rt := newTestRetryTransport(http.DefaultTransport, 2, 0, 0)
+
+	res, err := send(t, rt, context.Background(), http.MethodGet, srv.URL)
+	if err != nil || res.StatusCode != http.StatusServiceUnavailable || hits.Load() != 3 {
+		t.Errorf("expected the 503 after 3 attempts, got %v %v after %d", res, err, hits.Load())
+	}
+
+	hits.Store(0)
+	if _, err := send(t, rt, context.Background(), http.MethodPost, srv.URL); err != nil || hits.Load() != 1 {
+		t.Errorf("expected a POST to be sent once, got %d attempts - %v", hits.Load(), err)
+	}
+
+	hits.Store(0)
+	status.Store(http.StatusNotFound)
+	if _, err := send(t, rt, context.Background(), http.MethodGet, srv.URL); err != nil || hits.Load() != 1 {
+		t.Errorf("expected a 404 not to be retried, got %d attempts - %v", hits.Load(), err)
+	}
+}
+
+func TestBreakerCountsErrorsThatAreNotRetried(t *testing.T) {
+	var calls int
+	rt := newTestRetryTransport(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		calls++
+		return nil, &authError{err: errors.New("authentication failure")}
+	}), 3, 2, time.Hour)
+
+	for range 2 {
+		if _, err := send(t, rt, context.Background(), http.MethodGet, "http://lab.invalid/"); err == nil {
+			t.Fatal("expected the authentication error")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected authentication errors not to be retried, got %d calls", calls)
+	}
+	_, err := send(t, rt, context.Background(), http.MethodGet, "http://lab.invalid/")
+	if !errors.As(err, new(*breakerOpenError)) || calls != 2 {
+		t.Errorf("expected the breaker to open after two failed calls, got %v after %d calls", err, calls)
+	}
+}
+
+func TestBreakerIgnoresProxmoxErrors(t *testing.T) {
+	srv, router := newFakeParent(t, func(cfg *Config) {
+		cfg.Parents[0].Retries = 2
+		cfg.Parents[0].RetryBackoff = Duration(time.Millisecond)
+		cfg.Parents[0].BreakerThreshold = 2
+		cfg.Parents[0].BreakerCooldown = Duration(time.Hour)
+	})
+	// The guest agent of the VM is down - Proxmox answers with a plain 500
+	srv.Inject("/nodes/*/qemu/100/agent/*", pvetest.Fault{Status: http.StatusInternalServerError, Message: "QEMU guest agent is not running"})
+
+	for range 3 {
+		var res QemuGuestWrapper
+		getJSON(t, router, "/api/v1/virtualization/vm/detailed/lab/100", http.StatusOK, &res)
+	}
+	if calls := srv.Requests("/nodes/pve1/qemu/100/agent/get-host-name"); calls != 3 {
+		t.Errorf("expected the agent call not to be retried, got %d calls", calls)
+	}
+
+	var status ParentStatusResponse
+	getJSON(t, router, "/api/v1/status/parents", http.StatusOK, &status)
+	if len(status.Data) != 1 || status.Data[0].Breaker.State != breakerClosed || status.Data[0].Breaker.Failures != 0 {
+		t.Errorf("expected the breaker to stay closed, got %+v", status.Data)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	var status, hits atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	srv := statusServer(t, &status, &hits)
+	cooldown := 50 * time.Millisecond
+	rt := newTestRetryTransport(http.DefaultTransport, 0, 2, cooldown)
+
+	for range 2 {
+		send(t, rt, context.Background(), http.MethodGet, srv.URL)
+	}
+	if _, err := send(t, rt, context.Background(), http.MethodGet, srv.URL); !errors.As(err, new(*breakerOpenError)) || hits.Load() != 2 {
+		t.Fatalf("expected the open breaker to fail fast, got %v after %d calls", err, hits.Load())
+	}
+
+	// After the cooldown a single probe is let through; while it is out,
+	// other calls still fail fast
+	time.Sleep(cooldown)
+	if err := rt.breaker.allow(); err != nil {
+		t.Fatalf("expected the probe to be allowed - %v", err)
+	}
+	if _, err := send(t, rt, context.Background(), http.MethodGet, srv.URL); !errors.As(err, new(*breakerOpenError)) {
+		t.Errorf("expected calls to fail fast while the probe is out, got %v", err)
+	}
+	rt.breaker.record(true)
+	if got := rt.breaker.status().State; got != breakerOpen {
+		t.Fatalf("expected a failed probe to open the breaker again, got %s", got)
+	}
+
+	time.Sleep(cooldown)
+	status.Store(http.StatusOK)
+	if res, err := send(t, rt, context.Background(), http.MethodGet, srv.URL); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the probe to reach the parent, got %v", err)
+	}
+	if got := rt.breaker.status(); got.State != breakerClosed || got.Failures != 0 {
+		t.Errorf("expected a successful probe to close the breaker, got %+v", got)
+	}
+}
+
+func TestCancelledProbeReleasesTheBreaker(t *testing.T) {
+	var status, hits atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	srv := statusServer(t, &status, &hits)
+	cooldown := 50 * time.Millisecond
+	rt := newTestRetryTransport(http.DefaultTransport, 0, 1, cooldown)
+	send(t, rt, context.Background(), http.MethodGet, srv.URL)
+	time.Sleep(cooldown)
+
+	// The probe of the caller that went away gives up its slot without an
+	// outcome, so the next call can probe instead of waiting another cooldown
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := send(t, rt, ctx, http.MethodGet, srv.URL); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the call to be cancelled, got %v", err)
+	}
+	if got := rt.breaker.status().State; got != breakerHalfOpen {
+		t.Errorf("expected the breaker to stay half open, got %s", got)
+	}
+	status.Store(http.StatusOK)
+	if res, err := send(t, rt, context.Background(), http.MethodGet, srv.URL); err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("expected the next call to probe the parent, got %v", err)
+	}
+}
+
+func TestUpstreamErrorsAreCountedOncePerCall(t *testing.T) {
+	var status, hits atomic.Int32
+	srv := statusServer(t, &status, &hits)
+	rt := newTestRetryTransport(http.DefaultTransport, 2, 0, 0)
+	rt.parent = "count-lab"
+	counted := func(reason string) float64 {
+		return testutil.ToFloat64(upstreamErrors.WithLabelValues("count-lab", reason))
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	send(t, rt, context.Background(), http.MethodGet, srv.URL)
+	if hits.Load() != 3 || counted("status") != 1 {
+		t.Errorf("expected one error for three attempts, got %v after %d", counted("status"), hits.Load())
+	}
+
+	// Expected answers such as a 404 are not upstream errors
+	status.Store(http.StatusNotFound)
+	send(t, rt, context.Background(), http.MethodGet, srv.URL)
+	if counted("status") != 1 {
+		t.Errorf("expected a 404 not to be counted, got %v", counted("status"))
+	}
+
+	status.Store(http.StatusForbidden)
+	send(t, rt, context.Background(), http.MethodGet, srv.URL)
+	if counted("authenticate") != 1 {
+		t.Errorf("expected a 403 to be counted as authenticate, got %v", counted("authenticate"))
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const apiTokenPrefix = "PVEAPIToken="
+
+// cachedSecret is the last value read from a secret file, together with the
+// file stamp it was read at.
+type cachedSecret struct {
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+var (
+	secretCacheMu sync.Mutex
+	secretCache   = make(map[string]cachedSecret)
+)
+
+// readSecretFile returns the trimmed content of path. The file is only read
+// again when its modification time or size changes, so rotated Kubernetes
+// secrets are picked up on the next request.
+func readSecretFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the secret file %s - %w", path, err)
+	}
+
+	secretCacheMu.Lock()
+	defer secretCacheMu.Unlock()
+	if cached, ok := secretCache[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.value, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the secret file %s - %w", path, err)
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "" {
+		return "", fmt.Errorf("the secret file %s is empty", path)
+	}
+	secretCache[path] = cachedSecret{modTime: info.ModTime(), size: info.Size(), value: value}
+	return value, nil
+}
+
+// countSet returns how many of values are not empty.
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// tokenSources counts how many token sources are set directly on the parent.
+func (p PVEConnectionObject) tokenSources() int {
+	return countSet(p.Token, p.TokenFile, p.TokenEnv)
+}
+
+// passwordSources counts how many password sources are set on the parent.
+func (p PVEConnectionObject) passwordSources() int {
+	return countSet(p.Password, p.PasswordFile, p.PasswordEnv)
+}
+
+// totpSources counts how many TOTP secret sources are set on the parent.
+func (p PVEConnectionObject) totpSources() int {
+	return countSet(p.TotpSecret, p.TotpSecretFile, p.TotpSecretEnv)
+}
+
+// resolveSecret returns a secret from the first of its sources that is set:
+// the inline value, a file or an environment variable. what describes the
+// secret in error messages and must never be the secret itself.
+// The value is remembered, so redactSecrets masks it whatever its format.
+func resolveSecret(what, inline, file, env string) (string, error) {
+	var (
+		value string
+		err   error
+	)
+	switch {
+	case inline != "":
+		value = inline
+	case file != "":
+		value, err = readSecretFile(file)
+	case env != "":
+		v, ok := os.LookupEnv(env)
+		if !ok || strings.TrimSpace(v) == "" {
+			return "", fmt.Errorf("the environment variable %s holding the %s is not set", env, what)
+		}
+		value = strings.TrimSpace(v)
+	default:
+		return "", fmt.Errorf("no %s is configured", what)
+	}
+	if err != nil {
+		return "", err
+	}
+	rememberSecret(value)
+	return value, nil
+}
+
+// resolveToken returns the Authorization header value for the parent. A
+// tokenDir from the config is turned into a TokenFile when the config is
+// loaded, so only the parent itself is needed here.
+func resolveToken(host PVEConnectionObject) (string, error) {
+	token, err := resolveSecret("token for "+host.Parent, host.Token, host.TokenFile, host.TokenEnv)
+	if err != nil {
+		return "", err
+	}
+
+	// Secrets are often stored without the header prefix
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		token = apiTokenPrefix + token
+	}
+	return token, nil
+}
+
+// minSecretLength is the length below which resolved secrets are not
+// redacted by value, so short passwords do not mask common words.
+const minSecretLength = 6
+
+var (
+	apiTokenPattern = regexp.MustCompile(`PVEAPIToken=\S+`)
+	// USER@REALM!TOKENID=SECRET, as token files often hold it
+	tokenIDPattern = regexp.MustCompile(`([\w.-]+@[\w.-]+![\w.-]+)=[^\s"',]+`)
+	ticketPattern  = regexp.MustCompile(`PVE:[^\s"]+::\S+`)
+
+	// secretValues are the secrets resolved so far. Rotated secrets stay in
+	// the set, so older log lines and fixtures cannot leak them either.
+	secretValuesMu sync.Mutex
+	secretValues   = make(map[string]bool)
+)
+
+// rememberSecret registers a resolved secret for redactSecrets. For an API
+// token the secret after the token ID is registered on its own as well.
+func rememberSecret(value string) {
+	value = strings.TrimPrefix(value, apiTokenPrefix)
+	candidates := []string{value}
+	if _, secret, ok := strings.Cut(value, "="); ok {
+		candidates = append(candidates, secret)
+	}
+	secretValuesMu.Lock()
+	defer secretValuesMu.Unlock()
+	for _, v := range candidates {
+		if len(v) >= minSecretLength {
+			secretValues[v] = true
+		}
+	}
+}
+
+// redactSecrets masks anything that looks like an API token or ticket in s,
+// and every secret resolved so far. It is used on messages that may quote
+// config content, such as parser errors, and on recorded fixtures.
+func redactSecrets(s string) string {
+	s = apiTokenPattern.ReplaceAllString(s, apiTokenPrefix+"<redacted>")
+	s = tokenIDPattern.ReplaceAllString(s, "$1=<redacted>")
+	s = ticketPattern.ReplaceAllString(s, "PVE:<redacted>")
+
+	secretValuesMu.Lock()
+	// Longer values first, so a token is masked whole before its secret
+	values := slices.SortedFunc(maps.Keys(secretValues), func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	secretValuesMu.Unlock()
+	for _, v := range values {
+		s = strings.ReplaceAll(s, v, "<redacted>")
+	}
+	return s
+}
+
+// String keeps the credentials out of log lines that print the whole object.
+func (p PVEConnectionObject) String() string {
+	return fmt.Sprintf("%s:%d", p.Parent, p.Port)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveToken(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "lab")
+	if err := os.WriteFile(file, []byte("monitor@pve!file=11111111-2222\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LAB_TOKEN", " PVEAPIToken=monitor@pve!env=33333333-4444 ")
+
+	cfg := Config{TokenDir: dir, Parents: []PVEConnectionObject{{Parent: "lab"}}}
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		host PVEConnectionObject
+		want string
+	}{
+		{"inline", PVEConnectionObject{Parent: "lab", Token: "monitor@pve!inline=5555"}, "PVEAPIToken=monitor@pve!inline=5555"},
+		{"file", PVEConnectionObject{Parent: "lab", TokenFile: file}, "PVEAPIToken=monitor@pve!file=11111111-2222"},
+		{"env", PVEConnectionObject{Parent: "lab", TokenEnv: "LAB_TOKEN"}, "PVEAPIToken=monitor@pve!env=33333333-4444"},
+		{"tokenDir", cfg.Parents[0], "PVEAPIToken=monitor@pve!file=11111111-2222"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveToken(tt.host)
+			if err != nil || got != tt.want {
+				t.Errorf("expected %q, got %q - %v", tt.want, got, err)
+			}
+		})
+	}
+
+	if _, err := resolveToken(PVEConnectionObject{Parent: "lab", TokenEnv: "MISSING_TOKEN"}); err == nil || !strings.Contains(err.Error(), "MISSING_TOKEN") {
+		t.Errorf("expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestSecretFileIsCachedByStamp(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("first-secret", stamp)
+	if got, _ := readSecretFile(file); got != "first-secret" {
+		t.Fatalf("expected the file content, got %q", got)
+	}
+	// Same size and time - the cached value is kept
+	write("other-secret", stamp)
+	if got, _ := readSecretFile(file); got != "first-secret" {
+		t.Errorf("expected the cached value, got %q", got)
+	}
+	write("other-secret", stamp.Add(time.Minute))
+	if got, _ := readSecretFile(file); got != "other-secret" {
+		t.Errorf("expected the rotated value, got %q", got)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	// A bare secret without the token ID matches none of the patterns
+	if err := os.WriteFile(file, []byte("9f8e7d6c-bare-secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveToken(PVEConnectionObject{Parent: "lab", TokenFile: file}); err != nil {
+		t.Fatal(err)
+	}
+
+	for in, want := range map[string]string{
+		"Authorization: PVEAPIToken=monitor@pve!api=abc":    "Authorization: PVEAPIToken=<redacted>",
+		`token: "monitor@pve!api=0a1b2c3d"`:                 `token: "monitor@pve!api=<redacted>"`,
+		"sent 9f8e7d6c-bare-secret to the parent":           "sent <redacted> to the parent",
+		"cookie PVE:monitor@pve:6789ABCD::c2lnbmF0dXJl end": "cookie PVE:<redacted> end",
+	} {
+		if got := redactSecrets(in); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 2 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
+// ServerTimeouts bound the connections of the listener. WriteTimeout bounds
+// a whole request, so it should be longer than the timeout of the slowest
+// parent. ShutdownTimeout is how long the requests in flight may finish
+// after SIGTERM or SIGINT.
+type ServerTimeouts struct {
+	ReadHeader Duration `json:"readHeader" yaml:"readHeader"`
+	Read       Duration `json:"read" yaml:"read"`
+	Write      Duration `json:"write" yaml:"write"`
+	Idle       Duration `json:"idle" yaml:"idle"`
+	Shutdown   Duration `json:"shutdown" yaml:"shutdown"`
+}
+
+func (t *ServerTimeouts) applyDefaults() {
+	if t.ReadHeader == 0 {
+		t.ReadHeader = Duration(defaultReadHeaderTimeout)
+	}
+	if t.Read == 0 {
+		t.Read = Duration(defaultReadTimeout)
+	}
+	if t.Write == 0 {
+		t.Write = Duration(defaultWriteTimeout)
+	}
+	if t.Idle == 0 {
+		t.Idle = Duration(defaultIdleTimeout)
+	}
+	if t.Shutdown == 0 {
+		t.Shutdown = Duration(defaultShutdownTimeout)
+	}
+}
+
+func (t *ServerTimeouts) validate() error {
+	if t.ReadHeader < 0 || t.Read < 0 || t.Write < 0 || t.Idle < 0 || t.Shutdown < 0 {
+		return fmt.Errorf("the listener timeouts must not be negative")
+	}
+	return nil
+}
+
+// serve runs the API on ln until ctx is done. It then stops accepting
+// connections and waits up to the shutdown timeout for the requests in
+// flight, before the remaining connections are closed.
+func serve(ctx context.Context, ln net.Listener, cfg *Config, handler http.Handler) error {
+	timeouts := cfg.Listen.Timeouts
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(timeouts.ReadHeader),
+		ReadTimeout:       time.Duration(timeouts.Read),
+		WriteTimeout:      time.Duration(timeouts.Write),
+		IdleTimeout:       time.Duration(timeouts.Idle),
+	}
+
+	errc := make(chan error, 1)
+	if cfg.Listen.TLS.enabled() {
+		tlsConfig, err := listenerTLSConfig(ctx, cfg.Listen.TLS)
+		if err != nil {
+			return fmt.Errorf("failed to set up TLS for the listener - %w", err)
+		}
+		server.TLSConfig = tlsConfig
+		slog.Info("Listening and serving HTTPS", "address", ln.Addr().String())
+		go func() { errc <- server.ServeTLS(ln, "", "") }()
+	} else {
+		slog.Info("Listening and serving HTTP", "address", ln.Addr().String())
+		go func() { errc <- server.Serve(ln) }()
+	}
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down - waiting for the requests in flight", "timeout", time.Duration(timeouts.Shutdown).String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(timeouts.Shutdown))
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("not all requests finished in time - %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -3,39 +3,86 @@ package main
 import "time"
 
 type PVEConnectionObject struct {
-	Parent string `json:"Parent"`
-	Token  string `json:"Token"`
-	Port   int    `json:"Port"`
-}
-
-type ClusterNodesObject struct {
-	Data []ClusterNodeEntry `json:"data"`
-}
-
-type ClusterNodeEntry struct {
-	Quorum string `json:"quorum_votes"`
-	Name   string `json:"name"`
-	Ip     string `json:"ring0_addr"`
-}
-
-type NodeGuestOverview struct {
-	Data []GuestInfo `json:"data"`
-}
-
-type GuestInfo struct {
-	Parent string `json:"parent"`
-	Node   string `json:"node"`
-	Name   string `json:"name"`
-	Vmid   int    `json:"vmid"`
-}
-
-type VmSummaryObject struct {
-	Data []VmSummary `json:"data"`
+	Parent    string `json:"Parent" yaml:"parent"`
+	Token     string `json:"Token" yaml:"token"`
+	TokenFile string `json:"TokenFile" yaml:"tokenFile"`
+	TokenEnv  string `json:"TokenEnv" yaml:"tokenEnv"`
+	Port      int    `json:"Port" yaml:"port"`
+	// Username switches the parent to ticket authentication through
+	// /access/ticket, for realms where API tokens are not allowed.
+	Username       string `json:"Username" yaml:"username"`
+	Password       string `json:"Password" yaml:"password"`
+	PasswordFile   string `json:"PasswordFile" yaml:"passwordFile"`
+	PasswordEnv    string `json:"PasswordEnv" yaml:"passwordEnv"`
+	TotpSecret     string `json:"TotpSecret" yaml:"totpSecret"`
+	TotpSecretFile string `json:"TotpSecretFile" yaml:"totpSecretFile"`
+	TotpSecretEnv  string `json:"TotpSecretEnv" yaml:"totpSecretEnv"`
+	// Endpoints are the nodes that can answer for the parent, as host or
+	// host:port. EndpointOrder is either "health" or "ordered".
+	Endpoints     []string `json:"Endpoints" yaml:"endpoints"`
+	EndpointOrder string   `json:"EndpointOrder" yaml:"endpointOrder"`
+	// CAFile, Fingerprint and Insecure control how the certificate of the
+	// parent is verified - by default the system roots are used.
+	CAFile      string `json:"CAFile" yaml:"caFile"`
+	Fingerprint string `json:"Fingerprint" yaml:"fingerprint"`
+	Insecure    bool   `json:"Insecure" yaml:"insecure"`
+	// Timeout bounds all calls made to the parent for one request.
+	Timeout Duration `json:"Timeout" yaml:"timeout"`
+	// Retries is how often a failed GET is retried, starting RetryBackoff
+	// after the first try. BreakerThreshold failed calls in a row open the
+	// circuit breaker for BreakerCooldown. Zero disables either.
+	Retries          int      `json:"Retries" yaml:"retries"`
+	RetryBackoff     Duration `json:"RetryBackoff" yaml:"retryBackoff"`
+	BreakerThreshold int      `json:"BreakerThreshold" yaml:"breakerThreshold"`
+	BreakerCooldown  Duration `json:"BreakerCooldown" yaml:"breakerCooldown"`
+	// MaxConcurrent bounds the requests in flight to the parent. RateLimit
+	// is the number of requests per second, with bursts of RateBurst - zero
+	// leaves the rate unlimited.
+	MaxConcurrent int     `json:"MaxConcurrent" yaml:"maxConcurrent"`
+	RateLimit     float64 `json:"RateLimit" yaml:"rateLimit"`
+	RateBurst     int     `json:"RateBurst" yaml:"rateBurst"`
+	// fixtures is copied from the config, so the client of the parent is
+	// rebuilt when recording or replaying is switched
+	fixtures FixturesConfig
+}
+
+type ClustersResponse struct {
+	Data    []ClusterInfo  `json:"data"`
+	Errors  []ApiError     `json:"errors"`
+	Sources []ParentSource `json:"sources"`
+}
+
+// ClusterInfo is the corosync cluster of a parent. A standalone parent has
+// no name and no votes and is always quorate. Quorum is the number of votes
+// needed for the cluster to be quorate.
+type ClusterInfo struct {
+	Parent        string            `json:"parent"`
+	Standalone    bool              `json:"standalone"`
+	Name          string            `json:"name"`
+	Quorate       bool              `json:"quorate"`
+	ConfigVersion int               `json:"configVersion"`
+	ExpectedVotes int               `json:"expectedVotes"`
+	TotalVotes    int               `json:"totalVotes"`
+	Quorum        int               `json:"quorum"`
+	Nodes         []ClusterNodeInfo `json:"nodes"`
+}
+
+// ClusterNodeInfo is a node of a cluster. Local is the node that answered
+// for the parent. RingAddresses are the corosync addresses, in ring order.
+type ClusterNodeInfo struct {
+	Name          string   `json:"name"`
+	NodeID        int      `json:"nodeId"`
+	Online        bool     `json:"online"`
+	Local         bool     `json:"local"`
+	IP            string   `json:"ip"`
+	QuorumVotes   int      `json:"quorumVotes"`
+	RingAddresses []string `json:"ringAddresses"`
 }
 
 type VmSummaryResponse struct {
-	Data   []VmSummary `json:"data"`
-	Errors []ApiError  `json:"errors"`
+	Data    []VmSummary    `json:"data"`
+	Errors  []ApiError     `json:"errors"`
+	Sources []ParentSource `json:"sources"`
 }
 
 type VmSummary struct {
@@ -50,11 +97,22 @@ type VmSummary struct {
 	MaxMemoryGb   int    `json:"maxMem"`
 	Uptime        int    `json:"uptime"`
 	UptimeHours   int    `json:"uptimeHours"`
+	// HAState is the current state of the VM in the HA manager, empty when
+	// HA does not manage it
+	HAManaged bool   `json:"haManaged"`
+	HAState   string `json:"haState"`
+	// Only exported as metrics: the tags, the CPU usage and the memory in
+	// bytes, before the conversion for the response
+	tags        string
+	cpu         float64
+	memBytes    int
+	maxMemBytes int
 }
 
 type NodeDetailsResponse struct {
-	Data   []NodeDetails `json:"data"`
-	Errors []ApiError    `json:"errors"`
+	Data    []NodeDetails  `json:"data"`
+	Errors  []ApiError     `json:"errors"`
+	Sources []ParentSource `json:"sources"`
 }
 
 type NodeDetails struct {
@@ -91,92 +149,10 @@ type NodeDetails struct {
 	} `json:"time"`
 }
 
-type PVENodesObject struct {
-	Data []PVENodeInfo `json:"data"`
-}
-
-type PVENodeInfo struct {
-	Parent      string  `json:"parent"`
-	Node        string  `json:"node"`
-	NodeStatus  string  `json:"status"`
-	MaxCPU      int     `json:"maxCpu"`
-	UptimeHours int     `json:"uptime"`
-	Mem         int     `json:"mem"`
-	MaxMem      int     `json:"maxMem"`
-	Cpu         float64 `json:"cpu"`
-	Maxdisk     int     `json:"maxDisk"`
-	Disk        int     `json:"disk"`
-}
-
-type NodeDnsObject struct {
-	Data struct {
-		Search string `json:"search"`
-		Dns1   string `json:"dns1"`
-		Dns2   string `json:"dns2"`
-		Dns3   string `json:"dns3"`
-	} `json:"data"`
-}
-
-type NodeTimeObject struct {
-	Data struct {
-		Time     int    `json:"time"`
-		Timezone string `json:"timezone"`
-	} `json:"data"`
-}
-
-type NodeStatusObject struct {
-	Data struct {
-		CurrentKernel struct {
-			Version string `json:"version"`
-			Sysname string `json:"sysname"`
-			Release string `json:"release"`
-			Machine string `json:"machine"`
-		} `json:"current-kernel"`
-		Memory struct {
-			Free  int `json:"free"`
-			Used  int `json:"used"`
-			Total int `json:"total"`
-		} `json:"memory"`
-		BootInfo struct {
-			Secureboot int    `json:"secureboot"`
-			Mode       string `json:"mode"`
-		} `json:"boot-info"`
-		Cpuinfo struct {
-			Cpus    int    `json:"cpus"`
-			Flags   string `json:"flags"`
-			Hvm     string `json:"hvm"`
-			Mhz     string `json:"mhz"`
-			Model   string `json:"model"`
-			UserHz  int    `json:"user_hz"`
-			Sockets int    `json:"sockets"`
-			Cores   int    `json:"cores"`
-		} `json:"cpuinfo"`
-		Pveversion string `json:"pveversion"`
-		Swap       struct {
-			Used  int `json:"used"`
-			Free  int `json:"free"`
-			Total int `json:"total"`
-		} `json:"swap"`
-		Loadavg []string `json:"loadavg"`
-		Ksm     struct {
-			Shared int `json:"shared"`
-		} `json:"ksm"`
-		Wait   float64 `json:"wait"`
-		Uptime int     `json:"uptime"`
-		Rootfs struct {
-			Free  int `json:"free"`
-			Used  int `json:"used"`
-			Avail int `json:"avail"`
-			Total int `json:"total"`
-		} `json:"rootfs"`
-		Cpu  float64 `json:"cpu"`
-		Idle int     `json:"idle"`
-	} `json:"data"`
-}
-
 type NodeSummaryResponse struct {
-	Data   []nodeSummaryWrapper `json:"data"`
-	Errors []ApiError           `json:"errors"`
+	Data    []nodeSummaryWrapper `json:"data"`
+	Errors  []ApiError           `json:"errors"`
+	Sources []ParentSource       `json:"sources"`
 }
 
 type nodeSummaryWrapper struct {
@@ -193,8 +169,9 @@ type nodeSummaryWrapper struct {
 }
 
 type QemuGuestWrapper struct {
-	Data   QemuGuestInfo `json:"data"`
-	Errors []ApiError    `json:"errors"`
+	Data    QemuGuestInfo  `json:"data"`
+	Errors  []ApiError     `json:"errors"`
+	Sources []ParentSource `json:"sources"`
 }
 
 type QemuGuestInfo struct {
@@ -221,46 +198,10 @@ type QemuGuestStatus struct {
 	MachineVersion string  `json:"running-machine"`
 }
 
-type QemuCurrentStatusObject struct {
-	Data struct {
-		Name           string  `json:"name"`
-		Status         string  `json:"status"`
-		Agent          int     `json:"agent"`
-		Diskread       int     `json:"diskread"`
-		Diskwrite      int     `json:"diskwrite"`
-		Netout         int     `json:"netout"`
-		Netin          int     `json:"netin"`
-		Cpus           int     `json:"cpus"`
-		CpuLoad        float64 `json:"cpu"`
-		Memory         int     `json:"mem"`
-		Maxmemory      int     `json:"maxmem"`
-		MachineVersion string  `json:"running-machine"`
-	} `json:"data"`
-}
-
-type QemuHostNameObject struct {
-	Data struct {
-		Result struct {
-			Hostname string `json:"host-name"`
-		} `json:"result"`
-	} `json:"data"`
-}
-
 type QemuHostNameInfo struct {
 	HostName string `json:"hostname"`
 }
 
-type QemuOSInfoObject struct {
-	Data struct {
-		Result struct {
-			Version       string `json:"version-id"`
-			Name          string `json:"pretty-name"`
-			KernelVersion string `json:"kernel-version"`
-			KernelRelease string `json:"kernel-release"`
-		} `json:"result"`
-	} `json:"data"`
-}
-
 type QemuOSInfo struct {
 	MajorVersion     string `json:"Version"`
 	Name             string `json:"Name"`
@@ -268,25 +209,22 @@ type QemuOSInfo struct {
 	MinorBuildNumber string `json:"KernelRelease"`
 }
 
-type QemuGuestNetworkInfoObject struct {
-	Data struct {
-		Result []QemuGuestNetworkInfoObjectResult `json:"result"`
-	} `json:"data"`
+type QemuGuestNetworkInfoObjectResult struct {
+	Name            string               `json:"name"`
+	IPAddressList   []QemuGuestIPAddress `json:"ip-addresses"`
+	HardwareAddress string               `json:"hardware-address"`
 }
 
-type QemuGuestNetworkInfoObjectResult struct {
-	Name          string `json:"name"`
-	IPAddressList []struct {
-		IPAddress string `json:"ip-address"`
-		Prefix    int    `json:"prefix"`
-		Type      string `json:"ip-address-type"`
-	} `json:"ip-addresses"`
-	HardwareAddress string `json:"hardware-address"`
+type QemuGuestIPAddress struct {
+	IPAddress string `json:"ip-address"`
+	Prefix    int    `json:"prefix"`
+	Type      string `json:"ip-address-type"`
 }
 
 type LxcSummaryResponse struct {
-	Data   []LxcInfo  `json:"data"`
-	Errors []ApiError `json:"errors"`
+	Data    []LxcInfo      `json:"data"`
+	Errors  []ApiError     `json:"errors"`
+	Sources []ParentSource `json:"sources"`
 }
 
 type LxcInfo struct {
@@ -304,49 +242,111 @@ type LxcInfo struct {
 	DiskwriteMb int    `json:"diskWriteMb"`
 	MemoryMb    int    `json:"memMb"`
 	MaxMemoryMb int    `json:"maxMemMb"`
-}
-
-type LxcEntryObject struct {
-	Data []LxcEntryInfo `json:"data"`
-}
-
-type LxcEntryInfo struct {
-	Parent    string `json:"parent"`
-	Node      string `json:"node"`
-	Name      string `json:"name"`
-	Vmid      int    `json:"vmid"`
-	Status    string `json:"status"`
-	Tags      string `json:"tags"`
-	Uptime    int    `json:"uptime"`
-	Netout    int    `json:"netout"`
-	Netin     int    `json:"netin"`
-	Diskread  int    `json:"diskread"`
-	Diskwrite int    `json:"diskwrite"`
-	Memory    int    `json:"mem"`
-	MaxMemory int    `json:"maxmem"`
-}
-
-type hostStorageList struct {
-	Data []struct {
-		Storage   string `json:"storage"`
-		Active    int    `json:"active"`
-		Enabled   int    `json:"enabled"`
-		Shared    int    `json:"shared"`
-		Type      string `json:"type"`
-		Content   string `json:"content"`
-		Total     int    `json:"total"`
-		Used      int    `json:"used"`
-		Available int    `json:"avail"`
-	} `json:"data"`
+	// HAState is the current state of the container in the HA manager, empty
+	// when HA does not manage it
+	HAManaged bool   `json:"haManaged"`
+	HAState   string `json:"haState"`
+	// Only exported as metrics: the CPUs, the CPU usage, the uptime in
+	// seconds and the memory in bytes, before the conversion for the response
+	cpus          int
+	cpu           float64
+	uptimeSeconds int
+	memBytes      int
+	maxMemBytes   int
+}
+
+type HAResponse struct {
+	Data    []HAInfo       `json:"data"`
+	Errors  []ApiError     `json:"errors"`
+	Sources []ParentSource `json:"sources"`
+}
+
+// HAInfo is the HA stack of a parent. MasterNode is empty until an HA
+// resource is configured and a manager became active.
+type HAInfo struct {
+	Parent     string           `json:"parent"`
+	MasterNode string           `json:"masterNode"`
+	Nodes      []HANodeInfo     `json:"nodes"`
+	Resources  []HAResourceInfo `json:"resources"`
+	Groups     []HAGroupInfo    `json:"groups"`
+}
+
+// HANodeInfo is a node as the HA master sees it, with the mode and state of
+// its local resource manager (LRM).
+type HANodeInfo struct {
+	Node         string `json:"node"`
+	Status       string `json:"status"`
+	LRMMode      string `json:"lrmMode"`
+	LRMState     string `json:"lrmState"`
+	LRMTimestamp int64  `json:"lrmTimestamp"`
+}
+
+// HAResourceInfo is a guest managed by HA. RequestedState is the configured
+// state, CurrentState what the manager reports for it on Node.
+type HAResourceInfo struct {
+	SID            string `json:"sid"`
+	Type           string `json:"type"`
+	Vmid           int    `json:"vmid"`
+	Node           string `json:"node"`
+	RequestedState string `json:"requestedState"`
+	CurrentState   string `json:"currentState"`
+	Group          string `json:"group"`
+	MaxRestart     int    `json:"maxRestart"`
+	MaxRelocate    int    `json:"maxRelocate"`
+	Comment        string `json:"comment"`
+}
+
+// HAGroupInfo is an HA group with its nodes, highest priority first.
+type HAGroupInfo struct {
+	Group      string        `json:"group"`
+	Nodes      []HAGroupNode `json:"nodes"`
+	Restricted bool          `json:"restricted"`
+	NoFailback bool          `json:"noFailback"`
+	Comment    string        `json:"comment"`
+}
+
+type HAGroupNode struct {
+	Node     string `json:"node"`
+	Priority int    `json:"priority"`
+}
+
+type ClusterResourcesResponse struct {
+	Data    []ClusterResource `json:"data"`
+	Errors  []ApiError        `json:"errors"`
+	Sources []ParentSource    `json:"sources"`
+}
+
+// ClusterResource is a node, guest or storage of a parent, as listed by
+// /cluster/resources. The sizes are bytes - for storage, disk and maxDisk are
+// the used and total space. Guests on offline nodes have the status unknown.
+type ClusterResource struct {
+	Parent     string  `json:"parent"`
+	ID         string  `json:"id"`
+	Type       string  `json:"type"`
+	Node       string  `json:"node"`
+	Status     string  `json:"status"`
+	Name       string  `json:"name"`
+	Vmid       int     `json:"vmid"`
+	Tags       string  `json:"tags"`
+	Template   bool    `json:"template"`
+	HAState    string  `json:"haState"`
+	Cpus       int     `json:"cpus"`
+	Cpu        float64 `json:"cpu"`
+	Mem        int     `json:"mem"`
+	MaxMem     int     `json:"maxMem"`
+	Disk       int     `json:"disk"`
+	MaxDisk    int     `json:"maxDisk"`
+	Uptime     int     `json:"uptime"`
+	Storage    string  `json:"storage"`
+	PluginType string  `json:"pluginType"`
+	Content    string  `json:"content"`
+	Shared     bool    `json:"shared"`
 }
 
 type NodeStorageResponse struct {
-	Data   []NodeStorageInfo `json:"data"`
-	Errors []ApiError        `json:"errors"`
-}
-
-type NodeStorageObject struct {
-	Data []NodeStorageInfo `json:"data"`
+	Data    []NodeStorageInfo `json:"data"`
+	Errors  []ApiError        `json:"errors"`
+	Sources []ParentSource    `json:"sources"`
 }
 
 type NodeStorageInfo struct {
@@ -362,11 +362,16 @@ type NodeStorageInfo struct {
 	TotalGb     int    `json:"totalGb"`
 	UsedGb      int    `json:"usedGb"`
 	AvailableGb int    `json:"availableGb"`
+	// The sizes in bytes, before the conversion for the response
+	totalBytes     int
+	usedBytes      int
+	availableBytes int
 }
 
 type NodeDiskObject struct {
-	Data   []NodeDiskInfo `json:"data"`
-	Errors []ApiError     `json:"errors"`
+	Data    []NodeDiskInfo `json:"data"`
+	Errors  []ApiError     `json:"errors"`
+	Sources []ParentSource `json:"sources"`
 }
 
 type NodeDiskInfo struct {
@@ -383,27 +388,74 @@ type NodeDiskInfo struct {
 	SizeGb     int    `json:"sizeGb"`
 	Model      string `json:"model"`
 	Rpm        any    `json:"rpm"`
+	sizeBytes  int
+	// hasWearout is false when Proxmox reports no wearout for the disk
+	hasWearout bool
 }
 
-type hostDiskList struct {
-	Data []struct {
-		Vendor  string `json:"vendor"`
-		Gpt     int    `json:"gpt"`
-		Devpath string `json:"devpath"`
-		Health  string `json:"health"`
-		Type    string `json:"type"`
-		Wearout any    `json:"wearout"`
-		Serial  string `json:"serial"`
-		Used    string `json:"used"`
-		Model   string `json:"model"`
-		Size    int    `json:"size"`
-		Rpm     any    `json:"rpm"`
-	}
+type HealthResponse struct {
+	Status string `json:"status"`
 }
 
+// ReadinessResponse tells how many parents were reachable on their last
+// check, and why the API is not ready when it is not.
+type ReadinessResponse struct {
+	Status    string `json:"status"`
+	Policy    string `json:"policy"`
+	Parents   int    `json:"parents"`
+	Reachable int    `json:"reachable"`
+	Message   string `json:"message,omitempty"`
+	// ConfigError is why the config file was rejected on the last reload -
+	// the previous config stays in use meanwhile
+	ConfigError string `json:"configError,omitempty"`
+}
+
+type ParentHealthResponse struct {
+	Data   []ParentHealth `json:"data"`
+	Errors []ApiError     `json:"errors"`
+}
+
+// ParentHealth is the result of the last background check of a parent. The
+// times and the latency stay null until the parent was checked or answered,
+// and AuthValid stays null until the credentials could be tried.
+type ParentHealth struct {
+	Parent      string     `json:"parent"`
+	Reachable   bool       `json:"reachable"`
+	Endpoint    string     `json:"endpoint"`
+	LastCheck   *time.Time `json:"lastCheck"`
+	LastContact *time.Time `json:"lastContact"`
+	LatencyMs   *float64   `json:"latencyMs"`
+	PVEVersion  string     `json:"pveVersion"`
+	AuthValid   *bool      `json:"authValid"`
+}
+
+type ParentStatusResponse struct {
+	Data   []ParentStatus `json:"data"`
+	Errors []ApiError     `json:"errors"`
+}
+
+type ParentStatus struct {
+	Parent   string        `json:"parent"`
+	Endpoint string        `json:"endpoint"`
+	Breaker  BreakerStatus `json:"circuitBreaker"`
+}
+
+// ParentSource tells which endpoint answered for a parent. Cached is set
+// when the data comes from the background inventory, which was collected
+// SnapshotAge seconds ago.
+type ParentSource struct {
+	Parent      string `json:"parent"`
+	Endpoint    string `json:"endpoint"`
+	Cached      bool   `json:"cached"`
+	SnapshotAge int    `json:"snapshotAgeSeconds"`
+}
+
+// ApiError is a failed call to a parent. RequestID is the X-Request-ID of
+// the request that reported it, to find its logs.
 type ApiError struct {
-	Parent  string `json:"parent"`
-	Node    string `json:"node"`
-	Action  string `json:"action"`
-	Message string `json:"message"`
+	Parent    string `json:"parent"`
+	Node      string `json:"node"`
+	Action    string `json:"action"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId"`
 }
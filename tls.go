@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fingerprintMismatchError is returned when a parent presents a certificate
+// that does not match any of its pinned fingerprints.
+type fingerprintMismatchError struct {
+	got string
+}
+
+func (e *fingerprintMismatchError) Error() string {
+	return fmt.Sprintf("the certificate fingerprint %s does not match the pinned fingerprint", formatFingerprint(e.got))
+}
+
+// normalizeFingerprint accepts the colon separated form shown in the Proxmox
+// GUI as well as plain hex.
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fp), ":", ""))
+}
+
+func formatFingerprint(fp string) string {
+	var parts []string
+	for i := 0; i+2 <= len(fp); i += 2 {
+		parts = append(parts, strings.ToUpper(fp[i:i+2]))
+	}
+	return strings.Join(parts, ":")
+}
+
+// pinnedFingerprints parses the comma separated Fingerprint setting. Several
+// fingerprints can be pinned, as every node in a cluster has its own cert.
+func (p PVEConnectionObject) pinnedFingerprints() ([]string, error) {
+	var pins []string
+	for _, fp := range strings.Split(p.Fingerprint, ",") {
+		if strings.TrimSpace(fp) == "" {
+			continue
+		}
+		pin := normalizeFingerprint(fp)
+		if b, err := hex.DecodeString(pin); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("the fingerprint %q for %s is not a SHA-256 fingerprint", fp, p.Parent)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// buildTLSConfig returns the TLS settings for a parent. Without any settings
+// the certificate is verified against the system roots.
+func buildTLSConfig(host PVEConnectionObject) (*tls.Config, error) {
+	if host.Insecure {
+		if host.CAFile != "" || host.Fingerprint != "" {
+			return nil, fmt.Errorf("parent %s sets insecure together with caFile or fingerprint", host.Parent)
+		}
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if host.CAFile != "" {
+		pem, err := os.ReadFile(host.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA bundle for %s - %w", host.Parent, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("the CA bundle %s for %s contains no certificates", host.CAFile, host.Parent)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	pins, err := host.pinnedFingerprints()
+	if err != nil {
+		return nil, err
+	}
+	if len(pins) > 0 {
+		// A pinned certificate is trusted on its own, unless a CA bundle was
+		// given as well - then the chain is verified first.
+		tlsConfig.InsecureSkipVerify = host.CAFile == ""
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("the server presented no certificate")
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			got := hex.EncodeToString(sum[:])
+			for _, pin := range pins {
+				if got == pin {
+					return nil
+				}
+			}
+			return &fingerprintMismatchError{got: got}
+		}
+	}
+	return tlsConfig, nil
+}
+
+// isTLSError reports whether err was caused by certificate verification,
+// as opposed to the parent being unreachable.
+func isTLSError(err error) bool {
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		unknownErr   x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		pinErr       *fingerprintMismatchError
+		recordHdrErr tls.RecordHeaderError
+	)
+	return errors.As(err, &verifyErr) ||
+		errors.As(err, &unknownErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr) ||
+		errors.As(err, &pinErr) ||
+		errors.As(err, &recordHdrErr)
+}
+
+// errorAction returns the ApiError action for a failed upstream call, so
+// timeouts, certificate and credential problems can be told apart from
+// outages.
+func errorAction(action string, err error) string {
+	var authErr *authError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		action = "timeout"
+	case errors.Is(err, context.Canceled):
+		action = "cancelled"
+	case errors.As(err, new(*breakerOpenError)):
+		action = "circuitOpen"
+	case isTLSError(err):
+		action = "tlsVerify"
+	case errors.As(err, &authErr):
+		action = "authenticate"
+	}
+	return action
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// handshake dials srv with the TLS settings of host.
+func handshake(t *testing.T, srv *httptest.Server, host PVEConnectionObject) error {
+	t.Helper()
+	cfg, err := buildTLSConfig(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), cfg)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestTLSVerifiesAgainstTheCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+
+	if err := handshake(t, srv, PVEConnectionObject{Parent: "lab"}); err == nil || !isTLSError(err) {
+		t.Errorf("expected the self-signed certificate to fail against the system roots, got %v", err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "pve-root-ca.pem")
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, block, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, srv, PVEConnectionObject{Parent: "lab", CAFile: caFile}); err != nil {
+		t.Errorf("expected the certificate to verify against the bundle, got %v", err)
+	}
+
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildTLSConfig(PVEConnectionObject{Parent: "lab", CAFile: caFile}); err == nil {
+		t.Errorf("expected a bundle without certificates to be rejected")
+	}
+}
+
+func TestTLSPinsFingerprints(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+	sum := sha256.Sum256(srv.Certificate().Raw)
+
+	// The GUI form, next to the fingerprint of another node
+	other := formatFingerprint(hex.EncodeToString(make([]byte, sha256.Size)))
+	pinned := other + "," + formatFingerprint(hex.EncodeToString(sum[:]))
+	if err := handshake(t, srv, PVEConnectionObject{Parent: "lab", Fingerprint: pinned}); err != nil {
+		t.Errorf("expected the pinned certificate to be trusted, got %v", err)
+	}
+
+	err := handshake(t, srv, PVEConnectionObject{Parent: "lab", Fingerprint: other})
+	var mismatch *fingerprintMismatchError
+	if !errors.As(err, &mismatch) || !isTLSError(err) || errorAction("sendRequest", err) != "tlsVerify" {
+		t.Errorf("expected a fingerprint mismatch, got %v", err)
+	}
+
+	if _, err := buildTLSConfig(PVEConnectionObject{Parent: "lab", Fingerprint: "AB:CD"}); err == nil {
+		t.Errorf("expected a short fingerprint to be rejected")
+	}
+}
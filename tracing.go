@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracingExporterOTLP   = "otlp"
+	tracingExporterStdout = "stdout"
+	tracingExporterNone   = "none"
+
+	tracerName         = "proxmox-simple-api"
+	defaultServiceName = "proxmox-simple-api"
+)
+
+// TracingConfig enables OpenTelemetry tracing. Exporter is otlp, to send the
+// spans over OTLP/HTTP to Endpoint, or stdout; tracing is off when it is
+// empty or none. Without an Endpoint the OTEL_EXPORTER_OTLP_* variables
+// apply. SampleRatio is the share of new traces that are kept, 1 when unset.
+// The settings are read once at startup.
+type TracingConfig struct {
+	Exporter    string  `json:"exporter" yaml:"exporter"`
+	Endpoint    string  `json:"endpoint" yaml:"endpoint"`
+	ServiceName string  `json:"serviceName" yaml:"serviceName"`
+	SampleRatio float64 `json:"sampleRatio" yaml:"sampleRatio"`
+}
+
+func (t *TracingConfig) applyDefaults() {
+	if t.Exporter == tracingExporterNone {
+		t.Exporter = ""
+	}
+	if t.ServiceName == "" {
+		t.ServiceName = defaultServiceName
+	}
+	if t.SampleRatio == 0 {
+		t.SampleRatio = 1
+	}
+}
+
+func (t *TracingConfig) validate() error {
+	switch t.Exporter {
+	case "", tracingExporterOTLP, tracingExporterStdout:
+	default:
+		return fmt.Errorf("unknown tracing exporter %q - use %s, %s or %s", t.Exporter, tracingExporterOTLP, tracingExporterStdout, tracingExporterNone)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return fmt.Errorf("the tracing sample ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// setupTracing installs the global tracer provider for the configured
+// exporter. The stdout exporter writes to w. The returned function flushes
+// the spans that are still buffered and must be called before exiting.
+func setupTracing(ctx context.Context, t TracingConfig, w io.Writer) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	switch t.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case tracingExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	case tracingExporterOTLP:
+		var opts []otlptracehttp.Option
+		if t.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(t.Endpoint))
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(t.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", t.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	slog.Info("Tracing is enabled", "exporter", t.Exporter, "service", t.ServiceName, "sample_ratio", t.SampleRatio)
+	return provider.Shutdown, nil
+}
+
+// tracer returns the tracer of the API from the global provider, which is a
+// no-op unless tracing is enabled.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// traceRequest starts a span for every request, continuing the trace of the
+// caller when it sends a traceparent header. The trace ID is added to the
+// logs of the request.
+func traceRequest(c *gin.Context) {
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer().Start(ctx, c.Request.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", c.Request.URL.Path),
+			attribute.String("request.id", c.GetString(requestIDKey)),
+		),
+	)
+	defer span.End()
+	if sc := span.SpanContext(); sc.IsValid() {
+		ctx = withLogAttrs(ctx, slog.String("trace_id", sc.TraceID().String()))
+	}
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	for _, param := range []string{"parent", "id"} {
+		if v := c.Param(param); v != "" {
+			span.SetAttributes(attribute.String("pve."+logParamName(param), v))
+		}
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// startParentSpan starts the span that groups the calls to one parent
+// during a request or a background refresh.
+func startParentSpan(ctx context.Context, parent string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "parent", trace.WithAttributes(attribute.String("pve.parent", parent)))
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// upstreamSpanAttrs describes a call to a parent like upstreamLogAttrs, with
+// the node and the guest when the path names them.
+func upstreamSpanAttrs(parent, endpoint, method, path string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("pve.parent", parent),
+		attribute.String("pve.endpoint", endpoint),
+		attribute.String("http.request.method", method),
+		attribute.String("url.path", path),
+	}
+	logAttrs := upstreamLogAttrs(path)
+	for i := 2; i+1 < len(logAttrs); i += 2 {
+		attrs = append(attrs, attribute.String("pve."+logAttrs[i].(string), logAttrs[i+1].(string)))
+	}
+	return attrs
+}
+
+// upstreamRoute is the path of a call to a parent with the node and the
+// guest replaced by placeholders, so span names do not grow with the
+// inventory - e.g. /nodes/{node}/qemu/{vmid}/status/current.
+func upstreamRoute(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, apiPathPrefix+"/"), "/")
+	if len(parts) >= 2 && parts[0] == "nodes" {
+		parts[1] = "{node}"
+		if len(parts) >= 4 && (parts[2] == "qemu" || parts[2] == "lxc") {
+			if _, err := strconv.Atoi(parts[3]); err == nil {
+				parts[3] = "{vmid}"
+			}
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordSpans installs a tracer provider that keeps the ended spans in memory.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return recorder
+}
+
+// spanAttr returns the value of an attribute of span, or "".
+func spanAttr(span sdktrace.ReadOnlySpan, key string) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == attribute.Key(key) {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracesFollowTheFanOut(t *testing.T) {
+	_, router := newFakeParent(t)
+	recorder := recordSpans(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/infrastructure/nodes/detailed/lab/storage", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d - %s", rec.Code, rec.Body.String())
+	}
+
+	var server, parent sdktrace.ReadOnlySpan
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		byName[span.Name()] = append(byName[span.Name()], span)
+		switch span.Name() {
+		case "GET /api/v1/infrastructure/nodes/detailed/:parent/storage":
+			server = span
+		case "parent":
+			parent = span
+		}
+	}
+	if server == nil || parent == nil {
+		t.Fatalf("expected a span for the request and the parent, got %v", slices.Sorted(maps.Keys(byName)))
+	}
+	if parent.Parent().SpanID() != server.SpanContext().SpanID() || spanAttr(parent, "pve.parent") != "lab" {
+		t.Errorf("expected the span of lab under the request")
+	}
+	if got := spanAttr(server, "http.response.status_code"); got != "200" {
+		t.Errorf("expected the status on the request span, got %q", got)
+	}
+
+	probes := byName["testHostPort"]
+	if len(probes) == 0 || probes[0].Parent().SpanID() != parent.SpanContext().SpanID() || spanAttr(probes[0], "pve.endpoint") == "" {
+		t.Errorf("expected the endpoint check under the parent span, got %d spans", len(probes))
+	}
+	nodes := byName["GET /nodes"]
+	if len(nodes) != 1 || nodes[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("expected one call for the nodes under the parent span, got %d", len(nodes))
+	}
+	guests := byName["GET /nodes/{node}/storage"]
+	if len(guests) == 0 {
+		t.Fatalf("expected a call per node, got %v", slices.Sorted(maps.Keys(byName)))
+	}
+	for _, span := range guests {
+		if spanAttr(span, "pve.node") == "" || spanAttr(span, "pve.parent") != "lab" || spanAttr(span, "pve.endpoint") == "" {
+			t.Errorf("expected the parent, node and endpoint on %s, got %v", span.Name(), span.Attributes())
+		}
+		if span.Parent().TraceID() != server.SpanContext().TraceID() {
+			t.Errorf("expected %s in the trace of the request", span.Name())
+		}
+	}
+}
+
+func TestTracesContinueTheCallerTrace(t *testing.T) {
+	_, router := newFakeParent(t)
+	recorder := recordSpans(t)
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the request span in the trace of the caller")
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	var buf bytes.Buffer
+	cfg := TracingConfig{Exporter: tracingExporterStdout}
+	cfg.applyDefaults()
+	shutdown, err := setupTracing(context.Background(), cfg, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tracer().Start(context.Background(), "testHostPort")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"Name":"testHostPort"`) || !strings.Contains(buf.String(), defaultServiceName) {
+		t.Errorf("expected the span on stdout, got %s", buf.String())
+	}
+}